@@ -0,0 +1,245 @@
+package alertmanager
+
+// ==============================================================================
+// Receiver config, routes matching alert labels to email and SMS recipients,
+// and the templates used to render notifications
+// ==============================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Config is the receiver config, usually loaded from a JSON file with LoadConfig
+type Config struct {
+	From      string    `json:"from"`      // Email sender, required for email routes
+	SMSFrom   string    `json:"smsFrom"`   // Number SMS are sent from, required for SMS routes
+	Templates Templates `json:"templates"` // Override the default templates
+	Routes    []Route   `json:"routes"`
+}
+
+// Route sends alert groups with matching labels to recipients. Routes are tried in
+// order and the first match is used, unless it has Continue set
+type Route struct {
+	Name     string            `json:"name"`
+	Match    map[string]string `json:"match"`   // Labels which must equal these values
+	MatchRE  map[string]string `json:"matchRe"` // Labels which must match these regular expressions
+	Email    []string          `json:"email"`
+	SMS      []string          `json:"sms"`
+	Continue bool              `json:"continue"` // Keep trying later routes after this one matches
+
+	// Templates override the config templates for this route
+	Templates Templates `json:"templates"`
+}
+
+// Templates render notifications from a Message. Subject, Text and SMS use text/template,
+// HTML uses html/template. Empty templates fall back to the defaults, except HTML which
+// is only sent when set
+type Templates struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+	SMS     string `json:"sms"`
+}
+
+const defaultSubject = `[{{ .Status | upper }}{{ if eq .Status "firing" }}:{{ len .Firing }}{{ end }}] {{ .GroupLabels | labels }}`
+
+const defaultText = `{{ range .Firing }}FIRING since {{ .StartsAt | time }}
+{{ template "alert" . }}
+{{ end }}{{ range .Resolved }}RESOLVED at {{ .EndsAt | time }}
+{{ template "alert" . }}
+{{ end }}{{ if .TruncatedAlerts }}{{ .TruncatedAlerts }} more alerts were not included
+{{ end }}{{ with .ExternalURL }}Alertmanager: {{ . }}
+{{ end }}
+{{- define "alert" }}{{ range $k, $v := .Annotations }}  {{ $k }}: {{ $v }}
+{{ end }}  Labels: {{ .Labels | labels }}
+{{ with .GeneratorURL }}  Source: {{ . }}
+{{ end }}{{ end }}`
+
+const defaultSMS = `{{ .Status | upper }}{{ if eq .Status "firing" }}:{{ len .Firing }}{{ end }} {{ .GroupLabels | labels }}
+{{- range .Alerts }}
+{{ or .Annotations.summary .Labels.alertname }}{{ end }}`
+
+// Functions available in templates
+var templateFuncs = map[string]any{
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+	"join":   func(sep string, s []string) string { return strings.Join(s, sep) },
+	"labels": formatLabels,
+	"time":   func(t time.Time) string { return t.Format("02 Jan 2006 15:04 MST") },
+}
+
+type compiledTemplates struct {
+	subject *template.Template
+	text    *template.Template
+	sms     *template.Template
+	html    *htmltemplate.Template
+}
+
+type compiledRoute struct {
+	Route
+	matchRE   map[string]*regexp.Regexp
+	templates compiledTemplates
+}
+
+// LoadConfig reads a JSON config file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %s", err)
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %s", path, err)
+	}
+
+	return cfg, nil
+}
+
+// compile checks the config, and compiles the routes and templates
+func (cfg *Config) compile() ([]*compiledRoute, error) {
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("config has no routes")
+	}
+
+	routes := []*compiledRoute{}
+
+	for i, r := range cfg.Routes {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("route %d", i+1)
+		}
+
+		if len(r.Email) == 0 && len(r.SMS) == 0 {
+			return nil, fmt.Errorf("%s has no email or SMS recipients", r.Name)
+		}
+
+		if len(r.Email) > 0 && cfg.From == "" {
+			return nil, fmt.Errorf("%s sends email, so from must be set", r.Name)
+		}
+
+		if len(r.SMS) > 0 && cfg.SMSFrom == "" {
+			return nil, fmt.Errorf("%s sends SMS, so smsFrom must be set", r.Name)
+		}
+
+		cr := &compiledRoute{Route: r, matchRE: map[string]*regexp.Regexp{}}
+
+		// Anchored, the same as Alertmanager
+		for label, expr := range r.MatchRE {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%s has an invalid regular expression for %s: %s", r.Name, label, err)
+			}
+
+			cr.matchRE[label] = re
+		}
+
+		var err error
+
+		cr.templates, err = compileTemplates(merge(cfg.Templates, r.Templates))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", r.Name, err)
+		}
+
+		routes = append(routes, cr)
+	}
+
+	return routes, nil
+}
+
+// matches is true if the labels satisfy the route, a route with no matchers matches everything
+func (r *compiledRoute) matches(labels map[string]string) bool {
+	for label, value := range r.Match {
+		if labels[label] != value {
+			return false
+		}
+	}
+
+	for label, re := range r.matchRE {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// merge overrides the base templates with any set in t
+func merge(base, t Templates) Templates {
+	if t.Subject != "" {
+		base.Subject = t.Subject
+	}
+
+	if t.Text != "" {
+		base.Text = t.Text
+	}
+
+	if t.HTML != "" {
+		base.HTML = t.HTML
+	}
+
+	if t.SMS != "" {
+		base.SMS = t.SMS
+	}
+
+	return base
+}
+
+func compileTemplates(t Templates) (compiledTemplates, error) {
+	ct := compiledTemplates{}
+
+	var err error
+
+	if ct.subject, err = parse("subject", t.Subject, defaultSubject); err != nil {
+		return ct, err
+	}
+
+	if ct.text, err = parse("text", t.Text, defaultText); err != nil {
+		return ct, err
+	}
+
+	if ct.sms, err = parse("sms", t.SMS, defaultSMS); err != nil {
+		return ct, err
+	}
+
+	if t.HTML != "" {
+		if ct.html, err = htmltemplate.New("html").Funcs(templateFuncs).Option("missingkey=zero").Parse(t.HTML); err != nil {
+			return ct, fmt.Errorf("error parsing html template: %s", err)
+		}
+	}
+
+	return ct, nil
+}
+
+func parse(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s template: %s", name, err)
+	}
+
+	return t, nil
+}
+
+// formatLabels formats labels as name=value pairs, sorted by name
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ", ")
+}
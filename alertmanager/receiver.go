@@ -0,0 +1,399 @@
+package alertmanager
+
+// ==============================================================================
+// Prometheus Alertmanager webhook receiver, notifies on-call by email and SMS
+// through ACS. Alert groups are routed by label, rendered with templates, and
+// repeats of a group Alertmanager has already notified are deduplicated
+// ==============================================================================
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+// Alert statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Groups can hold many alerts, allow more than a typical request
+const maxBodySize = 10 << 20
+
+const defaultDedupWindow = time.Hour
+
+// ErrNoRoute is reported through OnResult when no route matches an alert group
+var ErrNoRoute = errors.New("no route matches")
+
+// Message is the payload Alertmanager sends to webhooks, a group of alerts
+type Message struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Alert is a single alert in a group
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// Firing returns the alerts in the group which are firing
+func (m *Message) Firing() []Alert {
+	return m.withStatus(StatusFiring)
+}
+
+// Resolved returns the alerts in the group which have resolved
+func (m *Message) Resolved() []Alert {
+	return m.withStatus(StatusResolved)
+}
+
+func (m *Message) withStatus(status string) []Alert {
+	alerts := []Alert{}
+
+	for _, a := range m.Alerts {
+		if a.Status == status {
+			alerts = append(alerts, a)
+		}
+	}
+
+	return alerts
+}
+
+// state identifies the group and the status of its alerts, repeat notifications have the same state
+func (m *Message) state() string {
+	alerts := make([]string, 0, len(m.Alerts))
+
+	for _, a := range m.Alerts {
+		id := a.Fingerprint
+		if id == "" {
+			id = formatLabels(a.Labels)
+		}
+
+		alerts = append(alerts, id+":"+a.Status)
+	}
+
+	sort.Strings(alerts)
+
+	hash := sha256.Sum256([]byte(m.GroupKey + "\n" + m.Status + "\n" + strings.Join(alerts, "\n")))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// Options for the receiver
+type Options struct {
+	// DedupWindow is how long a notification is remembered, repeats of the same group and
+	// alert statuses in this time aren't sent again. Defaults to one hour
+	DedupWindow time.Duration
+
+	// Credentials Alertmanager must send, as set in the webhook's http_config. When
+	// BearerToken or Username is set, requests without either are rejected
+	BearerToken string
+	Username    string
+	Password    string
+
+	// OnResult is called for each notification sent, skipped or failed, optional
+	OnResult func(r Result)
+}
+
+// Result is the outcome of notifying a route's email list or a phone number
+type Result struct {
+	GroupKey  string
+	Status    string
+	Route     string
+	Recipient string // The email list or phone number
+	MessageID string
+	Duplicate bool // Already sent within the dedup window, so skipped
+	Err       error
+}
+
+// Receiver is a http.Handler accepting Alertmanager webhook requests
+type Receiver struct {
+	client *client.Client
+	config *Config
+	routes []*compiledRoute
+	opts   Options
+
+	mu   sync.Mutex
+	sent map[string]time.Time // Dedup keys and when they were sent
+}
+
+// New creates a receiver, checking the config and compiling its templates
+func New(acsClient *client.Client, config *Config, opts Options) (*Receiver, error) {
+	routes, err := config.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DedupWindow <= 0 {
+		opts.DedupWindow = defaultDedupWindow
+	}
+
+	return &Receiver{
+		client: acsClient,
+		config: config,
+		routes: routes,
+		opts:   opts,
+		sent:   map[string]time.Time{},
+	}, nil
+}
+
+// ServeHTTP handles a webhook request, responding with an error if any notification failed
+// so Alertmanager retries. Recipients already notified are deduplicated on the retry
+func (rcv *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if !rcv.authorised(r) {
+		http.Error(w, "unauthorised", http.StatusUnauthorized)
+
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+
+		return
+	}
+
+	msg := &Message{}
+	if err := json.Unmarshal(body, msg); err != nil || msg.GroupKey == "" {
+		http.Error(w, "body must be an Alertmanager webhook message", http.StatusBadRequest)
+
+		return
+	}
+
+	if err := rcv.Notify(r.Context(), msg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorised checks the request has the bearer token or basic auth credentials, when either is set
+func (rcv *Receiver) authorised(r *http.Request) bool {
+	if rcv.opts.BearerToken == "" && rcv.opts.Username == "" {
+		return true
+	}
+
+	equal := func(a, b string) bool {
+		return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	}
+
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found && rcv.opts.BearerToken != "" {
+		return equal(token, rcv.opts.BearerToken)
+	}
+
+	if user, password, found := r.BasicAuth(); found && rcv.opts.Username != "" {
+		return equal(user, rcv.opts.Username) && equal(password, rcv.opts.Password)
+	}
+
+	return false
+}
+
+// Notify routes and sends notifications for an alert group, returning an error if any failed.
+// A group no route matches is reported through OnResult with ErrNoRoute, but isn't an error,
+// as Alertmanager retrying wouldn't help
+func (rcv *Receiver) Notify(ctx context.Context, msg *Message) error {
+	state := msg.state()
+	failed := 0
+	routed := false
+
+	// Numbers in more than one matching route are only sent one SMS
+	texted := map[string]bool{}
+
+	for _, route := range rcv.routes {
+		if !route.matches(msg.CommonLabels) {
+			continue
+		}
+
+		routed = true
+
+		if len(route.Email) > 0 {
+			r := rcv.send(state+"\nemail\n"+route.Name, msg, route, strings.Join(route.Email, ", "), func() (string, error) {
+				return rcv.sendEmail(ctx, msg, route)
+			})
+
+			if r.Err != nil {
+				failed++
+			}
+		}
+
+		for _, number := range route.SMS {
+			if texted[number] {
+				continue
+			}
+
+			texted[number] = true
+
+			r := rcv.send(state+"\nsms\n"+number, msg, route, number, func() (string, error) {
+				return rcv.sendSMS(ctx, msg, route, number)
+			})
+
+			if r.Err != nil {
+				failed++
+			}
+		}
+
+		if !route.Continue {
+			break
+		}
+	}
+
+	if !routed && rcv.opts.OnResult != nil {
+		rcv.opts.OnResult(Result{
+			GroupKey: msg.GroupKey,
+			Status:   msg.Status,
+			Err:      fmt.Errorf("%w the labels %s", ErrNoRoute, formatLabels(msg.CommonLabels)),
+		})
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d notifications failed", failed)
+	}
+
+	return nil
+}
+
+// send calls fn unless the key was sent within the dedup window, and reports the result
+func (rcv *Receiver) send(key string, msg *Message, route *compiledRoute, recipient string, fn func() (string, error)) Result {
+	result := Result{GroupKey: msg.GroupKey, Status: msg.Status, Route: route.Name, Recipient: recipient}
+
+	// Claimed before sending, so concurrent requests from Alertmanager replicas don't both send
+	if !rcv.claim(key) {
+		result.Duplicate = true
+	} else {
+		result.MessageID, result.Err = fn()
+		if result.Err != nil {
+			rcv.release(key)
+		}
+	}
+
+	if rcv.opts.OnResult != nil {
+		rcv.opts.OnResult(result)
+	}
+
+	return result
+}
+
+// claim records the key as sent, returning false if it already was within the dedup window
+func (rcv *Receiver) claim(key string) bool {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	// Forget old keys as we go, so memory doesn't grow
+	for k, sent := range rcv.sent {
+		if time.Since(sent) > rcv.opts.DedupWindow {
+			delete(rcv.sent, k)
+		}
+	}
+
+	if _, found := rcv.sent[key]; found {
+		return false
+	}
+
+	rcv.sent[key] = time.Now()
+
+	return true
+}
+
+// release forgets a key when sending failed, so a retry can send it
+func (rcv *Receiver) release(key string) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	delete(rcv.sent, key)
+}
+
+func (rcv *Receiver) sendEmail(ctx context.Context, msg *Message, route *compiledRoute) (string, error) {
+	subject, err := render(route.templates.subject, msg)
+	if err != nil {
+		return "", err
+	}
+
+	text, err := render(route.templates.text, msg)
+	if err != nil {
+		return "", err
+	}
+
+	e := client.NewPlainEmail(rcv.config.From, route.Email[0], strings.TrimSpace(subject), text)
+	for _, to := range route.Email[1:] {
+		e.Recipients.To = append(e.Recipients.To, client.Address{Email: to, DisplayName: to})
+	}
+
+	if route.templates.html != nil {
+		if e.Content.HTML, err = render(route.templates.html, msg); err != nil {
+			return "", err
+		}
+	}
+
+	if msg.Status == StatusFiring {
+		e.Importance = client.ImportanceHigh
+	}
+
+	return rcv.client.SendEmailContext(ctx, e)
+}
+
+func (rcv *Receiver) sendSMS(ctx context.Context, msg *Message, route *compiledRoute, number string) (string, error) {
+	text, err := render(route.templates.sms, msg)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := rcv.client.SendSingleSMSContext(ctx, client.NewSMS(rcv.config.SMSFrom, number, strings.TrimSpace(text)))
+	if err != nil {
+		return "", err
+	}
+
+	if err := resp.Err(); err != nil {
+		return "", err
+	}
+
+	return resp.MessageID, nil
+}
+
+// executor is a text or html template
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+func render(t executor, msg *Message) (string, error) {
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, msg); err != nil {
+		return "", fmt.Errorf("error rendering template: %s", err)
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,216 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+	"github.com/benc-uk/go-acs-client/devmail"
+)
+
+const testConfig = `{
+  "from": "alerts@blah.net",
+  "smsFrom": "+15550000000",
+  "routes": [
+    {
+      "name": "critical",
+      "match": { "severity": "critical" },
+      "email": ["oncall@example.net", "lead@example.net"],
+      "sms": ["+15550000001"],
+      "continue": true,
+      "templates": { "subject": "{{ .CommonLabels.alertname }} is {{ .Status }}" }
+    },
+    {
+      "name": "storage",
+      "matchRe": { "team": "db|storage" },
+      "sms": ["+15550000001", "+15550000002"]
+    },
+    {
+      "name": "default",
+      "email": ["ops@example.net"]
+    }
+  ]
+}`
+
+func message(status string, labels map[string]string) *Message {
+	alertStatus := status
+	if alertStatus == "" {
+		alertStatus = StatusFiring
+	}
+
+	return &Message{
+		Version:      "4",
+		GroupKey:     `{}:{alertname="DiskFull"}`,
+		Status:       alertStatus,
+		GroupLabels:  map[string]string{"alertname": "DiskFull"},
+		CommonLabels: labels,
+		Alerts: []Alert{{
+			Status:      alertStatus,
+			Labels:      labels,
+			Annotations: map[string]string{"summary": "Disk /data is 98% full"},
+			StartsAt:    time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			Fingerprint: "abc123",
+		}},
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	store := devmail.NewMemoryStore()
+	acs := httptest.NewServer(devmail.New(store))
+	defer acs.Close()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	_ = os.WriteFile(path, []byte(testConfig), 0o600)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []Result{}
+	acsClient := client.New(base64.StdEncoding.EncodeToString([]byte("dev")), acs.URL)
+
+	rcv, err := New(acsClient, cfg, Options{OnResult: func(r Result) { results = append(results, r) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	labels := map[string]string{"alertname": "DiskFull", "severity": "critical", "team": "db"}
+
+	if err := rcv.Notify(ctx, message(StatusFiring, labels)); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ := store.List(ctx)
+	if len(messages) != 3 {
+		t.Fatalf("expected an email and two SMS, got %d messages", len(messages))
+	}
+
+	for _, m := range messages {
+		if m.Kind == devmail.KindSMS && !strings.Contains(m.SMS.Message, "Disk /data is 98% full") {
+			t.Errorf("unexpected SMS %q", m.SMS.Message)
+		}
+
+		if m.Kind == devmail.KindEmail {
+			if m.Email.Content.Subject != "DiskFull is firing" || len(m.Email.Recipients.To) != 2 {
+				t.Errorf("unexpected email %q to %v", m.Email.Content.Subject, m.Email.Recipients.To)
+			}
+
+			if !strings.Contains(m.Email.Content.PlainText, "FIRING since 01 May 2024 10:00 UTC") {
+				t.Errorf("unexpected email body\n%s", m.Email.Content.PlainText)
+			}
+		}
+	}
+
+	// Repeat notifications from Alertmanager are skipped
+	results = nil
+	_ = rcv.Notify(ctx, message(StatusFiring, labels))
+
+	if messages, _ = store.List(ctx); len(messages) != 3 || len(results) != 3 || !results[0].Duplicate {
+		t.Errorf("expected repeats to be deduplicated, got %d messages, %+v", len(messages), results)
+	}
+
+	// When the group resolves that's sent, via the default route here
+	_ = rcv.Notify(ctx, message(StatusResolved, map[string]string{"alertname": "DiskFull"}))
+
+	messages, _ = store.List(ctx)
+	if len(messages) != 4 || messages[0].Email == nil || messages[0].Email.Content.Subject != "[RESOLVED] alertname=DiskFull" {
+		t.Errorf("expected a resolved email to the default route, got %+v", messages[0])
+	}
+}
+
+func TestReceiverHTTP(t *testing.T) {
+	acs := httptest.NewServer(devmail.New(devmail.NewMemoryStore()))
+	defer acs.Close()
+
+	cfg := &Config{SMSFrom: "+15550000000", Routes: []Route{{Match: map[string]string{"team": "web"}, SMS: []string{"+15550000001"}}}}
+
+	results := make(chan Result, 10)
+
+	rcv, err := New(client.New(base64.StdEncoding.EncodeToString([]byte("dev")), acs.URL), cfg, Options{
+		BearerToken: "s3cret",
+		Username:    "alertmanager",
+		Password:    "pa55",
+		OnResult:    func(r Result) { results <- r },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	web := `{"groupKey":"a","status":"firing","commonLabels":{"team":"web"},"alerts":[{"status":"firing"}]}`
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }
+
+	tests := []struct {
+		name string
+		body string
+		auth func(r *http.Request)
+		want int
+	}{
+		{"no credentials", web, func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong token", web, func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"wrong password", web, func(r *http.Request) { r.SetBasicAuth("alertmanager", "nope") }, http.StatusUnauthorized},
+		{"bearer token", web, bearer, http.StatusOK},
+		{"basic auth", web, func(r *http.Request) { r.SetBasicAuth("alertmanager", "pa55") }, http.StatusOK},
+		// Retrying wouldn't help when no route matches, so it's not an error to Alertmanager
+		{"no route", `{"groupKey":"b","status":"firing","commonLabels":{"team":"db"},"alerts":[{"status":"firing"}]}`, bearer, http.StatusOK},
+		{"not json", `not json`, bearer, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(tc.body))
+		tc.auth(req)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, resp.StatusCode)
+		}
+	}
+
+	close(results)
+
+	noRoute := 0
+
+	for r := range results {
+		if errors.Is(r.Err, ErrNoRoute) && r.GroupKey == "b" {
+			noRoute++
+		}
+	}
+
+	if noRoute != 1 {
+		t.Errorf("expected the group with no route to be reported once, got %d", noRoute)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	configs := map[string]*Config{
+		"no routes":     {},
+		"no recipients": {Routes: []Route{{Name: "empty"}}},
+		"no from":       {Routes: []Route{{Email: []string{"ops@example.net"}}}},
+		"bad regexp":    {SMSFrom: "+15550000000", Routes: []Route{{SMS: []string{"+1"}, MatchRE: map[string]string{"a": "("}}}},
+		"bad template":  {SMSFrom: "+15550000000", Routes: []Route{{SMS: []string{"+1"}, Templates: Templates{SMS: "{{ .Nope"}}}},
+	}
+
+	for name, cfg := range configs {
+		if _, err := New(&client.Client{}, cfg, Options{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
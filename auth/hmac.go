@@ -9,6 +9,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -47,8 +48,7 @@ func SignRequestHMAC(secret string, req *http.Request) error {
 
 	timestamp := time.Now().UTC().Format(http.TimeFormat)
 	contentHash := GetContentHashBase64(content)
-	stringToSign := fmt.Sprintf("%s\n%s\n%s;%s;%s", strings.ToUpper(method), pathAndQuery, timestamp, host, contentHash)
-	signature := GetHmac(stringToSign, key)
+	signature := GetHmac(stringToSign(method, pathAndQuery, timestamp, host, contentHash), key)
 
 	req.Header.Set("x-ms-content-sha256", contentHash)
 	req.Header.Set("x-ms-date", timestamp)
@@ -58,6 +58,71 @@ func SignRequestHMAC(secret string, req *http.Request) error {
 	return nil
 }
 
+// VerifyRequestHMAC checks a HTTP request was signed by SignRequestHMAC with the secret,
+// and that it was signed within maxSkew of now. The body is read and replaced
+func VerifyRequestHMAC(secret string, req *http.Request, maxSkew time.Duration) error {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return fmt.Errorf("error decoding secret: %s", err)
+	}
+
+	const prefix = "HMAC-SHA256 SignedHeaders=x-ms-date;host;x-ms-content-sha256&Signature="
+
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, prefix) {
+		return errors.New("missing HMAC-SHA256 authorization")
+	}
+
+	timestamp := req.Header.Get("x-ms-date")
+
+	signed, err := http.ParseTime(timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid x-ms-date: %s", err)
+	}
+
+	if skew := time.Since(signed); skew > maxSkew || skew < -maxSkew {
+		return errors.New("request was signed too long ago")
+	}
+
+	content := []byte{}
+
+	if req.Body != nil {
+		content, err = io.ReadAll(req.Body)
+		if err != nil {
+			return fmt.Errorf("error reading body: %s", err)
+		}
+	}
+
+	req.Body = io.NopCloser(bytes.NewBuffer(content))
+
+	contentHash := GetContentHashBase64(content)
+	if req.Header.Get("x-ms-content-sha256") != contentHash {
+		return errors.New("content hash does not match the body")
+	}
+
+	pathAndQuery := req.URL.Path
+	if req.URL.RawQuery != "" {
+		pathAndQuery = pathAndQuery + "?" + req.URL.RawQuery
+	}
+
+	// Servers see the host in req.Host, clients in req.URL.Host
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	expected := GetHmac(stringToSign(req.Method, pathAndQuery, timestamp, host, contentHash), key)
+	if !hmac.Equal([]byte(expected), []byte(strings.TrimPrefix(authorization, prefix))) {
+		return errors.New("signature does not match")
+	}
+
+	return nil
+}
+
+func stringToSign(method, pathAndQuery, timestamp, host, contentHash string) string {
+	return fmt.Sprintf("%s\n%s\n%s;%s;%s", strings.ToUpper(method), pathAndQuery, timestamp, host, contentHash)
+}
+
 // Hash content with SHA256 and return the hash in base64
 func GetContentHashBase64(content []byte) string {
 	hasher := sha256.New()
@@ -0,0 +1,82 @@
+package client
+
+// ==============================================================================
+// Archiving of sent emails, the MIME of each email is written after it has
+// been accepted by ACS, keyed by the message ID
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveOptions configures archiving of sent emails, see WithArchive
+type ArchiveOptions struct {
+	// Open returns where to write the MIME of a sent email, see ArchiveDir
+	Open func(ctx context.Context, messageID string) (io.WriteCloser, error)
+
+	// OnError is called when an email was sent but couldn't be archived
+	OnError func(messageID string, err error)
+}
+
+// WithArchive writes the MIME of every email accepted by ACS. Archiving happens
+// before the send returns, but failures are passed to OnError as the email was sent
+func WithArchive(opts ArchiveOptions) Option {
+	return func(c *Client) {
+		c.archive = &opts
+	}
+}
+
+// ArchiveDir archives each email to <dir>/<message ID>.eml, it's an error when ACS didn't return a message ID
+func ArchiveDir(dir string) func(ctx context.Context, messageID string) (io.WriteCloser, error) {
+	return func(ctx context.Context, messageID string) (io.WriteCloser, error) {
+		// Every email without an ID would be written to the same file, replacing the last
+		if messageID == "" {
+			return nil, errors.New("no message ID to name the archive file")
+		}
+
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, err
+		}
+
+		// Message IDs are GUIDs, but don't let one escape the directory
+		name := strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(messageID)
+
+		return os.Create(filepath.Join(dir, name+".eml"))
+	}
+}
+
+// archiveEmail writes the sent email to the archive, if configured
+func (c *Client) archiveEmail(ctx context.Context, messageID string, e *Email) {
+	if c.archive == nil || c.archive.Open == nil {
+		return
+	}
+
+	err := c.writeArchive(ctx, messageID, e)
+	if err != nil && c.archive.OnError != nil {
+		c.archive.OnError(messageID, err)
+	}
+}
+
+func (c *Client) writeArchive(ctx context.Context, messageID string, e *Email) error {
+	w, err := c.archive.Open(ctx, messageID)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %s", err)
+	}
+
+	err = e.WriteMIME(w)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("error archiving email: %s", err)
+	}
+
+	return nil
+}
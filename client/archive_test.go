@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	dir := t.TempDir()
+	WithArchive(ArchiveOptions{Open: ArchiveDir(dir)})(acsClient)
+	WithDefaults(Defaults{From: fromAddress, FromName: "Blah"})(acsClient)
+
+	if _, err := acsClient.SendEmail(NewHTMLEmail(fromAddress, toAddress, subject, "<b>Hello</b>")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "msg-id.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive has the generated plain text, as it was sent, and the default sender's display name
+	e, _, err := EmailFromMIME(strings.NewReader(string(data)))
+	if err != nil || e.Content.HTML != "<b>Hello</b>" || e.Content.PlainText == "" || e.SenderName != "Blah" {
+		t.Errorf("unexpected archived email %+v, %v", e, err)
+	}
+}
+
+func TestArchiveError(t *testing.T) {
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	failed := ""
+
+	WithArchive(ArchiveOptions{
+		Open: func(ctx context.Context, messageID string) (io.WriteCloser, error) {
+			return nil, errors.New("disk full")
+		},
+		OnError: func(messageID string, err error) {
+			failed = messageID
+		},
+	})(acsClient)
+
+	// The email was sent, so the send still succeeds
+	if _, err := acsClient.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if failed != "msg-id" {
+		t.Error("expected OnError to be called")
+	}
+}
+
+func TestArchiveNoMessageID(t *testing.T) {
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	var archiveErr error
+
+	dir := t.TempDir()
+	WithArchive(ArchiveOptions{
+		Open:    ArchiveDir(dir),
+		OnError: func(messageID string, err error) { archiveErr = err },
+	})(acsClient)
+
+	if _, err := acsClient.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := os.ReadDir(dir); archiveErr == nil || len(files) != 0 {
+		t.Errorf("expected an error and no file without a message ID, got %v and %d files", archiveErr, len(files))
+	}
+}
@@ -0,0 +1,220 @@
+package client
+
+// ==============================================================================
+// Sending of many individual emails, with a bounded pool of workers,
+// client side rate limiting and retries
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultBatchConcurrency = 4
+const defaultBatchRetryDelay = time.Second
+
+// ErrBatchStopped is the error for emails not sent, as the batch stopped early
+var ErrBatchStopped = errors.New("batch stopped before email was sent")
+
+// BatchOptions controls how SendEmailBatch sends emails
+type BatchOptions struct {
+	Concurrency int           // Number of emails sent in parallel, defaults to 4
+	RateLimit   float64       // Maximum emails sent per second, zero for no limit
+	MaxAttempts int           // Attempts per email when errors are retryable, defaults to 1
+	RetryDelay  time.Duration // Delay before the first retry, doubled for each retry, defaults to 1s
+	StopOnError bool          // Stop sending when any email fails, otherwise continue
+
+	// Progress is called each time an email is sent or fails, and for each email not sent as
+	// the batch stopped, calls are never concurrent
+	Progress func(p BatchProgress)
+}
+
+// BatchResult is the outcome of sending a single email in a batch
+type BatchResult struct {
+	Index     int    // Position of the email in the batch
+	MessageID string // Message ID, if sent successfully
+	Err       error  // Error, if the email was not sent
+	Attempts  int    // Number of times sending was attempted
+
+	// RepeatabilityResult shows if ACS had already processed the email, e.g. when a batch is re-run
+	RepeatabilityResult RepeatabilityResult
+}
+
+// BatchProgress is passed to the progress callback
+type BatchProgress struct {
+	Total     int
+	Completed int
+	Failed    int
+	Result    BatchResult // Result of the email which just completed
+}
+
+// SendEmailBatch sends each email individually, returning a result for every email
+// in the same order. When StopOnError is set, the first error is also returned
+func (c *Client) SendEmailBatch(ctx context.Context, emails []*Email, opts BatchOptions) ([]BatchResult, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = defaultBatchConcurrency
+	}
+
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultBatchRetryDelay
+	}
+
+	var limiter *tokenBucket
+	if opts.RateLimit > 0 {
+		limiter = newTokenBucket(opts.RateLimit, 1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]BatchResult, len(emails))
+	jobs := make(chan int)
+	progress := BatchProgress{Total: len(emails)}
+	progressMu := sync.Mutex{}
+
+	var firstErr error
+
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				result := c.sendBatchItem(ctx, i, emails[i], limiter, opts)
+				results[i] = result
+
+				progressMu.Lock()
+
+				progress.Completed++
+				progress.Result = result
+
+				if result.Err != nil {
+					progress.Failed++
+
+					if opts.StopOnError && firstErr == nil {
+						firstErr = fmt.Errorf("email %d failed: %w", i, result.Err)
+
+						cancel()
+					}
+				}
+
+				if opts.Progress != nil {
+					opts.Progress(progress)
+				}
+
+				progressMu.Unlock()
+			}
+		}()
+	}
+
+	queued := 0
+
+queue:
+	for i := range emails {
+		select {
+		case jobs <- i:
+			queued++
+		case <-ctx.Done():
+			break queue
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	for i := queued; i < len(emails); i++ {
+		results[i] = BatchResult{Index: i, Err: ErrBatchStopped}
+
+		progress.Completed++
+		progress.Failed++
+		progress.Result = results[i]
+
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	if firstErr != nil {
+		return results, firstErr
+	}
+
+	return results, ctx.Err()
+}
+
+// sendBatchItem sends one email, retrying retryable errors with exponential backoff
+func (c *Client) sendBatchItem(ctx context.Context, index int, e *Email, limiter *tokenBucket, opts BatchOptions) BatchResult {
+	result := BatchResult{Index: index}
+	delay := opts.RetryDelay
+
+	if ctx.Err() != nil {
+		result.Err = ErrBatchStopped
+
+		return result
+	}
+
+	// Retries must reuse the same repeatability headers, or ACS could deliver the email twice
+	if e.IdempotencyKey == "" && e.RepeatabilityRequestID == "" {
+		retryable := *e
+		retryable.RepeatabilityRequestID = uuid.New().String()
+		retryable.RepeatabilityFirstSent = time.Now().UTC().Format(http.TimeFormat)
+		e = &retryable
+	}
+
+	for result.Attempts < opts.MaxAttempts {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				if result.Attempts == 0 {
+					err = ErrBatchStopped
+				}
+
+				result.Err = err
+
+				return result
+			}
+		}
+
+		result.Attempts++
+
+		var sent *SendEmailResult
+
+		sent, result.Err = c.SendEmailWithResult(WithAttempt(ctx, result.Attempts), e)
+		if result.Err == nil {
+			result.MessageID = sent.MessageID
+			result.RepeatabilityResult = sent.RepeatabilityResult
+		}
+
+		if result.Err == nil || !IsRetryable(result.Err) || result.Attempts >= opts.MaxAttempts {
+			return result
+		}
+
+		wait := delay
+
+		apiErr := &APIError{}
+		if errors.As(result.Err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return result
+		}
+
+		delay *= 2
+	}
+
+	return result
+}
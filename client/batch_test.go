@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFakeAPI starts a server standing in for ACS, handler is called for every request
+func newFakeAPI(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return New(base64.StdEncoding.EncodeToString([]byte("secret")), srv.URL)
+}
+
+func TestSendEmailBatch(t *testing.T) {
+	mu := sync.Mutex{}
+	throttled := map[string]bool{}
+
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		e := Email{}
+		_ = json.NewDecoder(r.Body).Decode(&e)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first attempt of every third email is throttled, retries use the same request ID
+		requestID := r.Header.Get("repeatability-request-id")
+		if strings.HasSuffix(e.Content.Subject, "throttle") && !throttled[requestID] {
+			throttled[requestID] = true
+
+			w.WriteHeader(http.StatusTooManyRequests)
+
+			return
+		}
+
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	emails := []*Email{}
+	for i := 0; i < 10; i++ {
+		s := subject
+		if i%3 == 2 {
+			s += " throttle"
+		}
+
+		emails = append(emails, NewPlainEmail(fromAddress, toAddress, s, "Hello"))
+	}
+
+	progressCalls := 0
+	results, err := client.SendEmailBatch(context.Background(), emails, BatchOptions{
+		Concurrency: 3,
+		MaxAttempts: 3,
+		RetryDelay:  time.Millisecond,
+		Progress: func(p BatchProgress) {
+			progressCalls++
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if progressCalls != len(emails) {
+		t.Errorf("Expected %d progress calls, got %d", len(emails), progressCalls)
+	}
+
+	for i, r := range results {
+		attempts := 1
+		if i%3 == 2 {
+			attempts = 2
+		}
+
+		if r.Index != i || r.Err != nil || r.MessageID != "msg-id" || r.Attempts != attempts {
+			t.Errorf("Unexpected result: %+v", r)
+		}
+	}
+
+	if len(throttled) != 3 {
+		t.Errorf("Expected 3 throttled emails, got %d", len(throttled))
+	}
+}
+
+func TestSendEmailBatchStopOnError(t *testing.T) {
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"code":"BadRequest","message":"Nope"}}`))
+	})
+
+	emails := []*Email{}
+	for i := 0; i < 5; i++ {
+		emails = append(emails, NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	}
+
+	progressCalls := 0
+	results, err := client.SendEmailBatch(context.Background(), emails, BatchOptions{
+		Concurrency: 1,
+		StopOnError: true,
+		Progress: func(p BatchProgress) {
+			progressCalls++
+		},
+	})
+
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.Message != "Nope" || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatal("Expected API error, got:", err)
+	}
+
+	if !errors.Is(results[len(results)-1].Err, ErrBatchStopped) {
+		t.Error("Expected last email not to be sent, got:", results[len(results)-1].Err)
+	}
+
+	// Emails not sent are reported too
+	if progressCalls != len(emails) {
+		t.Errorf("Expected %d progress calls, got %d", len(emails), progressCalls)
+	}
+}
@@ -0,0 +1,307 @@
+package client
+
+// ==============================================================================
+// Circuit breaker, tracking the health of ACS. The breaker opens after a number
+// of consecutive server errors, timeouts or throttled calls, each counted
+// separately. While open calls fail straight away with ErrCircuitOpen, then
+// once the cool down has passed a single trial call is let through (half open)
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultBreakerThreshold = 5
+const defaultThrottleThreshold = 10
+const defaultBreakerCooldown = 30 * time.Second
+
+// Names of the client breakers, passed to OnStateChange
+const (
+	BreakerEmail = "email"
+	BreakerSMS   = "sms"
+)
+
+// ErrCircuitOpen is returned while a circuit breaker is open, see CircuitOpenError
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned while a circuit breaker is open, it matches ErrCircuitOpen with errors.Is
+type CircuitOpenError struct {
+	Breaker string    // Name of the breaker, e.g. BreakerEmail
+	Until   time.Time // When a trial call will be allowed
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s circuit breaker is open until %s", e.Breaker, e.Until.Format(time.RFC3339))
+}
+
+// Is makes errors.Is(err, ErrCircuitOpen) work
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Healthy, calls are allowed
+	BreakerOpen                         // Unhealthy, calls are rejected
+	BreakerHalfOpen                     // Cooled down, a trial call is allowed
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerOptions configures circuit breakers. Thresholds are consecutive failures of
+// each kind, zero uses the default and a negative threshold stops that kind being counted
+type BreakerOptions struct {
+	ServerErrorThreshold int           // 5xx responses, defaults to 5
+	TimeoutThreshold     int           // Timeouts, defaults to 5
+	ThrottleThreshold    int           // 429 responses, defaults to 10
+	Cooldown             time.Duration // Time the breaker stays open before a trial call, defaults to 30s
+
+	// OnStateChange is called when a breaker changes state, e.g. for alerting.
+	// It's called synchronously by the call which caused the change
+	OnStateChange func(name string, from, to BreakerState)
+}
+
+// WithCircuitBreaker adds circuit breakers to the client, email and SMS have independent breakers
+func WithCircuitBreaker(opts BreakerOptions) Option {
+	return func(c *Client) {
+		c.breakers = map[string]*breaker{
+			BreakerEmail: newBreaker(BreakerEmail, opts),
+			BreakerSMS:   newBreaker(BreakerSMS, opts),
+		}
+	}
+}
+
+// BreakerState returns the state of a client breaker, closed if breakers are not enabled
+func (c *Client) BreakerState(name string) BreakerState {
+	b, found := c.breakers[name]
+	if !found {
+		return BreakerClosed
+	}
+
+	state, _ := b.current()
+
+	return state
+}
+
+// failureKind classifies the outcome of a call
+type failureKind int
+
+const (
+	kindSuccess failureKind = iota // Including client errors, ACS is working
+	kindServerError
+	kindTimeout
+	kindThrottled
+	kindNone // Not counted either way, e.g. the caller cancelled
+)
+
+type breaker struct {
+	name       string
+	thresholds map[failureKind]int
+	cooldown   time.Duration
+	onChange   func(name string, from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures map[failureKind]int
+	openedAt time.Time
+	probing  bool
+	changes  [][2]BreakerState // Waiting to be passed to onChange
+}
+
+func newBreaker(name string, opts BreakerOptions) *breaker {
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = defaultBreakerCooldown
+	}
+
+	return &breaker{
+		name: name,
+		thresholds: map[failureKind]int{
+			kindServerError: threshold(opts.ServerErrorThreshold, defaultBreakerThreshold),
+			kindTimeout:     threshold(opts.TimeoutThreshold, defaultBreakerThreshold),
+			kindThrottled:   threshold(opts.ThrottleThreshold, defaultThrottleThreshold),
+		},
+		cooldown: opts.Cooldown,
+		onChange: opts.OnStateChange,
+		failures: map[failureKind]int{},
+	}
+}
+
+func threshold(n, def int) int {
+	if n == 0 {
+		return def
+	}
+
+	return n
+}
+
+// allow returns a CircuitOpenError if the call can't be made, in half open state only one trial call is allowed
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.setState(BreakerHalfOpen)
+	}
+
+	switch {
+	case b.state == BreakerOpen:
+		return &CircuitOpenError{Breaker: b.name, Until: b.openedAt.Add(b.cooldown)}
+	case b.state == BreakerHalfOpen && b.probing:
+		return &CircuitOpenError{Breaker: b.name, Until: time.Now().Add(b.cooldown)}
+	case b.state == BreakerHalfOpen:
+		b.probing = true
+	}
+
+	return nil
+}
+
+// record updates the breaker with the outcome of a call
+func (b *breaker) record(kind failureKind) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	wasProbe := b.probing
+	b.probing = false
+
+	switch {
+	case kind == kindNone:
+		return
+	case kind == kindSuccess:
+		b.failures = map[failureKind]int{}
+		b.setState(BreakerClosed)
+
+		return
+	case b.thresholds[kind] < 0:
+		// Not counted, but the trial call was still made
+		if wasProbe {
+			b.setState(BreakerClosed)
+		}
+
+		return
+	}
+
+	b.failures[kind]++
+
+	if b.state == BreakerHalfOpen || b.failures[kind] >= b.thresholds[kind] {
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// setState changes state, must be called with the lock held
+func (b *breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+
+	b.changes = append(b.changes, [2]BreakerState{b.state, state})
+	b.state = state
+}
+
+// unlock releases the lock, then calls the callback for any state changes.
+// Calling it without the lock held means the callback can use the client
+func (b *breaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+
+	if b.onChange == nil {
+		return
+	}
+
+	for _, change := range changes {
+		b.onChange(b.name, change[0], change[1])
+	}
+}
+
+// current returns the state and number of consecutive failures of all kinds
+func (b *breaker) current() (BreakerState, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failures := 0
+	for _, n := range b.failures {
+		failures += n
+	}
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen, failures
+	}
+
+	return b.state, failures
+}
+
+// classifyError classifies an error returned by the client
+func classifyError(err error) failureKind {
+	if err == nil {
+		return kindSuccess
+	}
+
+	apiErr := &APIError{}
+	if errors.As(err, &apiErr) {
+		return classifyStatus(apiErr.StatusCode)
+	}
+
+	netErr := net.Error(nil)
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return kindTimeout
+	}
+
+	return kindNone
+}
+
+func classifyStatus(status int) failureKind {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return kindThrottled
+	case status >= http.StatusInternalServerError:
+		return kindServerError
+	default:
+		return kindSuccess
+	}
+}
+
+// breakerPolicy fails calls straight away while the breaker for the operation is open
+func breakerPolicy(breakers map[string]*breaker) Policy {
+	return func(req *http.Request, next Next) (*http.Response, error) {
+		b := breakers[BreakerEmail]
+		if OperationFromContext(req.Context()) == OperationSendSMS {
+			b = breakers[BreakerSMS]
+		}
+
+		if err := b.allow(); err != nil {
+			return nil, err
+		}
+
+		resp, err := next(req)
+
+		switch {
+		case req.Context().Err() != nil && !errors.Is(req.Context().Err(), context.DeadlineExceeded):
+			// Cancelled by the caller, which says nothing about the health of ACS
+			b.record(kindNone)
+		case err != nil:
+			b.record(classifyError(err))
+		default:
+			b.record(classifyStatus(resp.StatusCode))
+		}
+
+		return resp, err
+	}
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	emailCalls, smsCalls := int32(0), int32(0)
+	failing := int32(1)
+
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == sendSMSEndpoint {
+			atomic.AddInt32(&smsCalls, 1)
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		atomic.AddInt32(&emailCalls, 1)
+
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	mu := sync.Mutex{}
+	changes := []string{}
+
+	WithCircuitBreaker(BreakerOptions{
+		ServerErrorThreshold: 2,
+		Cooldown:             50 * time.Millisecond,
+		OnStateChange: func(name string, from, to BreakerState) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			changes = append(changes, name+":"+from.String()+"->"+to.String())
+		},
+	})(acsClient)
+
+	send := func() error {
+		_, err := acsClient.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := send(); errors.Is(err, ErrCircuitOpen) {
+			t.Fatal("breaker opened too soon")
+		}
+	}
+
+	err := send()
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	openErr := &CircuitOpenError{}
+	if !errors.As(err, &openErr) || openErr.Breaker != BreakerEmail {
+		t.Errorf("expected a CircuitOpenError for the email breaker, got %v", err)
+	}
+
+	if emailCalls != 2 {
+		t.Errorf("expected 2 calls to the API, got %d", emailCalls)
+	}
+
+	// The SMS breaker is independent, and bad requests are not failures
+	_, err = acsClient.SendSingleSMSContext(context.Background(), NewSMS("+10000000000", "+10000000001", smsMessage))
+	if errors.Is(err, ErrCircuitOpen) || smsCalls != 1 {
+		t.Errorf("SMS should not be affected by the email breaker: %v", err)
+	}
+
+	// After the cool down, a successful trial call closes the breaker
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(60 * time.Millisecond)
+
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+
+	if acsClient.BreakerState(BreakerEmail) != BreakerClosed {
+		t.Error("breaker should be closed")
+	}
+
+	want := []string{"email:closed->open", "email:open->half-open", "email:half-open->closed"}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(changes) != len(want) {
+		t.Fatalf("state changes %v, want %v", changes, want)
+	}
+
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("state changes %v, want %v", changes, want)
+		}
+	}
+}
+
+func TestCircuitBreakerThrottling(t *testing.T) {
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	WithCircuitBreaker(BreakerOptions{ServerErrorThreshold: 1, ThrottleThreshold: 3})(acsClient)
+
+	for i := 0; i < 3; i++ {
+		_, _ = acsClient.GetEmailStatus("msg-id")
+	}
+
+	if acsClient.BreakerState(BreakerEmail) != BreakerOpen {
+		t.Error("breaker should open after 3 throttled calls")
+	}
+}
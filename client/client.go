@@ -6,10 +6,18 @@ package client
 // https://learn.microsoft.com/en-us/rest/api/communication/email/send
 // ==============================================================================
 
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
 const sendEmailEndpoint = "/emails:send"
 const sendSMSEndpoint = "/sms"
 const statusEmailEndpoint = "/emails/%s/status"
-const clientTimeout = 20
+const clientTimeout = 20 * time.Second
 
 // Client is used to send emails with Azure Communication Services
 type Client struct {
@@ -17,14 +25,84 @@ type Client struct {
 	Endpoint        string
 	APIVersionEmail string // Defaults to 2021-10-01-preview
 	APIVersionSMS   string // Defaults to 2021-03-07
+
+	// Defaults fill in messages sent without a sender, see WithDefaults
+	Defaults Defaults
+
+	limiter   *rateLimiter
+	telemetry *telemetry
+	logging   *LogOptions
+	archive   *ArchiveOptions
+	retry     *RetryOptions
+	breakers  map[string]*breaker
+	perCall   []Policy
+	perRetry  []Policy
+	transport http.RoundTripper
+	timeout   time.Duration
+	firstSent firstSentCache
+
+	pipelineOnce sync.Once
+	pipeline     Next
 }
 
-// New creates a client with the given access key and endpoint
-func New(accessKey, endpoint string) *Client {
-	return &Client{
+// Defaults are used for messages sent by the client, when they don't set their own
+type Defaults struct {
+	From     string // Email sender address
+	FromName string // Sender display name for the From header of MIME exports and archives, ACS doesn't send it
+	SMSFrom  string // Number SMS are sent from
+
+	// DisableTracking turns off user engagement tracking for every email
+	DisableTracking bool
+}
+
+// WithDefaults sets the defaults for messages sent by the client
+func WithDefaults(d Defaults) Option {
+	return func(c *Client) {
+		c.Defaults = d
+	}
+}
+
+// Option configures optional features of the client, see the With... functions
+type Option func(c *Client)
+
+// New creates a client with the given access key and endpoint, plus any options
+func New(accessKey, endpoint string, opts ...Option) *Client {
+	c := &Client{
 		AccessKey:       accessKey,
 		Endpoint:        endpoint,
 		APIVersionEmail: "2021-10-01-preview",
 		APIVersionSMS:   "2021-03-07",
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// NewFromConnectionString creates a client from an ACS connection string, as shown in the
+// Azure portal, in the form "endpoint=https://<resource>.communication.azure.com/;accesskey=<key>"
+func NewFromConnectionString(connectionString string, opts ...Option) (*Client, error) {
+	endpoint, accessKey := "", ""
+
+	for _, part := range strings.Split(connectionString, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "endpoint":
+			endpoint = strings.TrimRight(value, "/")
+		case "accesskey":
+			accessKey = value
+		}
+	}
+
+	if endpoint == "" || accessKey == "" {
+		return nil, errors.New("connection string must contain endpoint and accesskey")
+	}
+
+	return New(accessKey, endpoint, opts...), nil
 }
@@ -0,0 +1,458 @@
+package client
+
+// ==============================================================================
+// CSS inlining for HTML email bodies
+// Many email clients strip <style> blocks, so rules are moved into the style
+// attribute of each matching element, following the normal CSS cascade.
+// Media queries and other at-rules can't be inlined and are kept in a <style>
+// ==============================================================================
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// CSSInliner moves CSS rules from <style> blocks into inline style attributes
+type CSSInliner struct {
+	// Strict makes selectors which can't be inlined an error, rather than
+	// the rule being kept in the retained <style> block
+	Strict bool
+
+	// OnUnsupported is called with any selectors which could not be inlined, optional
+	OnUnsupported func(selectors []string)
+}
+
+// cssRule is a single rule from a stylesheet, with one or more selectors
+type cssRule struct {
+	selectors string
+	body      string
+}
+
+// cssDeclaration is one property & value, along with where it came from
+type cssDeclaration struct {
+	property    string
+	value       string
+	important   bool
+	inline      bool
+	specificity cascadia.Specificity
+	order       int
+}
+
+// InlineCSS inlines CSS in the HTML using the default inliner, returning any
+// selectors which could not be inlined
+func InlineCSS(htmlBody string) (result string, unsupported []string, err error) {
+	return CSSInliner{}.Inline(htmlBody)
+}
+
+// EnableCSSInlining adds a transform to the email which inlines CSS before sending
+func (e *Email) EnableCSSInlining() {
+	e.AddTransform(CSSInliner{}.Transform)
+}
+
+// Transform inlines the CSS in the HTML content of an email, for use with Email.AddTransform
+func (ci CSSInliner) Transform(e *Email) error {
+	if e.Content.HTML == "" {
+		return nil
+	}
+
+	result, unsupported, err := ci.Inline(e.Content.HTML)
+	if err != nil {
+		return err
+	}
+
+	if len(unsupported) > 0 && ci.OnUnsupported != nil {
+		ci.OnUnsupported(unsupported)
+	}
+
+	e.Content.HTML = result
+
+	return nil
+}
+
+// Inline parses the <style> blocks in a HTML document and applies the rules as
+// style attributes, returning the new HTML and any selectors which could not be inlined
+func (ci CSSInliner) Inline(htmlBody string) (result string, unsupported []string, err error) {
+	doc, err := html.Parse(strings.NewReader(htmlBody))
+	if err != nil {
+		return "", nil, fmt.Errorf("error parsing HTML: %s", err)
+	}
+
+	styleNodes := []*html.Node{}
+	elements := []*html.Node{}
+
+	var walk func(n *html.Node, inBody bool)
+
+	walk = func(n *html.Node, inBody bool) {
+		if n.Type == html.ElementNode {
+			if n.DataAtom == atom.Style {
+				styleNodes = append(styleNodes, n)
+
+				return
+			}
+
+			if n.DataAtom == atom.Body {
+				inBody = true
+			}
+
+			if inBody {
+				elements = append(elements, n)
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, inBody)
+		}
+	}
+
+	walk(doc, false)
+
+	if len(styleNodes) == 0 {
+		return htmlBody, nil, nil
+	}
+
+	decls := map[*html.Node][]cssDeclaration{}
+	retained := []string{}
+	order := 0
+
+	for _, styleNode := range styleNodes {
+		css := textContent(styleNode)
+
+		// Styles for a specific media type can't be inlined, so are kept as a media query
+		if media := strings.TrimSpace(attr(styleNode, "media")); media != "" && media != "all" && media != "screen" {
+			retained = append(retained, fmt.Sprintf("@media %s {\n%s\n}", media, strings.TrimSpace(css)))
+
+			continue
+		}
+
+		rules, atRules := parseCSS(css)
+		retained = append(retained, atRules...)
+
+		for _, rule := range rules {
+			kept := []string{}
+
+			for _, selector := range splitTopLevel(rule.selectors, ',') {
+				selector = strings.TrimSpace(selector)
+				if selector == "" {
+					continue
+				}
+
+				sel, err := cascadia.Parse(selector)
+				if err != nil || isDynamicSelector(selector) {
+					unsupported = append(unsupported, selector)
+					kept = append(kept, selector)
+
+					continue
+				}
+
+				specificity := sel.Specificity()
+
+				for _, el := range elements {
+					if !sel.Match(el) {
+						continue
+					}
+
+					for _, d := range parseDeclarations(rule.body) {
+						d.specificity = specificity
+						d.order = order
+						decls[el] = append(decls[el], d)
+						order++
+					}
+				}
+			}
+
+			if len(kept) > 0 {
+				retained = append(retained, fmt.Sprintf("%s { %s }", strings.Join(kept, ", "), strings.TrimSpace(rule.body)))
+			}
+		}
+	}
+
+	if ci.Strict && len(unsupported) > 0 {
+		return "", unsupported, fmt.Errorf("unsupported CSS selectors: %s", strings.Join(unsupported, ", "))
+	}
+
+	for el, elDecls := range decls {
+		for _, d := range parseDeclarations(attr(el, "style")) {
+			d.inline = true
+			d.order = order
+			elDecls = append(elDecls, d)
+			order++
+		}
+
+		setAttr(el, "style", cascadeStyle(elDecls))
+	}
+
+	// Remove the original style blocks, and put back anything that couldn't be inlined
+	for _, styleNode := range styleNodes {
+		styleNode.Parent.RemoveChild(styleNode)
+	}
+
+	if len(retained) > 0 {
+		if head := findElement(doc, atom.Head); head != nil {
+			style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+			style.AppendChild(&html.Node{Type: html.TextNode, Data: "\n" + strings.Join(retained, "\n") + "\n"})
+			head.AppendChild(style)
+		}
+	}
+
+	out := bytes.Buffer{}
+
+	err = html.Render(&out, doc)
+	if err != nil {
+		return "", unsupported, err
+	}
+
+	return out.String(), unsupported, nil
+}
+
+// cascadeStyle picks the winning declaration for each property and builds a style attribute
+func cascadeStyle(decls []cssDeclaration) string {
+	sort.SliceStable(decls, func(i, j int) bool {
+		a, b := decls[i], decls[j]
+		if a.important != b.important {
+			return b.important
+		}
+
+		if a.inline != b.inline {
+			return b.inline
+		}
+
+		if a.specificity != b.specificity {
+			return a.specificity.Less(b.specificity)
+		}
+
+		return a.order < b.order
+	})
+
+	// Later declarations win, but each property keeps the position it first appeared in
+	winners := map[string]cssDeclaration{}
+	props := []string{}
+
+	for _, d := range decls {
+		if _, found := winners[d.property]; !found {
+			props = append(props, d.property)
+		}
+
+		winners[d.property] = d
+	}
+
+	parts := make([]string, len(props))
+
+	for i, prop := range props {
+		d := winners[prop]
+		value := d.value
+
+		if d.important && d.inline {
+			value += " !important"
+		}
+
+		parts[i] = prop + ": " + value
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// parseCSS splits a stylesheet into plain rules and at-rules, at-rules are returned as text
+func parseCSS(css string) (rules []cssRule, atRules []string) {
+	css = stripCSSComments(css)
+	css = strings.NewReplacer("<!--", "", "-->", "").Replace(css)
+
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			return rules, atRules
+		}
+
+		open := indexTopLevel(css, '{')
+		semi := indexTopLevel(css, ';')
+
+		// Statement at-rules such as @import or @charset
+		if strings.HasPrefix(css, "@") && semi >= 0 && (open < 0 || semi < open) {
+			atRules = append(atRules, strings.TrimSpace(css[:semi+1]))
+			css = css[semi+1:]
+
+			continue
+		}
+
+		if open < 0 {
+			return rules, atRules
+		}
+
+		end := matchingBrace(css, open)
+		if end < 0 {
+			end = len(css) - 1
+		}
+
+		prelude := strings.TrimSpace(css[:open])
+		body := css[open+1 : end]
+
+		if strings.HasPrefix(prelude, "@") {
+			atRules = append(atRules, strings.TrimSpace(css[:end+1]))
+		} else {
+			rules = append(rules, cssRule{selectors: prelude, body: body})
+		}
+
+		css = css[end+1:]
+	}
+}
+
+// parseDeclarations parses the body of a rule or a style attribute
+func parseDeclarations(body string) []cssDeclaration {
+	decls := []cssDeclaration{}
+
+	for _, part := range splitTopLevel(stripCSSComments(body), ';') {
+		prop, value, found := strings.Cut(part, ":")
+		if !found {
+			continue
+		}
+
+		prop = strings.ToLower(strings.TrimSpace(prop))
+		value = strings.TrimSpace(value)
+		important := false
+
+		if i := strings.LastIndex(value, "!"); i >= 0 && strings.EqualFold(strings.TrimSpace(value[i+1:]), "important") {
+			important = true
+			value = strings.TrimSpace(value[:i])
+		}
+
+		if prop == "" || value == "" {
+			continue
+		}
+
+		decls = append(decls, cssDeclaration{property: prop, value: value, important: important})
+	}
+
+	return decls
+}
+
+// isDynamicSelector checks for pseudo-classes that depend on user interaction and can never be inlined
+func isDynamicSelector(selector string) bool {
+	for _, pseudo := range []string{":hover", ":active", ":focus", ":visited", ":target", "::"} {
+		if strings.Contains(selector, pseudo) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stripCSSComments(css string) string {
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			return css
+		}
+
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return css[:start]
+		}
+
+		css = css[:start] + css[start+2+end+2:]
+	}
+}
+
+// indexTopLevel finds a character outside of any quotes, brackets or parentheses
+func indexTopLevel(s string, target byte) int {
+	depth := 0
+	quote := byte(0)
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == target && depth == 0:
+			return i
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '(' || ch == '[':
+			depth++
+		case ch == ')' || ch == ']':
+			depth--
+		}
+	}
+
+	return -1
+}
+
+// splitTopLevel splits a string on a separator, ignoring any inside quotes, brackets or parentheses
+func splitTopLevel(s string, sep byte) []string {
+	parts := []string{}
+
+	for {
+		i := indexTopLevel(s, sep)
+		if i < 0 {
+			return append(parts, s)
+		}
+
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// matchingBrace finds the closing brace for the opening brace at the given position
+func matchingBrace(s string, open int) int {
+	depth := 0
+	quote := byte(0)
+
+	for i := open; i < len(s); i++ {
+		ch := s[i]
+
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '{':
+			depth++
+		case ch == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+const styledHTML = `<html><head><style>
+p { color: red; font-size: 14px }
+.note { color: blue }
+#main .note { font-weight: bold }
+p.warn { color: orange !important }
+a:hover { color: green }
+@media (max-width: 600px) { p { font-size: 12px } }
+</style></head>
+<body><div id="main"><p class="note" style="font-size: 16px">One</p><p class="warn" style="color: black">Two</p></div></body></html>`
+
+func TestInlineCSS(t *testing.T) {
+	result, unsupported, err := InlineCSS(styledHTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(result, `<p class="note" style="color: blue; font-size: 16px; font-weight: bold">One</p>`) {
+		t.Error("Expected specificity and inline style to be respected, got: " + result)
+	}
+
+	if !strings.Contains(result, `<p class="warn" style="color: orange; font-size: 14px">Two</p>`) {
+		t.Error("Expected !important to override inline style, got: " + result)
+	}
+
+	if !strings.Contains(result, "@media (max-width: 600px)") || !strings.Contains(result, "a:hover { color: green }") {
+		t.Error("Expected media query and hover rule to be retained, got: " + result)
+	}
+
+	if len(unsupported) != 1 || unsupported[0] != "a:hover" {
+		t.Errorf("Expected a:hover to be unsupported, got: %v", unsupported)
+	}
+}
+
+func TestInlineCSSStrict(t *testing.T) {
+	_, _, err := CSSInliner{Strict: true}.Inline(styledHTML)
+	if err == nil || !strings.Contains(err.Error(), "a:hover") {
+		t.Error("Expected error, but got:", err)
+	}
+}
+
+func TestInlineCSSTransform(t *testing.T) {
+	e := NewHTMLEmail(fromAddress, toAddress, subject, styledHTML)
+	e.EnableCSSInlining()
+
+	err := e.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(e.Content.HTML, `style="color: blue;`) {
+		t.Error("Expected CSS to be inlined, got: " + e.Content.HTML)
+	}
+
+	if !strings.HasPrefix(e.Content.PlainText, "One\n\nTwo") {
+		t.Error("Expected plain text, got: " + e.Content.PlainText)
+	}
+}
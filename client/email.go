@@ -8,113 +8,125 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
-	"github.com/benc-uk/go-acs-client/auth"
-
 	"github.com/google/uuid"
 )
 
 // SendEmail sends an email and returns the message ID and any error
 func (c *Client) SendEmail(e *Email) (messageID string, err error) {
-	postBody, err := json.Marshal(e)
+	return c.SendEmailContext(context.Background(), e)
+}
+
+// SendEmailContext sends an email and returns the message ID and any error,
+// the context can be used to cancel the request
+func (c *Client) SendEmailContext(ctx context.Context, e *Email) (messageID string, err error) {
+	result, err := c.SendEmailWithResult(ctx, e)
 	if err != nil {
-		return "", fmt.Errorf("email failed JSON marshalling: %s", err)
+		return "", err
 	}
 
-	bodyBuffer := bytes.NewBuffer(postBody)
+	return result.MessageID, nil
+}
+
+// SendEmailWithResult sends an email, and returns the message ID along with the
+// repeatability result, which says if ACS had already processed the request
+func (c *Client) SendEmailWithResult(ctx context.Context, e *Email) (*SendEmailResult, error) {
+	// Transforms and defaults change the email, so work on a copy and leave the caller's as it was
+	e = e.Clone()
+	c.applyDefaults(e)
+
+	err := e.Prepare()
+	if err != nil {
+		return nil, fmt.Errorf("error preparing email: %s", err)
+	}
 
-	req, err := http.NewRequest("POST", c.Endpoint+sendEmailEndpoint+"?api-version="+c.APIVersionEmail, bodyBuffer)
+	postBody, err := json.Marshal(e)
 	if err != nil {
-		return "", fmt.Errorf("error creating API request: %s", err)
+		return nil, fmt.Errorf("email failed JSON marshalling: %s", err)
 	}
 
-	// Sign the request using the ACS access key and HMAC-SHA256
-	err = auth.SignRequestHMAC(c.AccessKey, req)
+	bodyBuffer := bytes.NewBuffer(postBody)
+	ctx = withRequestInfo(ctx, OperationSendEmail, e.recipientCount(), e.Sender)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint+sendEmailEndpoint+"?api-version="+c.APIVersionEmail, bodyBuffer)
 	if err != nil {
-		return "", fmt.Errorf("error signing API request: %s", err)
+		return nil, fmt.Errorf("error creating API request: %s", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
 	// Important, without these headers the request will fail
-	req.Header.Set("repeatability-request-id", uuid.New().String())
-	req.Header.Set("repeatability-first-sent", time.Now().UTC().Format(http.TimeFormat))
-
-	client := &http.Client{
-		Timeout: time.Second * clientTimeout,
+	result := &SendEmailResult{
+		RequestID: e.RepeatabilityRequestID,
+		FirstSent: e.RepeatabilityFirstSent,
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending API request: %s", err)
+	// Repeatability fields which are set win over the key, e.g. the scheduler sets one for each run
+	if e.IdempotencyKey != "" && e.RepeatabilityRequestID == "" {
+		result.RequestID, result.FirstSent = c.Repeatability(e.IdempotencyKey)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusBadRequest {
-		commError := ErrorResponse{}
+	if result.RequestID == "" {
+		result.RequestID = uuid.New().String()
+	}
 
-		err = json.NewDecoder(resp.Body).Decode(&commError)
-		if err != nil {
-			return "", err
-		}
+	if result.FirstSent == "" {
+		result.FirstSent = time.Now().UTC().Format(http.TimeFormat)
+	}
 
-		return "", fmt.Errorf("error sending email: %s", commError.Error.Message)
+	req.Header.Set("repeatability-request-id", result.RequestID)
+	req.Header.Set("repeatability-first-sent", result.FirstSent)
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending API request: %w", err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted {
-		return "", fmt.Errorf("error sending email: status: %d", resp.StatusCode)
+		return nil, newAPIError("sending email", resp)
 	}
 
 	// This header seems to be the message ID
-	messageID = resp.Header.Get("x-ms-request-id")
+	result.MessageID = resp.Header.Get("x-ms-request-id")
+	result.RepeatabilityResult = RepeatabilityResult(resp.Header.Get("repeatability-result"))
 
-	return messageID, nil
+	c.archiveEmail(ctx, result.MessageID, e)
+
+	return result, nil
 }
 
-// GetStatus gets the status of an email message sent using SendEmail()
+// GetEmailStatus gets the status of an email message sent using SendEmail()
 func (c *Client) GetEmailStatus(messageID string) (status string, err error) {
-	req, err := http.NewRequest("GET", c.Endpoint+fmt.Sprintf(statusEmailEndpoint, messageID)+"?api-version="+c.APIVersionEmail, nil)
-	if err != nil {
-		return "", err
-	}
+	return c.GetEmailStatusContext(context.Background(), messageID)
+}
+
+// GetEmailStatusContext gets the status of an email message sent using SendEmail(),
+// the context can be used to cancel the request
+func (c *Client) GetEmailStatusContext(ctx context.Context, messageID string) (status string, err error) {
+	ctx = withRequestInfo(ctx, OperationGetEmailStatus, 0, "")
 
-	// Sign the request using the ACS access key and HMAC-SHA256
-	err = auth.SignRequestHMAC(c.AccessKey, req)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.Endpoint+fmt.Sprintf(statusEmailEndpoint, messageID)+"?api-version="+c.APIVersionEmail, nil)
 	if err != nil {
 		return "", err
 	}
 
-	client := &http.Client{
-		Timeout: time.Second * clientTimeout,
-	}
-
-	resp, err := client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusBadRequest {
-		commError := ErrorResponse{}
-
-		err = json.NewDecoder(resp.Body).Decode(&commError)
-		if err != nil {
-			return "", err
-		}
-		defer resp.Body.Close()
-
-		return "", fmt.Errorf("error getting status: %s", commError.Error.Message)
-	}
-
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("error getting status: status: %d", resp.StatusCode)
+		return "", newAPIError("getting status", resp)
 	}
 
 	statusResult := &SendStatusResult{}
@@ -149,6 +161,7 @@ func newEmail(from, to, subject string) *Email {
 func NewHTMLEmail(from, to, subject, body string) *Email {
 	e := newEmail(from, to, subject)
 	e.Content.HTML = body
+	e.GeneratePlainText = true
 
 	return e
 }
@@ -161,6 +174,44 @@ func NewPlainEmail(from, to, subject, body string) *Email {
 	return e
 }
 
+// applyDefaults fills in the client defaults
+func (c *Client) applyDefaults(e *Email) {
+	if e.Sender == "" {
+		e.Sender = c.Defaults.From
+	}
+
+	// The display name belongs to the default address, so isn't used for other senders
+	if e.SenderName == "" && e.Sender == c.Defaults.From {
+		e.SenderName = c.Defaults.FromName
+	}
+
+	if c.Defaults.DisableTracking {
+		e.Tracking = true
+	}
+}
+
+// Clone returns a copy of the email, with its own recipients, headers, attachments and
+// transforms, so either can be changed without affecting the other
+func (e *Email) Clone() *Email {
+	cp := *e
+	cp.Recipients = Recipients{
+		To:  slices.Clone(e.Recipients.To),
+		CC:  slices.Clone(e.Recipients.CC),
+		BCC: slices.Clone(e.Recipients.BCC),
+	}
+	cp.Headers = slices.Clone(e.Headers)
+	cp.ReplyTo = slices.Clone(e.ReplyTo)
+	cp.Attachments = slices.Clone(e.Attachments)
+	cp.Transforms = slices.Clone(e.Transforms)
+
+	return &cp
+}
+
+// recipientCount is the total number of To, CC and BCC recipients
+func (e *Email) recipientCount() int {
+	return len(e.Recipients.To) + len(e.Recipients.CC) + len(e.Recipients.BCC)
+}
+
 // AddCC adds a CC recipient
 func (e *Email) AddCC(address, displayName string) {
 	e.Recipients.CC = append(e.Recipients.CC, Address{
@@ -203,6 +254,51 @@ func (e *Email) DisableUserEngagementTracking() {
 	e.Tracking = true
 }
 
+// EnablePlainTextGeneration generates the plain text content from the HTML on send
+func (e *Email) EnablePlainTextGeneration() {
+	e.GeneratePlainText = true
+}
+
+// DisablePlainTextGeneration stops plain text content being generated from the HTML
+func (e *Email) DisablePlainTextGeneration() {
+	e.GeneratePlainText = false
+}
+
+// SetIdempotencyKey sets a key which identifies this email, sends with the same
+// key are only delivered once. Any string can be used, such as an order number.
+// When the key was first sent is remembered by this client, in memory for 24 hours,
+// so keys only stop repeats from the same process
+func (e *Email) SetIdempotencyKey(key string) {
+	e.IdempotencyKey = key
+}
+
+// AddTransform adds a transform, which will modify the email before it is sent
+func (e *Email) AddTransform(t Transform) {
+	e.Transforms = append(e.Transforms, t)
+}
+
+// Prepare runs transforms and fills in any generated content, this is called on a
+// copy when sending, but can also be used to preview exactly what will be sent
+func (e *Email) Prepare() error {
+	for _, t := range e.Transforms {
+		err := t(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.GeneratePlainText && e.Content.PlainText == "" && e.Content.HTML != "" {
+		text, err := HTMLToText(e.Content.HTML)
+		if err != nil {
+			return err
+		}
+
+		e.Content.PlainText = text
+	}
+
+	return nil
+}
+
 // AddAttachmentRaw adds an attachment to the email as raw bytes
 func (e *Email) AddAttachmentRaw(name string, content []byte, attachmentType string) {
 	b64content := base64.StdEncoding.EncodeToString(content)
@@ -8,14 +8,21 @@ package client
 //  TO_ADDRESS=<your-email-address>
 //  FROM_ADDRESS=<valid-from-address>
 //  CC_ADDRESS=<other-email-address>
+// Set ACS_RECORDER=record to save the interactions in testdata/cassettes,
+// then ACS_RECORDER=replay runs the tests from the cassettes without ACS, and
+// fails any test without one. With no .env, or ACS_RECORDER=off, they're skipped
 // ==============================================================================
 
 import (
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/benc-uk/go-acs-client/recorder"
+
 	"github.com/joho/godotenv"
 )
 
@@ -28,6 +35,11 @@ var accessKey string
 var fromNumber string
 var toNumber string
 
+var recorderMode string
+
+// skipLive is set when there's nothing to run the live tests against
+var skipLive bool
+
 const subject = "Test email via Azure Communication Services"
 const emailBody = "<h1>Hello!</h1>This email was sent using Go and the Azure Communication Services REST API"
 
@@ -45,6 +57,21 @@ func TestMain(m *testing.M) {
 	fromNumber = os.Getenv("FROM_NUMBER")
 	toNumber = os.Getenv("TO_NUMBER")
 
+	recorderMode = os.Getenv("ACS_RECORDER")
+	skipLive = recorderMode == "off" || (recorderMode == "" && endpoint == "")
+
+	// Replaying needs no real settings, the placeholders match anything scrubbed when recording.
+	// They are also used by the tests which don't call ACS
+	if recorderMode == "replay" || skipLive {
+		setDefault(&endpoint, "https://replay.communication.azure.com")
+		setDefault(&accessKey, "cmVwbGF5")
+		setDefault(&toAddress, recorder.PlaceholderEmail)
+		setDefault(&fromAddress, recorder.PlaceholderEmail)
+		setDefault(&ccAddress, recorder.PlaceholderEmail)
+		setDefault(&fromNumber, recorder.PlaceholderPhone)
+		setDefault(&toNumber, recorder.PlaceholderPhone)
+	}
+
 	if endpoint == "" || accessKey == "" {
 		log.Fatal("Please set ACS_ENDPOINT and ACS_ACCESS_KEY")
 	}
@@ -60,8 +87,57 @@ func TestMain(m *testing.M) {
 	m.Run()
 }
 
+func setDefault(v *string, def string) {
+	if *v == "" {
+		*v = def
+	}
+}
+
+// newTestClient creates a client for the live tests, which records or replays when ACS_RECORDER is set
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	if skipLive {
+		t.Skip("No ACS settings in .env, set ACS_RECORDER=replay to run from the cassettes")
+	}
+
+	if recorderMode == "" {
+		return New(accessKey, endpoint)
+	}
+
+	mode, err := recorder.ParseMode(recorderMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := ""
+	if u, err := url.Parse(endpoint); err == nil {
+		host = u.Host
+	}
+
+	cassette := filepath.Join("testdata", "cassettes", t.Name()+".json")
+
+	// A missing cassette fails, so a replay run can't pass without running anything
+	if _, err := os.Stat(cassette); mode == recorder.ModeReplay && os.IsNotExist(err) {
+		t.Fatalf("No cassette %s, record it with ACS_RECORDER=record", cassette)
+	}
+
+	rec, err := recorder.New(cassette, mode, recorder.Options{Secrets: []string{accessKey, host}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := rec.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return New(accessKey, endpoint, WithTransport(rec))
+}
+
 func TestSendSimple(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, subject, emailBody)
 	_, err := client.SendEmail(e)
 
@@ -71,7 +147,7 @@ func TestSendSimple(t *testing.T) {
 }
 
 func TestSendStatus(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, subject, emailBody)
 
 	id, err := client.SendEmail(e)
@@ -90,7 +166,7 @@ func TestSendStatus(t *testing.T) {
 }
 
 func TestSendCC(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, subject, emailBody)
 	e.AddCC(ccAddress, "Some person")
 	_, err := client.SendEmail(e)
@@ -101,7 +177,7 @@ func TestSendCC(t *testing.T) {
 }
 
 func TestSendAttachmentText(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, "Testing text attachments", "Yo! Here are some attachments...")
 
 	e.AddAttachmentRaw("hello.txt", []byte("Hello world!"), "txt")
@@ -114,7 +190,7 @@ func TestSendAttachmentText(t *testing.T) {
 }
 
 func TestSendAttachmentImage(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, "Testing image attachments", "Yo! Here are some attachments...")
 
 	_ = e.AddAttachmentFile("testdata/trek.gif")
@@ -136,7 +212,7 @@ func TestAttachmentMissing(t *testing.T) {
 }
 
 func TestSendCustomHeader(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewPlainEmail(fromAddress, toAddress, "Testing with custom header", "I wonder how my socks are?")
 	e.AddCustomHeader("X-Sock-Status", "My socks are extremely smelly")
 	_, err := client.SendEmail(e)
@@ -147,7 +223,7 @@ func TestSendCustomHeader(t *testing.T) {
 }
 
 func TestInvalidToAddress(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, "lemon", subject, emailBody)
 	_, err := client.SendEmail(e)
 
@@ -157,7 +233,7 @@ func TestInvalidToAddress(t *testing.T) {
 }
 
 func TestInvalidFromAddress(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail("sausages", toAddress, subject, emailBody)
 	_, err := client.SendEmail(e)
 
@@ -167,7 +243,7 @@ func TestInvalidFromAddress(t *testing.T) {
 }
 
 func TestNoSubject(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, "", emailBody)
 	_, err := client.SendEmail(e)
 
@@ -177,7 +253,7 @@ func TestNoSubject(t *testing.T) {
 }
 
 func TestNoBody(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, subject, "")
 	_, err := client.SendEmail(e)
 
@@ -187,7 +263,7 @@ func TestNoBody(t *testing.T) {
 }
 
 func TestInvalidImportance(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, subject, emailBody)
 	e.Importance = "fishcake"
 	_, err := client.SendEmail(e)
@@ -198,7 +274,7 @@ func TestInvalidImportance(t *testing.T) {
 }
 
 func TestInvalidReplyTo(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 	e := NewHTMLEmail(fromAddress, toAddress, subject, emailBody)
 	e.ReplyTo = []Address{
 		{
@@ -0,0 +1,111 @@
+package client
+
+// ==============================================================================
+// Errors returned by the client when the ACS API rejects a request
+// ==============================================================================
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxErrorBody = 64 * 1024
+
+// APIError is returned when the API responds with an unexpected status code
+type APIError struct {
+	Op         string        // What was being done, e.g. "sending email"
+	StatusCode int           // HTTP status code returned by the API
+	Code       string        // Error code from the API, if any
+	Message    string        // Error message from the API, if any
+	RetryAfter time.Duration // Delay requested by the API before retrying, if any
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("error %s: %s", e.Op, e.Message)
+	}
+
+	return fmt.Sprintf("error %s: status: %d", e.Op, e.StatusCode)
+}
+
+// Retryable is true when the request failed due to throttling or a server side error
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// IsRetryable checks if an error returned by the client is worth retrying, these are
+// throttling & server errors from the API, network timeouts and open circuit breakers
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+
+	apiErr := &APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	netErr := net.Error(nil)
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+
+	return false
+}
+
+// retryAfter gets the requested retry delay from the response headers, if any
+func retryAfter(resp *http.Response) time.Duration {
+	for _, header := range []string{"retry-after-ms", "x-ms-retry-after-ms"} {
+		if ms, err := strconv.Atoi(resp.Header.Get(header)); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(when); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
+
+// newAPIError builds an APIError from a failed response, the body is
+// decoded as an ACS error if possible, otherwise it's used as the message
+func newAPIError(op string, resp *http.Response) *APIError {
+	apiErr := &APIError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfter(resp),
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	commError := ErrorResponse{}
+	if err := json.Unmarshal(body, &commError); err == nil && commError.Error.Message != "" {
+		apiErr.Code = commError.Error.Code
+		apiErr.Message = commError.Error.Message
+
+		return apiErr
+	}
+
+	apiErr.Message = strings.TrimSpace(string(body))
+
+	return apiErr
+}
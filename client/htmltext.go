@@ -0,0 +1,466 @@
+package client
+
+// ==============================================================================
+// Conversion of HTML email bodies into a readable plain text alternative
+// Links are kept as numbered footnotes, lists and tables are laid out as text
+// and any script or style content is dropped
+// ==============================================================================
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const hrWidth = 40
+
+// Elements which are skipped entirely, along with all their content
+var skippedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Head:     true,
+	atom.Title:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Svg:      true,
+	atom.Select:   true,
+	atom.Textarea: true,
+}
+
+// Elements which are rendered as a paragraph, separated from their neighbours
+var blockElements = map[atom.Atom]bool{
+	atom.P:          true,
+	atom.Div:        true,
+	atom.Section:    true,
+	atom.Article:    true,
+	atom.Header:     true,
+	atom.Footer:     true,
+	atom.Main:       true,
+	atom.Nav:        true,
+	atom.Aside:      true,
+	atom.Address:    true,
+	atom.Figure:     true,
+	atom.Figcaption: true,
+	atom.Center:     true,
+	atom.Form:       true,
+	atom.Fieldset:   true,
+	atom.Dl:         true,
+	atom.Dt:         true,
+	atom.Dd:         true,
+	atom.Li:         true,
+	atom.Caption:    true,
+}
+
+// HTMLToText converts a HTML document or fragment into plain text
+func HTMLToText(htmlBody string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(htmlBody))
+	if err != nil {
+		return "", fmt.Errorf("error parsing HTML: %s", err)
+	}
+
+	r := &textRenderer{}
+	text := r.renderChildren(doc, false)
+
+	if len(r.links) > 0 {
+		notes := make([]string, len(r.links))
+		for i, link := range r.links {
+			notes[i] = fmt.Sprintf("[%d] %s", i+1, link)
+		}
+
+		text = strings.TrimSpace(text + "\n\n" + strings.Join(notes, "\n"))
+	}
+
+	return text, nil
+}
+
+// textRenderer walks the HTML tree, collecting links as it goes
+type textRenderer struct {
+	links []string
+}
+
+// textWriter accumulates inline text and completed blocks of text
+type textWriter struct {
+	blocks       []string
+	inline       strings.Builder
+	pendingSpace bool
+	tight        bool // Blocks are separated by a single newline rather than a blank line
+}
+
+func (w *textWriter) writeInline(s string) {
+	for _, ch := range s {
+		if unicode.IsSpace(ch) {
+			w.pendingSpace = w.inline.Len() > 0
+
+			continue
+		}
+
+		if w.pendingSpace {
+			w.inline.WriteRune(' ')
+			w.pendingSpace = false
+		}
+
+		w.inline.WriteRune(ch)
+	}
+}
+
+func (w *textWriter) lineBreak() {
+	w.inline.WriteRune('\n')
+	w.pendingSpace = false
+}
+
+func (w *textWriter) flushInline() {
+	lines := strings.Split(w.inline.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+
+	text := strings.Trim(strings.Join(lines, "\n"), "\n")
+	if strings.TrimSpace(text) != "" {
+		w.blocks = append(w.blocks, text)
+	}
+
+	w.inline.Reset()
+	w.pendingSpace = false
+}
+
+func (w *textWriter) block(text string) {
+	w.flushInline()
+
+	text = strings.Trim(text, "\n")
+	if strings.TrimSpace(text) != "" {
+		w.blocks = append(w.blocks, text)
+	}
+}
+
+func (w *textWriter) String() string {
+	w.flushInline()
+
+	sep := "\n\n"
+	if w.tight {
+		sep = "\n"
+	}
+
+	return strings.Join(w.blocks, sep)
+}
+
+// renderChildren renders all the children of a node into a string
+func (r *textRenderer) renderChildren(n *html.Node, tight bool) string {
+	w := &textWriter{tight: tight}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.render(w, c)
+	}
+
+	return w.String()
+}
+
+func (r *textRenderer) render(w *textWriter, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.writeInline(n.Data)
+
+		return
+	case html.ElementNode:
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			r.render(w, c)
+		}
+
+		return
+	default:
+		return
+	}
+
+	if skippedElements[n.DataAtom] {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		w.lineBreak()
+	case atom.Hr:
+		w.block(strings.Repeat("-", hrWidth))
+	case atom.A:
+		r.renderLink(w, n)
+	case atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			w.writeInline("[" + alt + "]")
+		}
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.block(renderHeading(n.DataAtom, collapse(r.renderChildren(n, false))))
+	case atom.Ul, atom.Ol:
+		w.block(r.renderList(n))
+	case atom.Table:
+		w.block(r.renderTable(n))
+	case atom.Blockquote:
+		w.block(prefixLines(r.renderChildren(n, false), "> ", "> "))
+	case atom.Pre:
+		w.block(strings.Trim(textContent(n), "\n"))
+	default:
+		if blockElements[n.DataAtom] {
+			w.block(r.renderChildren(n, false))
+
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			r.render(w, c)
+		}
+	}
+}
+
+// renderLink writes the link text, followed by a reference to a footnote holding the URL
+func (r *textRenderer) renderLink(w *textWriter, n *html.Node) {
+	text := collapse(r.renderChildren(n, false))
+	href := strings.TrimSpace(attr(n, "href"))
+
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		w.writeInline(text)
+
+		return
+	}
+
+	if text == "" || text == href || "mailto:"+text == href {
+		w.writeInline(href)
+
+		return
+	}
+
+	num := 0
+
+	for i, link := range r.links {
+		if link == href {
+			num = i + 1
+
+			break
+		}
+	}
+
+	if num == 0 {
+		r.links = append(r.links, href)
+		num = len(r.links)
+	}
+
+	w.writeInline(fmt.Sprintf("%s [%d]", text, num))
+}
+
+// renderList renders ordered and unordered lists, nested lists are indented
+func (r *textRenderer) renderList(n *html.Node) string {
+	items := []string{}
+	num := 1
+
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		num = start
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+
+		bullet := "* "
+		if n.DataAtom == atom.Ol {
+			bullet = strconv.Itoa(num) + ". "
+			num++
+		}
+
+		text := r.renderChildren(c, true)
+		items = append(items, prefixLines(text, bullet, strings.Repeat(" ", len(bullet))))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+// renderTable renders a table with aligned columns, header rows are underlined
+// Layout tables, marked as presentation or with a single column, are rendered as blocks
+func (r *textRenderer) renderTable(n *html.Node) string {
+	rows := tableRows(n)
+	layout := strings.EqualFold(attr(n, "role"), "presentation")
+
+	if !layout {
+		layout = true
+
+		for _, row := range rows {
+			if len(row) > 1 {
+				layout = false
+
+				break
+			}
+		}
+	}
+
+	if layout {
+		w := &textWriter{}
+
+		for _, row := range rows {
+			for _, cell := range row {
+				w.block(r.renderChildren(cell, false))
+			}
+		}
+
+		return w.String()
+	}
+
+	textRows := [][]string{}
+	headerRows := 0
+	widths := []int{}
+
+	for _, row := range rows {
+		isHeader := true
+		textRow := []string{}
+
+		for i, cell := range row {
+			if cell.DataAtom == atom.Td {
+				isHeader = false
+			}
+
+			text := collapse(r.renderChildren(cell, false))
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+
+			if l := utf8.RuneCountInString(text); l > widths[i] {
+				widths[i] = l
+			}
+
+			textRow = append(textRow, text)
+		}
+
+		if isHeader && headerRows == len(textRows) {
+			headerRows++
+		}
+
+		textRows = append(textRows, textRow)
+	}
+
+	lines := []string{}
+
+	for i, row := range textRows {
+		cells := make([]string, len(row))
+		for j, cell := range row {
+			cells[j] = cell + strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell))
+		}
+
+		lines = append(lines, strings.TrimRight(strings.Join(cells, " | "), " "))
+
+		if i == headerRows-1 {
+			dashes := make([]string, len(widths))
+			for j, width := range widths {
+				dashes[j] = strings.Repeat("-", width)
+			}
+
+			lines = append(lines, strings.Join(dashes, "-+-"))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// tableRows finds the cells of each row in a table, skipping any nested tables
+func tableRows(n *html.Node) [][]*html.Node {
+	rows := [][]*html.Node{}
+
+	var walk func(*html.Node)
+
+	walk = func(node *html.Node) {
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+
+			switch c.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			case atom.Tr:
+				row := []*html.Node{}
+
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+						row = append(row, cell)
+					}
+				}
+
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+
+	walk(n)
+
+	return rows
+}
+
+func renderHeading(level atom.Atom, text string) string {
+	switch level {
+	case atom.H1:
+		return text + "\n" + strings.Repeat("=", utf8.RuneCountInString(text))
+	case atom.H2:
+		return text + "\n" + strings.Repeat("-", utf8.RuneCountInString(text))
+	default:
+		return text
+	}
+}
+
+// prefixLines adds a prefix to the first line of text and another to all following lines
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// collapse squashes all runs of whitespace, including newlines, into single spaces
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// textContent returns the raw text of a node and its descendants, whitespace is preserved
+func textContent(n *html.Node) string {
+	sb := strings.Builder{}
+
+	var walk func(*html.Node)
+
+	walk = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			sb.WriteString(node.Data)
+		}
+
+		if node.Type == html.ElementNode && node.DataAtom == atom.Br {
+			sb.WriteString("\n")
+		}
+
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	walk(n)
+
+	return sb.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
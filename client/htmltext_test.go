@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHTMLToTextBasic(t *testing.T) {
+	text, err := HTMLToText("<html><head><title>Hi</title><style>p { color: red; }</style></head>" +
+		"<body><h1>Hello</h1><p>Some   <b>bold</b>\n text</p><script>alert('x')</script><p>Line<br>break</p></body></html>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Hello\n=====\n\nSome bold text\n\nLine\nbreak"
+	if text != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, text)
+	}
+}
+
+func TestHTMLToTextLinks(t *testing.T) {
+	text, err := HTMLToText(`<p>Visit <a href="https://example.com">our site</a> or
+		<a href="https://example.com">this</a> and <a href="https://other.net">https://other.net</a></p>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Visit our site [1] or this [1] and https://other.net\n\n[1] https://example.com"
+	if text != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, text)
+	}
+}
+
+func TestHTMLToTextLists(t *testing.T) {
+	text, err := HTMLToText(`<ul><li>One</li><li>Two<ul><li>Nested</li></ul></li></ul><ol start="3"><li>Three</li><li>Four</li></ol>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "* One\n* Two\n  * Nested\n\n3. Three\n4. Four"
+	if text != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, text)
+	}
+}
+
+func TestHTMLToTextTable(t *testing.T) {
+	text, err := HTMLToText(`<table><tr><th>Name</th><th>Qty</th></tr><tr><td>Apples</td><td>3</td></tr><tr><td>Kiwi</td><td>12</td></tr></table>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Name   | Qty\n-------+----\nApples | 3\nKiwi   | 12"
+	if text != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, text)
+	}
+}
+
+func TestPlainTextGeneration(t *testing.T) {
+	e := NewHTMLEmail(fromAddress, toAddress, subject, emailBody)
+
+	err := e.Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(e.Content.PlainText, "Hello!") {
+		t.Error("Expected plain text to be generated, got: " + e.Content.PlainText)
+	}
+
+	e = NewHTMLEmail(fromAddress, toAddress, subject, emailBody)
+	e.DisablePlainTextGeneration()
+	_ = e.Prepare()
+
+	if e.Content.PlainText != "" {
+		t.Error("Expected no plain text, got: " + e.Content.PlainText)
+	}
+}
+
+func TestSendLeavesEmailUnchanged(t *testing.T) {
+	sent := []Email{}
+
+	c := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		e := Email{}
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		sent = append(sent, e)
+
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	WithDefaults(Defaults{From: "DoNotReply@blah.net", DisableTracking: true})(c)
+
+	e := NewHTMLEmail("", toAddress, subject, "<p>Hello</p>")
+	e.AddCustomHeader("X-Campaign", "spring")
+	e.AddTransform(func(e *Email) error {
+		e.Content.HTML += "<p>Footer</p>"
+		e.AddCustomHeader("X-Footer", "yes")
+
+		return nil
+	})
+
+	// The same email sent twice, as a retry by the app would
+	for i := 0; i < 2; i++ {
+		if _, err := c.SendEmail(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if e.Content.HTML != "<p>Hello</p>" || e.Content.PlainText != "" || e.Sender != "" || e.Tracking || len(e.Headers) != 1 {
+		t.Errorf("the caller's email was changed: %+v", e)
+	}
+
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 sends, got %d", len(sent))
+	}
+
+	for _, s := range sent {
+		if s.Content.HTML != "<p>Hello</p><p>Footer</p>" || !strings.HasPrefix(s.Content.PlainText, "Hello") ||
+			s.Sender != "DoNotReply@blah.net" || len(s.Headers) != 2 {
+			t.Errorf("expected the transform and defaults to be applied once, got %+v", s)
+		}
+	}
+}
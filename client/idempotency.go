@@ -0,0 +1,94 @@
+package client
+
+// ==============================================================================
+// Idempotency keys supplied by the caller, mapped onto the ACS repeatability
+// headers. The same key always gives the same repeatability-request-id, and
+// the client remembers when a key was first sent so retries carry a stable
+// repeatability-first-sent
+// ==============================================================================
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// idempotencyWindow is how long the client remembers when a key was first sent
+const idempotencyWindow = 24 * time.Hour
+
+// idempotencyNamespace is used to derive request IDs from keys which are not UUIDs
+var idempotencyNamespace = uuid.MustParse("5f0c2a7e-4b1d-4a36-9d5e-acdc0e1d2b6f")
+
+// RepeatabilityResult is returned by ACS to say if a request was processed
+type RepeatabilityResult string
+
+const (
+	// RepeatabilityAccepted means the request was new and has been processed
+	RepeatabilityAccepted RepeatabilityResult = "accepted"
+
+	// RepeatabilityRejected means the request was a repeat of one already processed, so was not processed again
+	RepeatabilityRejected RepeatabilityResult = "rejected"
+)
+
+// AlreadyProcessed is true when ACS had already seen the request, and didn't send it again
+func (r RepeatabilityResult) AlreadyProcessed() bool {
+	return r == RepeatabilityRejected
+}
+
+// SendEmailResult contains the outcome of sending an email
+type SendEmailResult struct {
+	MessageID           string
+	RepeatabilityResult RepeatabilityResult // Empty if the API didn't return the header
+	RequestID           string              // The repeatability-request-id which was sent
+	FirstSent           string              // The repeatability-first-sent which was sent
+}
+
+// firstSentCache remembers when each idempotency key was first sent
+type firstSentCache struct {
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+// get returns when the key was first sent, recording now if it's new or has expired
+func (f *firstSentCache) get(key string) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	if f.times == nil {
+		f.times = map[string]time.Time{}
+	}
+
+	if first, found := f.times[key]; found && now.Sub(first) < idempotencyWindow {
+		return first
+	}
+
+	// Drop expired keys, so the cache doesn't grow forever
+	for k, first := range f.times {
+		if now.Sub(first) >= idempotencyWindow {
+			delete(f.times, k)
+		}
+	}
+
+	f.times[key] = now
+
+	return now
+}
+
+// Repeatability returns the repeatability headers for an idempotency key, as used when sending
+// with the key. Stores such as the outbox use it to save the headers, as the key isn't serialised
+func (c *Client) Repeatability(key string) (requestID, firstSent string) {
+	return idempotencyRequestID(key), c.firstSent.get(key).Format(http.TimeFormat)
+}
+
+// idempotencyRequestID uses the key as the request ID if it's a UUID, otherwise a UUID is derived from it
+func idempotencyRequestID(key string) string {
+	if id, err := uuid.Parse(key); err == nil {
+		return id.String()
+	}
+
+	return uuid.NewSHA1(idempotencyNamespace, []byte(key)).String()
+}
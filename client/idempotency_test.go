@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestIdempotencyKeyEmail(t *testing.T) {
+	mu := sync.Mutex{}
+	seen := map[string]string{}
+
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		id := r.Header.Get("repeatability-request-id")
+		firstSent := r.Header.Get("repeatability-first-sent")
+
+		result := "accepted"
+		if prev, found := seen[id]; found {
+			if prev != firstSent {
+				t.Errorf("first sent changed between retries: %s != %s", prev, firstSent)
+			}
+
+			result = "rejected"
+		}
+
+		seen[id] = firstSent
+
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.Header().Set("repeatability-result", result)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	e := NewPlainEmail(fromAddress, toAddress, subject, "Hello")
+	e.SetIdempotencyKey("order-1234")
+
+	first, err := client.SendEmailWithResult(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.RepeatabilityResult != RepeatabilityAccepted || first.RepeatabilityResult.AlreadyProcessed() {
+		t.Errorf("first send should be accepted, got %q", first.RepeatabilityResult)
+	}
+
+	// A new email with the same key, as an application level retry would do
+	retry := NewPlainEmail(fromAddress, toAddress, subject, "Hello")
+	retry.SetIdempotencyKey("order-1234")
+
+	second, err := client.SendEmailWithResult(context.Background(), retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second.RequestID != first.RequestID {
+		t.Errorf("request ID not stable: %s != %s", second.RequestID, first.RequestID)
+	}
+
+	if !second.RepeatabilityResult.AlreadyProcessed() {
+		t.Errorf("retry should be already processed, got %q", second.RepeatabilityResult)
+	}
+
+	other := NewPlainEmail(fromAddress, toAddress, subject, "Hello")
+	other.SetIdempotencyKey("order-5678")
+
+	third, err := client.SendEmailWithResult(context.Background(), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if third.RequestID == first.RequestID {
+		t.Error("different keys should give different request IDs")
+	}
+}
+
+func TestIdempotencyKeySMS(t *testing.T) {
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		s := &SMS{}
+		if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+			t.Fatal(err)
+		}
+
+		recipient := s.SMSRecipients[0]
+		if recipient.RepeatabilityRequestID != idempotencyRequestID("reminder-42") {
+			t.Errorf("request ID not derived from key: %s", recipient.RepeatabilityRequestID)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(SMSSendResponse{Value: []SMSSendResponseItem{{
+			To:                  recipient.To,
+			MessageID:           "sms-id",
+			Successful:          true,
+			HTTPStatusCode:      http.StatusAccepted,
+			RepeatabilityResult: RepeatabilityAccepted,
+		}}})
+	})
+
+	s := NewSMS("+10000000000", "+10000000001", smsMessage)
+	s.SetIdempotencyKey("reminder-42")
+
+	resp, err := client.SendSingleSMS(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.RepeatabilityResult != RepeatabilityAccepted {
+		t.Errorf("unexpected repeatability result %q", resp.RepeatabilityResult)
+	}
+}
+
+func TestIdempotencyKeyExplicitWins(t *testing.T) {
+	ids := []string{}
+
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get("repeatability-request-id"))
+
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	// As the scheduler does, a template email with a key is sent with a new ID for each run
+	for _, run := range []string{"0d9f1c1e-7a7b-4a39-9a4b-2d1f4e0f8f11", "9b2c7d4e-1f3a-4c5b-8d6e-7f8091a2b3c4"} {
+		e := NewPlainEmail(fromAddress, toAddress, subject, "Hello")
+		e.SetIdempotencyKey("daily-report")
+		e.RepeatabilityRequestID = run
+
+		if _, err := client.SendEmail(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(ids) != 2 || ids[0] == ids[1] || ids[0] == idempotencyRequestID("daily-report") {
+		t.Errorf("expected the explicit request IDs to be used, got %v", ids)
+	}
+}
+
+func TestIdempotencyRequestID(t *testing.T) {
+	id := "0d9f1c1e-7a7b-4a39-9a4b-2d1f4e0f8f11"
+	if idempotencyRequestID(id) != id {
+		t.Error("UUID keys should be used as they are")
+	}
+
+	if idempotencyRequestID("abc") != idempotencyRequestID("abc") {
+		t.Error("derived request IDs should be stable")
+	}
+}
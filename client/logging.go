@@ -0,0 +1,252 @@
+package client
+
+// ==============================================================================
+// Structured logging of requests and responses with log/slog, enabled with
+// the WithLogger or WithLogging options. Headers and bodies are only logged
+// in debug mode, and are passed through a redaction function first
+// ==============================================================================
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Redacted replaces values removed by the default redaction
+const Redacted = "[REDACTED]"
+
+// maxLogBody limits how much of a body is logged
+const maxLogBody = 64 * 1024
+
+// RedactFunc is called for every header and every string in a JSON body before
+// it's logged, and returns the value to log. The path is the header name prefixed
+// with "header.", or the dotted JSON path e.g. "recipients.to.email"
+type RedactFunc func(path, value string) string
+
+// LogOptions configures request and response logging
+type LogOptions struct {
+	Logger *slog.Logger
+
+	// Levels for each type of log, nil uses the default shown
+	RequestLevel  slog.Leveler // Defaults to Debug
+	ResponseLevel slog.Leveler // Defaults to Info
+	ErrorLevel    slog.Leveler // Failed requests and error responses, defaults to Error
+
+	// Debug logs headers and bodies, these are redacted first
+	Debug bool
+
+	// Redact is used on headers and bodies, defaults to DefaultRedact
+	Redact RedactFunc
+}
+
+// WithLogger logs requests and responses to the logger, with the default options
+func WithLogger(logger *slog.Logger) Option {
+	return WithLogging(LogOptions{Logger: logger})
+}
+
+// WithLogging logs requests and responses as configured
+func WithLogging(opts LogOptions) Option {
+	return func(c *Client) {
+		if opts.Logger == nil {
+			opts.Logger = slog.Default()
+		}
+
+		if opts.RequestLevel == nil {
+			opts.RequestLevel = slog.LevelDebug
+		}
+
+		if opts.ResponseLevel == nil {
+			opts.ResponseLevel = slog.LevelInfo
+		}
+
+		if opts.ErrorLevel == nil {
+			opts.ErrorLevel = slog.LevelError
+		}
+
+		if opts.Redact == nil {
+			opts.Redact = DefaultRedact
+		}
+
+		c.logging = &opts
+	}
+}
+
+// Fields which always hold personal data or message content
+var redactedFields = map[string]bool{
+	"email":              true,
+	"displayname":        true,
+	"sender":             true,
+	"from":               true,
+	"to":                 true,
+	"subject":            true,
+	"html":               true,
+	"plaintext":          true,
+	"message":            true,
+	"contentbytesbase64": true,
+	"value":              true, // Custom header values
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+[0-9][0-9 ()\-]{5,}[0-9]`) // ACS numbers are always E.164
+)
+
+// DefaultRedact removes the Authorization header, message content & attachments,
+// and anything which looks like an email address or phone number
+func DefaultRedact(path, value string) string {
+	name := strings.ToLower(path[strings.LastIndex(path, ".")+1:])
+
+	if strings.HasPrefix(path, "header.") {
+		if name == "authorization" {
+			return Redacted
+		}
+
+		return value
+	}
+
+	// Error messages are kept for troubleshooting, but still scrubbed below
+	if redactedFields[name] && !strings.HasPrefix(path, "error.") {
+		return Redacted
+	}
+
+	value = emailPattern.ReplaceAllString(value, Redacted)
+
+	return phonePattern.ReplaceAllString(value, Redacted)
+}
+
+// loggingPolicy logs each request and response
+func loggingPolicy(opts *LogOptions) Policy {
+	l := &requestLogger{opts: opts}
+
+	return l.policy
+}
+
+type requestLogger struct {
+	opts *LogOptions
+}
+
+func (t *requestLogger) policy(req *http.Request, next Next) (*http.Response, error) {
+	ctx := req.Context()
+	logger := t.opts.Logger
+
+	attrs := []any{
+		slog.String("method", req.Method),
+		slog.String("url", req.URL.Redacted()),
+	}
+
+	if op := OperationFromContext(ctx); op != "" {
+		attrs = append(attrs, slog.String("operation", op))
+	}
+
+	if t.opts.Debug {
+		reqAttrs := append([]any{t.headers(req.Header)}, attrs...)
+
+		if req.GetBody != nil {
+			if body, err := req.GetBody(); err == nil {
+				reqAttrs = append(reqAttrs, slog.String("body", t.body(body)))
+				body.Close()
+			}
+		}
+
+		logger.Log(ctx, t.opts.RequestLevel.Level(), "acs request", reqAttrs...)
+	} else {
+		logger.Log(ctx, t.opts.RequestLevel.Level(), "acs request", attrs...)
+	}
+
+	start := time.Now()
+	resp, err := next(req)
+	attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+
+	if err != nil {
+		logger.Log(ctx, t.opts.ErrorLevel.Level(), "acs request failed", append(attrs, slog.String("error", err.Error()))...)
+
+		return resp, err
+	}
+
+	attrs = append(attrs, slog.Int("status", resp.StatusCode))
+
+	if requestID := resp.Header.Get("x-ms-request-id"); requestID != "" {
+		attrs = append(attrs, slog.String("requestId", requestID))
+	}
+
+	if t.opts.Debug {
+		attrs = append(attrs, t.headers(resp.Header))
+
+		// Read the body to log it, and replace it so the caller can still read it
+		data, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+
+		if readErr == nil {
+			attrs = append(attrs, slog.String("body", t.body(io.NopCloser(bytes.NewReader(data)))))
+		}
+	}
+
+	level := t.opts.ResponseLevel.Level()
+	if resp.StatusCode >= http.StatusBadRequest {
+		level = t.opts.ErrorLevel.Level()
+	}
+
+	logger.Log(ctx, level, "acs response", attrs...)
+
+	return resp, nil
+}
+
+// headers returns the redacted headers as a log group
+func (t *requestLogger) headers(h http.Header) slog.Attr {
+	attrs := []any{}
+
+	for name, values := range h {
+		attrs = append(attrs, slog.String(name, t.opts.Redact("header."+name, strings.Join(values, ", "))))
+	}
+
+	return slog.Group("headers", attrs...)
+}
+
+// body reads and redacts a body, JSON bodies are redacted field by field
+func (t *requestLogger) body(r io.ReadCloser) string {
+	data, err := io.ReadAll(io.LimitReader(r, maxLogBody))
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+
+	var doc any
+	if json.Unmarshal(data, &doc) != nil {
+		return t.opts.Redact("body", string(data))
+	}
+
+	redacted, err := json.Marshal(redactJSON("", doc, t.opts.Redact))
+	if err != nil {
+		return Redacted
+	}
+
+	return string(redacted)
+}
+
+// redactJSON walks a decoded JSON document, redacting every string
+func redactJSON(path string, v any, redact RedactFunc) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+
+			val[k] = redactJSON(childPath, child, redact)
+		}
+	case []any:
+		for i, child := range val {
+			val[i] = redactJSON(path, child, redact)
+		}
+	case string:
+		return redact(path, val)
+	}
+
+	return v
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"code":"BadRequest","message":"Invalid address someone@example.net"}}`))
+	})
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	WithLogging(LogOptions{Logger: logger, Debug: true})(acsClient)
+
+	e := NewPlainEmail("sender@example.com", "someone@example.net", "Secret subject", "Secret body")
+	e.AddAttachmentRaw("file.txt", []byte("Secret attachment"), "txt")
+
+	_, err := acsClient.SendEmail(e)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	out := buf.String()
+
+	for _, want := range []string{"acs request", "acs response", "operation=SendEmail", "status=400", "requestId=msg-id", "level=ERROR"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log does not contain %q:\n%s", want, out)
+		}
+	}
+
+	for _, secret := range []string{"example.net", "example.com", "Secret", "HMAC-SHA256"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log contains %q:\n%s", secret, out)
+		}
+	}
+
+	// The caller still gets the error body, after it was logged
+	if !strings.Contains(err.Error(), "Invalid address") {
+		t.Errorf("error response body was lost: %s", err)
+	}
+}
+
+func TestLoggingNoBodies(t *testing.T) {
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	buf := &bytes.Buffer{}
+	WithLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))(acsClient)
+
+	_, err := acsClient.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "body=") || strings.Contains(buf.String(), "headers.") {
+		t.Errorf("bodies and headers should only be logged in debug mode:\n%s", buf.String())
+	}
+}
+
+func TestDefaultRedact(t *testing.T) {
+	tests := map[[2]string]string{
+		{"header.Authorization", "HMAC-SHA256 abc"}:  Redacted,
+		{"header.Content-Type", "application/json"}:  "application/json",
+		{"recipients.to.email", "a@b.com"}:           Redacted,
+		{"error.message", "Bad number +14255550123"}: "Bad number " + Redacted,
+		{"messageId", "0d9f1c1e-7a7b-4a39-9a4b"}:     "0d9f1c1e-7a7b-4a39-9a4b",
+	}
+
+	for in, want := range tests {
+		if got := DefaultRedact(in[0], in[1]); got != want {
+			t.Errorf("DefaultRedact(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package client
+
+// ==============================================================================
+// Markdown email bodies, rendered from CommonMark into HTML with an
+// email safe theme, where all styling is applied inline
+// ==============================================================================
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MarkdownTheme controls the look of emails rendered from Markdown
+// All values are CSS values, Header & Footer are blocks of HTML
+type MarkdownTheme struct {
+	FontFamily        string
+	FontSize          string
+	HeadingFontFamily string
+	MonoFontFamily    string
+	TextColor         string
+	MutedColor        string
+	HeadingColor      string
+	LinkColor         string
+	BackgroundColor   string
+	ContentColor      string // Background colour of the content area
+	CodeColor         string // Background colour of code and table headers
+	BorderColor       string
+	MaxWidth          string
+
+	Header string // HTML shown above the content, optional
+	Footer string // HTML shown below the content, optional
+}
+
+// DefaultMarkdownTheme is a plain light theme used by NewMarkdownEmail
+var DefaultMarkdownTheme = MarkdownTheme{
+	FontFamily:        "-apple-system, 'Segoe UI', Helvetica, Arial, sans-serif",
+	FontSize:          "15px",
+	HeadingFontFamily: "-apple-system, 'Segoe UI', Helvetica, Arial, sans-serif",
+	MonoFontFamily:    "Consolas, Menlo, 'Courier New', monospace",
+	TextColor:         "#24292f",
+	MutedColor:        "#57606a",
+	HeadingColor:      "#1f2328",
+	LinkColor:         "#0969da",
+	BackgroundColor:   "#f6f8fa",
+	ContentColor:      "#ffffff",
+	CodeColor:         "#eff1f3",
+	BorderColor:       "#d0d7de",
+	MaxWidth:          "640px",
+}
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.Table, extension.Strikethrough))
+
+var markdownLayout = template.Must(template.New("layout").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body style="{{.Styles.body}}">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0" style="{{.Styles.body}}">
+<tr><td align="center" style="padding:24px 12px">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0" style="{{.Styles.container}}">
+{{- if .Header}}
+<tr><td style="{{.Styles.header}}">{{.Header}}</td></tr>
+{{- end}}
+<tr><td style="{{.Styles.content}}">
+{{.Content}}
+</td></tr>
+{{- if .Footer}}
+<tr><td style="{{.Styles.footer}}">{{.Footer}}</td></tr>
+{{- end}}
+</table>
+</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// NewMarkdownEmail creates a new email from Markdown, using the default theme
+func NewMarkdownEmail(from, to, subject, md string) (*Email, error) {
+	return NewThemedMarkdownEmail(from, to, subject, md, DefaultMarkdownTheme)
+}
+
+// NewThemedMarkdownEmail creates a new email from Markdown, using the given theme
+func NewThemedMarkdownEmail(from, to, subject, md string, theme MarkdownTheme) (*Email, error) {
+	htmlBody, text, err := RenderMarkdown(md, theme)
+	if err != nil {
+		return nil, err
+	}
+
+	e := newEmail(from, to, subject)
+	e.Content.HTML = htmlBody
+	e.Content.PlainText = text
+
+	return e, nil
+}
+
+// RenderMarkdown renders CommonMark into a themed HTML document and a matching plain text version
+func RenderMarkdown(md string, theme MarkdownTheme) (htmlBody, text string, err error) {
+	buf := bytes.Buffer{}
+
+	err = markdown.Convert([]byte(md), &buf)
+	if err != nil {
+		return "", "", fmt.Errorf("error rendering markdown: %s", err)
+	}
+
+	content, err := applyTheme(buf.String(), theme)
+	if err != nil {
+		return "", "", err
+	}
+
+	out := strings.Builder{}
+
+	err = markdownLayout.Execute(&out, map[string]any{
+		"Styles":  layoutStyles(theme),
+		"Header":  theme.Header,
+		"Footer":  theme.Footer,
+		"Content": content,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("error rendering email layout: %s", err)
+	}
+
+	htmlBody = out.String()
+
+	text, err = HTMLToText(htmlBody)
+	if err != nil {
+		return "", "", err
+	}
+
+	return htmlBody, text, nil
+}
+
+// applyTheme adds inline styles to each element of a HTML fragment
+func applyTheme(fragment string, theme MarkdownTheme) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), body)
+	if err != nil {
+		return "", fmt.Errorf("error parsing rendered markdown: %s", err)
+	}
+
+	styles := themeStyles(theme)
+	out := bytes.Buffer{}
+
+	var walk func(n *html.Node)
+
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			style := styles[n.DataAtom]
+
+			// Code blocks are styled by the surrounding pre
+			if n.DataAtom == atom.Code && n.Parent != nil && n.Parent.DataAtom == atom.Pre {
+				style = "font-family:" + theme.MonoFontFamily
+			}
+
+			if style != "" {
+				setStyle(n, style)
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	for _, n := range nodes {
+		walk(n)
+
+		err = html.Render(&out, n)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return out.String(), nil
+}
+
+// setStyle adds style to an element, any existing inline style takes precedence
+func setStyle(n *html.Node, style string) {
+	for i, a := range n.Attr {
+		if a.Key == "style" {
+			n.Attr[i].Val = style + ";" + a.Val
+
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: style})
+}
+
+// layoutStyles returns the styles for the outer layout, escaped for use in attributes
+func layoutStyles(t MarkdownTheme) map[string]string {
+	text := fmt.Sprintf("font-family:%s;font-size:%s;color:%s", t.FontFamily, t.FontSize, t.TextColor)
+	styles := map[string]string{
+		"body": fmt.Sprintf("margin:0;padding:0;background-color:%s", t.BackgroundColor),
+		"container": fmt.Sprintf("max-width:%s;background-color:%s;border:1px solid %s;border-radius:6px",
+			t.MaxWidth, t.ContentColor, t.BorderColor),
+		"header":  fmt.Sprintf("padding:16px 24px;border-bottom:1px solid %s;%s", t.BorderColor, text),
+		"content": "padding:24px;line-height:1.5;" + text,
+		"footer": fmt.Sprintf("padding:16px 24px;border-top:1px solid %s;font-family:%s;font-size:12px;color:%s",
+			t.BorderColor, t.FontFamily, t.MutedColor),
+	}
+
+	for k, v := range styles {
+		styles[k] = html.EscapeString(v)
+	}
+
+	return styles
+}
+
+func themeStyles(t MarkdownTheme) map[atom.Atom]string {
+	heading := func(size string) string {
+		return fmt.Sprintf("margin:24px 0 12px;font-family:%s;font-size:%s;line-height:1.25;font-weight:600;color:%s",
+			t.HeadingFontFamily, size, t.HeadingColor)
+	}
+
+	code := fmt.Sprintf("font-family:%s;font-size:13px;background-color:%s;padding:2px 4px;border-radius:3px",
+		t.MonoFontFamily, t.CodeColor)
+	cell := fmt.Sprintf("border:1px solid %s;padding:6px 12px;text-align:left", t.BorderColor)
+
+	return map[atom.Atom]string{
+		atom.H1:         heading("26px"),
+		atom.H2:         heading("22px"),
+		atom.H3:         heading("18px"),
+		atom.H4:         heading("16px"),
+		atom.H5:         heading("14px"),
+		atom.H6:         heading("13px"),
+		atom.P:          "margin:0 0 16px",
+		atom.A:          fmt.Sprintf("color:%s;text-decoration:underline", t.LinkColor),
+		atom.Ul:         "margin:0 0 16px;padding-left:24px",
+		atom.Ol:         "margin:0 0 16px;padding-left:24px",
+		atom.Li:         "margin:0 0 4px",
+		atom.Blockquote: fmt.Sprintf("margin:0 0 16px;padding:0 0 0 12px;border-left:4px solid %s;color:%s", t.BorderColor, t.MutedColor),
+		atom.Pre: fmt.Sprintf("margin:0 0 16px;padding:12px;font-size:13px;line-height:1.4;background-color:%s;border-radius:4px;overflow-x:auto",
+			t.CodeColor),
+		atom.Code:  code,
+		atom.Table: "border-collapse:collapse;margin:0 0 16px",
+		atom.Th:    cell + ";font-weight:600;background-color:" + t.CodeColor,
+		atom.Td:    cell,
+		atom.Hr:    fmt.Sprintf("border:none;border-top:1px solid %s;margin:24px 0", t.BorderColor),
+		atom.Img:   "max-width:100%;height:auto;border:0",
+	}
+}
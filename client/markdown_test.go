@@ -0,0 +1,59 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+const markdownBody = `# Deployment complete
+
+Version **1.2.0** is now live, see the [release notes](https://example.com/notes).
+
+- API
+- Frontend
+
+| Service | Status |
+|---------|--------|
+| api     | ok     |
+`
+
+func TestMarkdownEmail(t *testing.T) {
+	e, err := NewMarkdownEmail(fromAddress, toAddress, subject, markdownBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(e.Content.HTML, "<h1 style=") || !strings.Contains(e.Content.HTML, "<strong>1.2.0</strong>") {
+		t.Error("Expected styled HTML, got: " + e.Content.HTML)
+	}
+
+	if strings.Contains(e.Content.HTML, "<style") {
+		t.Error("Expected no style blocks in HTML")
+	}
+
+	expected := "Deployment complete\n===================\n\nVersion 1.2.0 is now live, see the release notes [1].\n\n" +
+		"* API\n* Frontend\n\nService | Status\n--------+-------\napi     | ok\n\n[1] https://example.com/notes"
+	if e.Content.PlainText != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, e.Content.PlainText)
+	}
+}
+
+func TestMarkdownTheme(t *testing.T) {
+	theme := DefaultMarkdownTheme
+	theme.LinkColor = "#ff0000"
+	theme.Header = "<strong>ACME Alerts</strong>"
+	theme.Footer = "You are receiving this because you're on call"
+
+	e, err := NewThemedMarkdownEmail(fromAddress, toAddress, subject, "[link](https://example.com)", theme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(e.Content.HTML, "color:#ff0000") {
+		t.Error("Expected link colour in HTML")
+	}
+
+	if !strings.HasPrefix(e.Content.PlainText, "ACME Alerts\n\nlink [1]") || !strings.Contains(e.Content.PlainText, "on call") {
+		t.Error("Expected header and footer in plain text, got: " + e.Content.PlainText)
+	}
+}
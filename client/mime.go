@@ -0,0 +1,554 @@
+package client
+
+// ==============================================================================
+// Parse RFC 5322 / MIME messages, such as .eml files, into an Email, and write
+// an Email as a MIME message for previews and archiving
+// ==============================================================================
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Headers copied to the email's custom headers, as well as any X- headers
+var mimeCustomHeaders = []string{"In-Reply-To", "References", "List-Unsubscribe", "List-Unsubscribe-Post"}
+
+// Extensions for common attachment types, mime.ExtensionsByType picks unusual ones
+var mimeExtensions = map[string]string{
+	"application/pdf": "pdf",
+	"image/jpeg":      "jpeg",
+	"image/png":       "png",
+	"image/gif":       "gif",
+	"text/plain":      "txt",
+	"text/html":       "html",
+	"text/csv":        "csv",
+	"text/calendar":   "ics",
+	"message/rfc822":  "eml",
+}
+
+var mimeWordDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// UnmappedPart is a part of a MIME message which couldn't be mapped to the email
+type UnmappedPart struct {
+	ContentType string
+	Name        string // File name, if the part had one
+	Reason      string
+}
+
+// EmailFromMIME parses a MIME message into an email. Headers are mapped to the recipients,
+// reply to and custom headers, multipart/alternative bodies to the HTML and plain text
+// content, and other parts to attachments. Parts which can't be mapped, such as a second
+// HTML body or a calendar invite, are returned so the caller can decide what to do
+func EmailFromMIME(r io.Reader) (*Email, []UnmappedPart, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading message: %s", err)
+	}
+
+	e := &Email{Importance: ImportanceNormal}
+	p := &mimeParser{email: e}
+
+	e.Content.Subject = decodeHeader(msg.Header.Get("Subject"))
+
+	if from := addressList(msg.Header, "From"); len(from) > 0 {
+		e.Sender = from[0].Email
+		e.SenderName = from[0].DisplayName
+	}
+
+	e.Recipients.To = addressList(msg.Header, "To")
+	e.Recipients.CC = addressList(msg.Header, "Cc")
+	e.Recipients.BCC = addressList(msg.Header, "Bcc")
+	e.ReplyTo = addressList(msg.Header, "Reply-To")
+	e.Importance = importance(msg.Header)
+
+	names := make([]string, 0, len(msg.Header))
+	for name := range msg.Header {
+		// X-Priority is mapped to the importance
+		if (strings.HasPrefix(name, "X-") && name != "X-Priority") || containsFold(mimeCustomHeaders, name) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range msg.Header[name] {
+			e.AddCustomHeader(name, decodeHeader(value))
+		}
+	}
+
+	err = p.addPart(mimePart{
+		contentType: msg.Header.Get("Content-Type"),
+		encoding:    msg.Header.Get("Content-Transfer-Encoding"),
+		disposition: msg.Header.Get("Content-Disposition"),
+		body:        msg.Body,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e, p.unmapped, nil
+}
+
+type mimeParser struct {
+	email    *Email
+	unmapped []UnmappedPart
+}
+
+type mimePart struct {
+	contentType string
+	encoding    string
+	disposition string
+	body        io.Reader
+}
+
+// addPart maps a part to the email, walking into multipart parts
+func (p *mimeParser) addPart(part mimePart) error {
+	if part.contentType == "" {
+		part.contentType = "text/plain"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(part.contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return p.addMultipart(mediaType, params["boundary"], part.body)
+	}
+
+	dispType, dispParams, _ := mime.ParseMediaType(part.disposition)
+
+	name := dispParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+
+	name = decodeHeader(name)
+
+	body, err := transferDecoder(part.encoding, part.body)
+	if err != nil {
+		p.unmap(mediaType, name, err.Error())
+
+		return nil
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		p.unmap(mediaType, name, fmt.Sprintf("error decoding: %s", err))
+
+		return nil
+	}
+
+	// Text bodies unless they're attached, the first of each type is used
+	isBody := dispType != "attachment" && name == "" && strings.HasPrefix(mediaType, "text/")
+
+	switch {
+	case isBody && mediaType == "text/html" && p.email.Content.HTML == "":
+		p.email.Content.HTML, err = decodeCharset(params["charset"], data)
+	case isBody && mediaType == "text/plain" && p.email.Content.PlainText == "":
+		p.email.Content.PlainText, err = decodeCharset(params["charset"], data)
+	case isBody && (mediaType == "text/html" || mediaType == "text/plain"):
+		p.unmap(mediaType, name, "the email already has a "+mediaType+" body")
+	case isBody:
+		p.unmap(mediaType, name, "body type not supported by ACS")
+	default:
+		name, attachmentType := attachmentName(name, mediaType)
+		p.email.AddAttachmentRaw(name, data, attachmentType)
+	}
+
+	if err != nil {
+		p.unmap(mediaType, name, err.Error())
+	}
+
+	return nil
+}
+
+func (p *mimeParser) addMultipart(mediaType, boundary string, body io.Reader) error {
+	if boundary == "" {
+		p.unmap(mediaType, "", "missing boundary")
+
+		return nil
+	}
+
+	mr := multipart.NewReader(body, boundary)
+
+	for {
+		// Quoted printable is decoded by NextPart, and the header removed
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading %s part: %s", mediaType, err)
+		}
+
+		err = p.addPart(mimePart{
+			contentType: part.Header.Get("Content-Type"),
+			encoding:    part.Header.Get("Content-Transfer-Encoding"),
+			disposition: part.Header.Get("Content-Disposition"),
+			body:        part,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *mimeParser) unmap(contentType, name, reason string) {
+	p.unmapped = append(p.unmapped, UnmappedPart{ContentType: contentType, Name: name, Reason: reason})
+}
+
+func transferDecoder(encoding string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "7bit", "8bit", "binary":
+		return r, nil
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: r}), nil
+	case "quoted-printable":
+		return quotedprintable.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported transfer encoding %q", encoding)
+	}
+}
+
+// base64Cleaner removes spaces and tabs, the base64 decoder only skips line breaks
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	clean := 0
+
+	for _, b := range p[:n] {
+		if b != ' ' && b != '\t' {
+			p[clean] = b
+			clean++
+		}
+	}
+
+	return clean, err
+}
+
+// decodeCharset converts text to UTF-8
+func decodeCharset(label string, data []byte) (string, error) {
+	label = strings.ToLower(label)
+	if label == "" || label == "utf-8" || label == "us-ascii" {
+		return string(data), nil
+	}
+
+	r, err := charset.NewReaderLabel(label, bytes.NewReader(data))
+	if err != nil {
+		return string(data), fmt.Errorf("unknown charset %q", label)
+	}
+
+	text, err := io.ReadAll(r)
+
+	return string(text), err
+}
+
+// decodeHeader decodes RFC 2047 encoded words, returning the value as is if it can't
+func decodeHeader(value string) string {
+	decoded, err := mimeWordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+
+	return decoded
+}
+
+func addressList(h mail.Header, name string) []Address {
+	parser := mail.AddressParser{WordDecoder: mimeWordDecoder}
+
+	list, err := parser.ParseList(h.Get(name))
+	if err != nil {
+		return nil
+	}
+
+	addresses := make([]Address, 0, len(list))
+	for _, a := range list {
+		addresses = append(addresses, Address{Email: a.Address, DisplayName: a.Name})
+	}
+
+	return addresses
+}
+
+// importance maps the Importance or X-Priority headers
+func importance(h mail.Header) string {
+	value := strings.ToLower(h.Get("Importance"))
+
+	switch {
+	case value == ImportanceHigh || strings.HasPrefix(h.Get("X-Priority"), "1") || strings.HasPrefix(h.Get("X-Priority"), "2"):
+		return ImportanceHigh
+	case value == ImportanceLow || strings.HasPrefix(h.Get("X-Priority"), "4") || strings.HasPrefix(h.Get("X-Priority"), "5"):
+		return ImportanceLow
+	default:
+		return ImportanceNormal
+	}
+}
+
+// attachmentName returns a name for the attachment, and the type ACS expects,
+// which is the file extension
+func attachmentName(name, mediaType string) (string, string) {
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+
+	if ext == "" {
+		ext = mimeExtensions[mediaType]
+	}
+
+	if ext == "" {
+		ext = "bin"
+		if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+			ext = strings.TrimPrefix(exts[0], ".")
+		}
+	}
+
+	if name == "" {
+		name = "attachment." + ext
+	}
+
+	return name, strings.ToLower(ext)
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Length of base64 lines in attachments, as recommended by RFC 2045
+const base64LineLength = 76
+
+// ValidHeaderName checks a header name is a RFC 5322 field name, which is
+// printable ASCII characters (33 to 126) other than a colon
+func ValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 || name[i] == ':' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// createPart starts a MIME part with the given headers, returning where to write the body
+type createPart func(h textproto.MIMEHeader) (io.Writer, error)
+
+// WriteMIME writes the email as a MIME message, which mail clients can open as a .eml file.
+// BCC recipients are included, as in a sent items copy. Call Prepare first to include
+// transforms and generated plain text, as sending does
+func (e *Email) WriteMIME(w io.Writer) error {
+	// Names are written as they are, so one with a colon or line break could add other headers
+	for _, h := range e.Headers {
+		if !ValidHeaderName(h.Name) {
+			return fmt.Errorf("invalid header name %q", h.Name)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	headers := [][2]string{
+		{"From", formatAddresses([]Address{{DisplayName: e.SenderName, Email: e.Sender}})},
+		{"To", formatAddresses(e.Recipients.To)},
+		{"Cc", formatAddresses(e.Recipients.CC)},
+		{"Bcc", formatAddresses(e.Recipients.BCC)},
+		{"Reply-To", formatAddresses(e.ReplyTo)},
+		{"Subject", mime.QEncoding.Encode("utf-8", e.Content.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+	}
+
+	switch e.Importance {
+	case ImportanceHigh:
+		headers = append(headers, [2]string{"Importance", "high"}, [2]string{"X-Priority", "1 (Highest)"})
+	case ImportanceLow:
+		headers = append(headers, [2]string{"Importance", "low"}, [2]string{"X-Priority", "5 (Lowest)"})
+	}
+
+	for _, h := range e.Headers {
+		headers = append(headers, [2]string{h.Name, mime.QEncoding.Encode("utf-8", h.Value)})
+	}
+
+	for _, h := range headers {
+		if h[1] != "" {
+			fmt.Fprintf(bw, "%s: %s\r\n", h[0], h[1])
+		}
+	}
+
+	err := e.writeMixed(func(h textproto.MIMEHeader) (io.Writer, error) {
+		keys := make([]string, 0, len(h))
+		for key := range h {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(bw, "%s: %s\r\n", key, h.Get(key))
+		}
+
+		_, err := bw.WriteString("\r\n")
+
+		return bw, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// writeMixed writes the content, followed by any attachments
+func (e *Email) writeMixed(create createPart) error {
+	if len(e.Attachments) == 0 {
+		return e.writeAlternative(create)
+	}
+
+	mw, err := createMultipart(create, "multipart/mixed")
+	if err != nil {
+		return err
+	}
+
+	if err := e.writeAlternative(mw.CreatePart); err != nil {
+		return err
+	}
+
+	for _, a := range e.Attachments {
+		if err := writeAttachment(mw.CreatePart, a); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+// writeAlternative writes the plain text and HTML content, mail clients show the last they support
+func (e *Email) writeAlternative(create createPart) error {
+	if e.Content.HTML == "" {
+		return writeText(create, "text/plain", e.Content.PlainText)
+	}
+
+	if e.Content.PlainText == "" {
+		return writeText(create, "text/html", e.Content.HTML)
+	}
+
+	mw, err := createMultipart(create, "multipart/alternative")
+	if err != nil {
+		return err
+	}
+
+	if err := writeText(mw.CreatePart, "text/plain", e.Content.PlainText); err != nil {
+		return err
+	}
+
+	if err := writeText(mw.CreatePart, "text/html", e.Content.HTML); err != nil {
+		return err
+	}
+
+	return mw.Close()
+}
+
+func createMultipart(create createPart, mediaType string) (*multipart.Writer, error) {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	w, err := create(textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType(mediaType, map[string]string{"boundary": boundary})},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mw := multipart.NewWriter(w)
+
+	return mw, mw.SetBoundary(boundary)
+}
+
+func writeText(create createPart, mediaType, text string) error {
+	w, err := create(textproto.MIMEHeader{
+		"Content-Type":              {mediaType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(w)
+
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+
+	return qp.Close()
+}
+
+// writeAttachment writes an attachment, the content is already base64 encoded
+func writeAttachment(create createPart, a Attachment) error {
+	contentType := mime.TypeByExtension("." + a.AttachmentType)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w, err := create(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+
+	for content := a.Content; len(content) > 0; {
+		line := content[:min(base64LineLength, len(content))]
+		content = content[len(line):]
+
+		if _, err := io.WriteString(w, line+"\r\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// formatAddresses formats addresses for a header, leaving out display names which are just the address
+func formatAddresses(addresses []Address) string {
+	formatted := make([]string, 0, len(addresses))
+
+	for _, a := range addresses {
+		if a.Email == "" {
+			continue
+		}
+
+		name := a.DisplayName
+		if name == a.Email {
+			name = ""
+		}
+
+		formatted = append(formatted, (&mail.Address{Name: name, Address: a.Email}).String())
+	}
+
+	return strings.Join(formatted, ", ")
+}
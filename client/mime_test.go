@@ -0,0 +1,171 @@
+package client
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const testMIME = "From: =?utf-8?b?U3RlcGhhbmllIETDvHJy?= <steph@example.net>\r\n" +
+	"To: Alice <alice@example.net>, bob@example.net\r\n" +
+	"Cc: carol@example.net\r\n" +
+	"Reply-To: support@example.net\r\n" +
+	"Subject: =?iso-8859-1?q?Caf=E9_menu?=\r\n" +
+	"X-Priority: 1\r\n" +
+	"X-Campaign: spring\r\n" +
+	"Received: from somewhere\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Today's caf=E9 special is a very long line which has been wrapped with a soft=\r\n" +
+	" line break\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PHA+VG9kYXkncyBjYWbDqSBzcGVjaWFs\r\n" +
+	"PC9wPg==\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/calendar; method=REQUEST\r\n" +
+	"\r\n" +
+	"BEGIN:VCALENDAR\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"=?utf-8?q?men=C3=BC.pdf?=\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0x\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/jpeg\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"/9j/\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>Second body</p>\r\n" +
+	"--outer--\r\n"
+
+func TestEmailFromMIME(t *testing.T) {
+	e, unmapped, err := EmailFromMIME(strings.NewReader(testMIME))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Sender != "steph@example.net" || e.SenderName != "Stephanie Dürr" || e.Content.Subject != "Café menu" {
+		t.Errorf("unexpected sender %q %q or subject %q", e.SenderName, e.Sender, e.Content.Subject)
+	}
+
+	if len(e.Recipients.To) != 2 || e.Recipients.To[0].DisplayName != "Alice" || len(e.Recipients.CC) != 1 ||
+		len(e.ReplyTo) != 1 || e.ReplyTo[0].Email != "support@example.net" {
+		t.Errorf("unexpected recipients %+v or reply to %+v", e.Recipients, e.ReplyTo)
+	}
+
+	if e.Importance != ImportanceHigh {
+		t.Errorf("expected high importance from X-Priority, got %q", e.Importance)
+	}
+
+	if len(e.Headers) != 1 || e.Headers[0].Name != "X-Campaign" {
+		t.Errorf("unexpected custom headers %+v", e.Headers)
+	}
+
+	if e.Content.PlainText != "Today's café special is a very long line which has been wrapped with a soft line break" {
+		t.Errorf("unexpected plain text %q", e.Content.PlainText)
+	}
+
+	if e.Content.HTML != "<p>Today's café special</p>" {
+		t.Errorf("unexpected HTML %q", e.Content.HTML)
+	}
+
+	if len(e.Attachments) != 2 || e.Attachments[0].Name != "menü.pdf" || e.Attachments[0].AttachmentType != "pdf" ||
+		e.Attachments[0].Content != "JVBERi0x" || e.Attachments[1].AttachmentType != "jpeg" {
+		t.Errorf("unexpected attachments %+v", e.Attachments)
+	}
+
+	if len(unmapped) != 2 || unmapped[0].ContentType != "text/calendar" || unmapped[1].ContentType != "text/html" {
+		t.Errorf("expected the calendar and second HTML part to be unmapped, got %+v", unmapped)
+	}
+}
+
+func TestEmailFromMIMESimple(t *testing.T) {
+	e, unmapped, err := EmailFromMIME(strings.NewReader("From: a@example.net\r\nTo: b@example.net\r\n\r\nHello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Content.PlainText != "Hello" || len(unmapped) != 0 {
+		t.Errorf("unexpected content %q or unmapped parts %+v", e.Content.PlainText, unmapped)
+	}
+
+	if _, _, err := EmailFromMIME(strings.NewReader("not a message")); err == nil {
+		t.Error("expected an error for an invalid message")
+	}
+}
+
+func TestWriteMIME(t *testing.T) {
+	e := NewHTMLEmail("DoNotReply@blah.net", "alice@example.net", "Café menu", "<p>Today's café special</p>")
+	e.AddCC("bob@example.net", "Bob Smith")
+	e.AddBCC("carol@example.net", "")
+	e.AddReplyTo("support@example.net", "Support")
+	e.AddCustomHeader("X-Campaign", "spring")
+	e.AddAttachmentRaw("menü.pdf", []byte(strings.Repeat("%PDF-1.4 ", 20)), "pdf")
+	e.Importance = ImportanceHigh
+
+	if err := e.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &strings.Builder{}
+	if err := e.WriteMIME(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "X-Priority: 1 (Highest)\r\n") {
+		t.Error("expected a X-Priority header")
+	}
+
+	// Parsing the message should give back the same email
+	parsed, unmapped, err := EmailFromMIME(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unmapped) != 0 {
+		t.Errorf("unexpected unmapped parts %+v", unmapped)
+	}
+
+	if parsed.Sender != e.Sender || parsed.Content != e.Content || parsed.Importance != ImportanceHigh {
+		t.Errorf("content changed, got %+v", parsed.Content)
+	}
+
+	if len(parsed.Recipients.To) != 1 || parsed.Recipients.CC[0] != e.Recipients.CC[0] ||
+		parsed.Recipients.BCC[0].Email != "carol@example.net" || parsed.ReplyTo[0] != e.ReplyTo[0] {
+		t.Errorf("recipients changed, got %+v", parsed.Recipients)
+	}
+
+	if len(parsed.Headers) != 1 || parsed.Headers[0] != e.Headers[0] {
+		t.Errorf("headers changed, got %+v", parsed.Headers)
+	}
+
+	if len(parsed.Attachments) != 1 || parsed.Attachments[0] != e.Attachments[0] {
+		t.Errorf("attachments changed, got %+v", parsed.Attachments)
+	}
+
+	// Header names which could add other headers are rejected
+	for _, name := range []string{"X-Bad\r\nBcc", "X-Bad: evil", "X Bad", "X-Café", ""} {
+		bad := NewPlainEmail("DoNotReply@blah.net", "alice@example.net", "Hi", "Hello")
+		bad.AddCustomHeader(name, "value")
+
+		if err := bad.WriteMIME(io.Discard); err == nil {
+			t.Errorf("expected an error for header name %q", name)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package client
+
+// ==============================================================================
+// MultiClient sends through several ACS resources, e.g. in different regions,
+// failing over when one is unhealthy. Each resource has a circuit breaker which
+// opens on consecutive 5xx errors or timeouts, so unhealthy resources are
+// skipped until they have cooled down. Only failures which show the message
+// wasn't accepted fail over, as resources can't dedupe each other's sends
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// statusRetention is how long the resource used to send a message is remembered, for status lookups
+const statusRetention = 48 * time.Hour
+
+// Strategy decides the order resources are tried in
+type Strategy int
+
+const (
+	// StrategyPriority always tries resources in the order given, the first is the primary
+	StrategyPriority Strategy = iota
+
+	// StrategyRoundRobin spreads calls evenly, starting with the next resource on each call
+	StrategyRoundRobin
+
+	// StrategyWeighted spreads calls in proportion to each resource's Weight
+	StrategyWeighted
+)
+
+// ErrNoHealthyResource is returned when every resource failed or has an open circuit breaker
+var ErrNoHealthyResource = errors.New("no healthy ACS resource available")
+
+// ErrUnknownMessage is returned for status lookups of messages not sent by this MultiClient
+var ErrUnknownMessage = errors.New("message was not sent by this client")
+
+// Resource is an ACS resource used by a MultiClient
+type Resource struct {
+	Name   string  // Unique name, returned in results e.g. "westeurope"
+	Client *Client // Client for the resource, with its own endpoint and key
+	Weight int     // Used by StrategyWeighted, defaults to 1
+
+	// Senders are per resource, as domains and numbers belong to a resource.
+	// When set these replace the email Sender and SMS From for this resource
+	Sender  string
+	SMSFrom string
+}
+
+// MultiOptions configures a MultiClient, zero values use the defaults
+type MultiOptions struct {
+	Strategy         Strategy
+	FailureThreshold int           // Consecutive failures which open a breaker, defaults to 5
+	Cooldown         time.Duration // Time a breaker stays open before a trial call, defaults to 30s
+
+	// OnStateChange is called when the breaker for a resource changes state, with the resource name
+	OnStateChange func(resource string, from, to BreakerState)
+
+	// FailoverOnTimeout also fails over when a request times out, or the connection fails after
+	// it was sent. The first resource may have accepted the message, so it can be delivered twice
+	FailoverOnTimeout bool
+}
+
+// MultiResult says which resource handled a call, and the outcome
+type MultiResult struct {
+	Resource            string // Name of the resource which handled the message
+	MessageID           string
+	RepeatabilityResult RepeatabilityResult
+	SMS                 *SMSSendResponseItem // Set for SMS sends
+	Failovers           []ResourceError      // Resources tried before this one, and why they failed
+}
+
+// ResourceError is a failure of a single resource
+type ResourceError struct {
+	Resource string
+	Err      error
+}
+
+// ResourceHealth is the health of a resource, from its circuit breaker
+type ResourceHealth struct {
+	Resource            string
+	State               BreakerState
+	ConsecutiveFailures int
+}
+
+type resource struct {
+	Resource
+	breaker *breaker
+	current int // Used for smooth weighted round robin
+}
+
+type sentMessage struct {
+	resource *resource
+	sentAt   time.Time
+}
+
+// MultiClient sends through several ACS resources with failover and load balancing
+type MultiClient struct {
+	resources         []*resource
+	strategy          Strategy
+	failoverOnTimeout bool
+
+	mu        sync.Mutex
+	next      int
+	sent      map[string]sentMessage
+	lastPrune time.Time
+}
+
+// NewMultiClient creates a client which uses the resources with the given strategy
+func NewMultiClient(resources []Resource, opts MultiOptions) (*MultiClient, error) {
+	if len(resources) == 0 {
+		return nil, errors.New("at least one resource is required")
+	}
+
+	m := &MultiClient{
+		strategy:          opts.Strategy,
+		failoverOnTimeout: opts.FailoverOnTimeout,
+		sent:              map[string]sentMessage{},
+	}
+
+	names := map[string]bool{}
+
+	for _, r := range resources {
+		if r.Client == nil {
+			return nil, fmt.Errorf("resource %q has no client", r.Name)
+		}
+
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate resource name %q", r.Name)
+		}
+
+		if r.Weight < 1 {
+			r.Weight = 1
+		}
+
+		names[r.Name] = true
+		m.resources = append(m.resources, &resource{
+			Resource: r,
+			breaker: newBreaker(r.Name, BreakerOptions{
+				ServerErrorThreshold: opts.FailureThreshold,
+				TimeoutThreshold:     opts.FailureThreshold,
+				ThrottleThreshold:    -1, // Throttling fails over, but isn't a sign of an unhealthy resource
+				Cooldown:             opts.Cooldown,
+				OnStateChange:        opts.OnStateChange,
+			}),
+		})
+	}
+
+	return m, nil
+}
+
+// SendEmail sends an email through the first healthy resource, failing over to the others
+func (m *MultiClient) SendEmail(ctx context.Context, e *Email) (*MultiResult, error) {
+	return m.send(ctx, func(r *resource, result *MultiResult) error {
+		email := *e
+		if r.Sender != "" {
+			email.Sender = r.Sender
+		}
+
+		sent, err := r.Client.SendEmailWithResult(ctx, &email)
+		if err != nil {
+			return err
+		}
+
+		result.MessageID = sent.MessageID
+		result.RepeatabilityResult = sent.RepeatabilityResult
+
+		return nil
+	})
+}
+
+// SendSMS sends a SMS through the first healthy resource, failing over to the others
+func (m *MultiClient) SendSMS(ctx context.Context, s *SMS) (*MultiResult, error) {
+	return m.send(ctx, func(r *resource, result *MultiResult) error {
+		sms := *s
+		if r.SMSFrom != "" {
+			sms.From = r.SMSFrom
+		}
+
+		resp, err := r.Client.SendSingleSMSContext(ctx, &sms)
+		if err != nil {
+			return err
+		}
+
+		// A recipient failure is treated like an API error, so server errors fail over
+		if err := resp.Err(); err != nil {
+			return err
+		}
+
+		result.MessageID = resp.MessageID
+		result.RepeatabilityResult = resp.RepeatabilityResult
+		result.SMS = resp
+
+		return nil
+	})
+}
+
+// GetEmailStatus gets the status of an email from the resource which sent it
+func (m *MultiClient) GetEmailStatus(ctx context.Context, messageID string) (string, error) {
+	m.mu.Lock()
+	sent, found := m.sent[messageID]
+	m.mu.Unlock()
+
+	if !found {
+		return "", ErrUnknownMessage
+	}
+
+	return sent.resource.Client.GetEmailStatusContext(ctx, messageID)
+}
+
+// GetEmailStatusFrom gets the status of an email from a named resource, e.g. after a restart
+func (m *MultiClient) GetEmailStatusFrom(ctx context.Context, resourceName, messageID string) (string, error) {
+	for _, r := range m.resources {
+		if r.Name == resourceName {
+			return r.Client.GetEmailStatusContext(ctx, messageID)
+		}
+	}
+
+	return "", fmt.Errorf("unknown resource %q", resourceName)
+}
+
+// Health returns the health of each resource, in the order they were given
+func (m *MultiClient) Health() []ResourceHealth {
+	health := []ResourceHealth{}
+
+	for _, r := range m.resources {
+		state, failures := r.breaker.current()
+		health = append(health, ResourceHealth{Resource: r.Name, State: state, ConsecutiveFailures: failures})
+	}
+
+	return health
+}
+
+// send tries each resource in turn until one succeeds, or an error can't be fixed by failing over
+func (m *MultiClient) send(ctx context.Context, call func(r *resource, result *MultiResult) error) (*MultiResult, error) {
+	result := &MultiResult{}
+
+	var lastErr error
+
+	for _, r := range m.order() {
+		if r.breaker.allow() != nil {
+			continue
+		}
+
+		err := call(r, result)
+
+		// Cancellation by the caller says nothing about the health of the resource
+		if ctx.Err() != nil {
+			r.breaker.record(kindNone)
+
+			return nil, ctx.Err()
+		}
+
+		r.breaker.record(classifyError(err))
+
+		if err == nil {
+			result.Resource = r.Name
+			m.remember(result.MessageID, r)
+
+			return result, nil
+		}
+
+		lastErr = err
+		result.Failovers = append(result.Failovers, ResourceError{Resource: r.Name, Err: err})
+
+		// Errors such as bad requests would fail on every resource, and after a timeout the
+		// message may have been accepted, so sending it again could deliver it twice
+		if !m.canFailover(err) {
+			return nil, err
+		}
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoHealthyResource, lastErr)
+	}
+
+	return nil, ErrNoHealthyResource
+}
+
+// canFailover is true for errors which show the resource didn't accept the message: an open
+// breaker, client side rate limits, throttling, 5xx errors and failing to connect. Timeouts
+// and other network errors only fail over when FailoverOnTimeout is set
+func (m *MultiClient) canFailover(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	apiErr := &APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	opErr := &net.OpError{}
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	return m.failoverOnTimeout && IsRetryable(err)
+}
+
+// order returns the resources in the order to try them, for the strategy
+func (m *MultiClient) order() []*resource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	first := 0
+
+	switch m.strategy {
+	case StrategyRoundRobin:
+		first = m.next % len(m.resources)
+		m.next++
+	case StrategyWeighted:
+		// Smooth weighted round robin, spreads calls evenly while honouring the weights
+		total := 0
+
+		for i, r := range m.resources {
+			r.current += r.Weight
+			total += r.Weight
+
+			if r.current > m.resources[first].current {
+				first = i
+			}
+		}
+
+		m.resources[first].current -= total
+	}
+
+	// The chosen resource first, then the rest in priority order as fallbacks
+	ordered := []*resource{m.resources[first]}
+
+	for i, r := range m.resources {
+		if i != first {
+			ordered = append(ordered, r)
+		}
+	}
+
+	return ordered
+}
+
+// remember records which resource sent a message, and forgets old messages
+func (m *MultiClient) remember(messageID string, r *resource) {
+	if messageID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	// Pruning is done hourly, not on every send
+	if now.Sub(m.lastPrune) > time.Hour {
+		for id, sent := range m.sent {
+			if now.Sub(sent.sentAt) > statusRetention {
+				delete(m.sent, id)
+			}
+		}
+
+		m.lastPrune = now
+	}
+
+	m.sent[messageID] = sentMessage{resource: r, sentAt: now}
+}
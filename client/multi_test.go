@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeResource creates a resource whose API returns the given status, or 202 and 200
+func newFakeResource(t *testing.T, name string, failStatus *int32, calls *int32) Resource {
+	t.Helper()
+
+	c := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+
+		if status := atomic.LoadInt32(failStatus); status != 0 {
+			w.WriteHeader(int(status))
+
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/status") {
+			_, _ = w.Write([]byte(`{"messageId":"x","status":"Delivered by ` + name + `"}`))
+
+			return
+		}
+
+		w.Header().Set("x-ms-request-id", name+"-msg")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	return Resource{Name: name, Client: c}
+}
+
+func TestMultiClientFailover(t *testing.T) {
+	primaryFail, secondaryFail := int32(http.StatusServiceUnavailable), int32(0)
+	primaryCalls, secondaryCalls := int32(0), int32(0)
+
+	m, err := NewMultiClient([]Resource{
+		newFakeResource(t, "primary", &primaryFail, &primaryCalls),
+		newFakeResource(t, "secondary", &secondaryFail, &secondaryCalls),
+	}, MultiOptions{FailureThreshold: 2, Cooldown: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		result, err := m.SendEmail(ctx, NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Resource != "secondary" || result.MessageID != "secondary-msg" {
+			t.Errorf("expected secondary to handle the message, got %+v", result)
+		}
+	}
+
+	// The breaker opened after 2 failures, so the third send skipped the primary
+	if primaryCalls != 2 {
+		t.Errorf("expected 2 calls to the primary, got %d", primaryCalls)
+	}
+
+	if h := m.Health(); h[0].State != BreakerOpen || h[1].State != BreakerClosed {
+		t.Errorf("unexpected health %+v", h)
+	}
+
+	// Status must come from the resource which sent the message
+	status, err := m.GetEmailStatus(ctx, "secondary-msg")
+	if err != nil || status != "Delivered by secondary" {
+		t.Errorf("unexpected status %q %v", status, err)
+	}
+
+	if _, err = m.GetEmailStatus(ctx, "unknown"); !errors.Is(err, ErrUnknownMessage) {
+		t.Errorf("expected ErrUnknownMessage, got %v", err)
+	}
+
+	// After the cool down a trial call closes the breaker again
+	atomic.StoreInt32(&primaryFail, 0)
+	time.Sleep(60 * time.Millisecond)
+
+	result, err := m.SendEmail(ctx, NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if err != nil || result.Resource != "primary" {
+		t.Errorf("expected primary to recover, got %+v %v", result, err)
+	}
+}
+
+func TestMultiClientNoFailoverOnBadRequest(t *testing.T) {
+	primaryFail, secondaryFail := int32(http.StatusBadRequest), int32(0)
+	primaryCalls, secondaryCalls := int32(0), int32(0)
+
+	m, _ := NewMultiClient([]Resource{
+		newFakeResource(t, "primary", &primaryFail, &primaryCalls),
+		newFakeResource(t, "secondary", &secondaryFail, &secondaryCalls),
+	}, MultiOptions{})
+
+	_, err := m.SendEmail(context.Background(), NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	if secondaryCalls != 0 {
+		t.Error("bad requests should not fail over")
+	}
+
+	atomic.StoreInt32(&primaryFail, http.StatusInternalServerError)
+	atomic.StoreInt32(&secondaryFail, http.StatusInternalServerError)
+
+	_, err = m.SendEmail(context.Background(), NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if !errors.Is(err, ErrNoHealthyResource) {
+		t.Errorf("expected ErrNoHealthyResource, got %v", err)
+	}
+}
+
+func TestMultiClientFailoverOnTimeout(t *testing.T) {
+	slow := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	WithTimeout(20 * time.Millisecond)(slow)
+
+	secondaryFail, secondaryCalls := int32(0), int32(0)
+	secondary := newFakeResource(t, "secondary", &secondaryFail, &secondaryCalls)
+
+	// The slow resource may have accepted the message, so it's not sent again by default
+	m, _ := NewMultiClient([]Resource{{Name: "slow", Client: slow}, secondary}, MultiOptions{})
+
+	if _, err := m.SendEmail(context.Background(), NewPlainEmail(fromAddress, toAddress, subject, "Hello")); err == nil {
+		t.Error("expected the timeout to be returned")
+	}
+
+	if secondaryCalls != 0 {
+		t.Error("timeouts should not fail over by default")
+	}
+
+	m, _ = NewMultiClient([]Resource{{Name: "slow", Client: slow}, secondary}, MultiOptions{FailoverOnTimeout: true})
+
+	result, err := m.SendEmail(context.Background(), NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if err != nil || result.Resource != "secondary" {
+		t.Errorf("expected failover with FailoverOnTimeout, got %+v %v", result, err)
+	}
+
+	// A resource which can't be reached never got the message, so always fails over
+	unreachable := New(slow.AccessKey, "http://127.0.0.1:1")
+	m, _ = NewMultiClient([]Resource{{Name: "down", Client: unreachable}, secondary}, MultiOptions{})
+
+	result, err = m.SendEmail(context.Background(), NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if err != nil || result.Resource != "secondary" {
+		t.Errorf("expected failover from an unreachable resource, got %+v %v", result, err)
+	}
+}
+
+func TestMultiClientStrategies(t *testing.T) {
+	noFail := int32(0)
+	aCalls, bCalls := int32(0), int32(0)
+
+	a := newFakeResource(t, "a", &noFail, &aCalls)
+	b := newFakeResource(t, "b", &noFail, &bCalls)
+
+	roundRobin, _ := NewMultiClient([]Resource{a, b}, MultiOptions{Strategy: StrategyRoundRobin})
+	for i := 0; i < 4; i++ {
+		_, _ = roundRobin.SendEmail(context.Background(), NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	}
+
+	if aCalls != 2 || bCalls != 2 {
+		t.Errorf("round robin gave a=%d b=%d", aCalls, bCalls)
+	}
+
+	aCalls, bCalls = 0, 0
+	a.Weight, b.Weight = 3, 1
+
+	weighted, _ := NewMultiClient([]Resource{a, b}, MultiOptions{Strategy: StrategyWeighted})
+	for i := 0; i < 8; i++ {
+		_, _ = weighted.SendEmail(context.Background(), NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	}
+
+	if aCalls != 6 || bCalls != 2 {
+		t.Errorf("weighted gave a=%d b=%d", aCalls, bCalls)
+	}
+}
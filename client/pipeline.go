@@ -0,0 +1,276 @@
+package client
+
+// ==============================================================================
+// Request pipeline, every API request is passed through a chain of policies
+// before being sent by a single shared HTTP client. The order is
+//
+//   1. Circuit breaker, when enabled with WithCircuitBreaker
+//   2. Policies added with WithPolicy, run once per call
+//   3. Retry, when enabled with WithRetry
+//   4. Rate limiting, when enabled with WithRateLimits, a token for every attempt
+//   5. Telemetry, when enabled with WithTelemetry
+//   6. Policies added with WithPerRetryPolicy, run for every attempt
+//   7. Signing with the access key
+//   8. Logging, when enabled with WithLogger or WithLogging
+//   9. The transport, http.DefaultTransport unless set with WithTransport
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/auth"
+)
+
+const defaultRetryAttempts = 3
+const defaultRetryDelay = time.Second
+const defaultMaxRetryDelay = 30 * time.Second
+
+// Next sends the request on to the rest of the pipeline
+type Next func(req *http.Request) (*http.Response, error)
+
+// Policy wraps the sending of a request. It can modify the request before calling
+// next, and inspect or modify the response after. Not calling next stops the request
+type Policy func(req *http.Request, next Next) (*http.Response, error)
+
+// RetryOptions configures the retry policy, zero values use the defaults
+type RetryOptions struct {
+	MaxAttempts int           // Total attempts including the first, defaults to 3
+	Delay       time.Duration // Delay before the first retry, doubled for each retry, defaults to 1s
+	MaxDelay    time.Duration // Maximum delay between retries, defaults to 30s
+}
+
+// WithPolicy adds a policy which runs once for each call, before any retries
+func WithPolicy(p Policy) Option {
+	return func(c *Client) {
+		c.perCall = append(c.perCall, p)
+	}
+}
+
+// WithPerRetryPolicy adds a policy which runs for every attempt, just before the request is signed
+func WithPerRetryPolicy(p Policy) Option {
+	return func(c *Client) {
+		c.perRetry = append(c.perRetry, p)
+	}
+}
+
+// WithRetry retries requests which are throttled, fail with a 5xx status, or time out.
+// Retries honour Retry-After, and reuse the repeatability headers so nothing is sent twice
+func WithRetry(opts RetryOptions) Option {
+	return func(c *Client) {
+		if opts.MaxAttempts < 1 {
+			opts.MaxAttempts = defaultRetryAttempts
+		}
+
+		if opts.Delay <= 0 {
+			opts.Delay = defaultRetryDelay
+		}
+
+		if opts.MaxDelay <= 0 {
+			opts.MaxDelay = defaultMaxRetryDelay
+		}
+
+		c.retry = &opts
+	}
+}
+
+// WithTransport sets the transport used to send requests, e.g. to use a proxy or for testing
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.transport = rt
+	}
+}
+
+// WithTimeout sets the timeout for each request, including reading the response, defaults to 20s.
+// With retries, each attempt has this timeout
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+type requestInfoKey struct{}
+
+// requestInfo describes the call being made, it's carried in the request context
+type requestInfo struct {
+	operation  string
+	recipients int
+	from       string // Sender of the message, SMS are rate limited per number
+}
+
+// withRequestInfo records the operation name, recipient count and sender in the context
+func withRequestInfo(ctx context.Context, operation string, recipients int, from string) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, requestInfo{operation: operation, recipients: recipients, from: from})
+}
+
+// OperationFromContext returns the operation name, e.g. OperationSendEmail, for use in policies
+func OperationFromContext(ctx context.Context) string {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+
+	return info.operation
+}
+
+// do sends a request through the pipeline, which is built on first use
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.pipelineOnce.Do(func() {
+		c.pipeline = c.buildPipeline()
+	})
+
+	return c.pipeline(req)
+}
+
+func (c *Client) buildPipeline() Next {
+	transport := c.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = clientTimeout
+	}
+
+	httpClient := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+
+	policies := []Policy{}
+
+	if c.breakers != nil {
+		policies = append(policies, breakerPolicy(c.breakers))
+	}
+
+	policies = append(policies, c.perCall...)
+
+	if c.retry != nil {
+		policies = append(policies, retryPolicy(*c.retry))
+	}
+
+	if c.limiter != nil {
+		policies = append(policies, c.limiter.policy)
+	}
+
+	if c.telemetry != nil {
+		policies = append(policies, c.telemetry.policy)
+	}
+
+	policies = append(policies, c.perRetry...)
+	policies = append(policies, signingPolicy(c.AccessKey))
+
+	if c.logging != nil {
+		policies = append(policies, loggingPolicy(c.logging))
+	}
+
+	// Chain the policies from the end, so the first policy runs first
+	next := Next(httpClient.Do)
+
+	for i := len(policies) - 1; i >= 0; i-- {
+		policy, inner := policies[i], next
+		next = func(req *http.Request) (*http.Response, error) {
+			return policy(req, inner)
+		}
+	}
+
+	return next
+}
+
+// signingPolicy signs each request using the ACS access key and HMAC-SHA256
+func signingPolicy(accessKey string) Policy {
+	return func(req *http.Request, next Next) (*http.Response, error) {
+		err := auth.SignRequestHMAC(accessKey, req)
+		if err != nil {
+			return nil, &pipelineError{msg: "error signing API request: " + err.Error()}
+		}
+
+		return next(req)
+	}
+}
+
+// pipelineError is returned when a request fails before it's sent, it's not retried
+type pipelineError struct {
+	msg string
+	err error
+}
+
+func (e *pipelineError) Error() string {
+	return e.msg
+}
+
+func (e *pipelineError) Unwrap() error {
+	return e.err
+}
+
+// retryPolicy resends requests which fail with a retryable status or error
+func retryPolicy(opts RetryOptions) Policy {
+	return func(req *http.Request, next Next) (*http.Response, error) {
+		ctx := req.Context()
+		delay := opts.Delay
+		firstAttempt := attemptFromContext(ctx)
+
+		for attempt := 1; ; attempt++ {
+			try := req.Clone(WithAttempt(ctx, firstAttempt+attempt-1))
+
+			// The body has been read by the previous attempt, so get a fresh copy
+			if attempt > 1 && req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+
+				try.Body = body
+			}
+
+			resp, err := next(try)
+
+			wait, retry := shouldRetry(resp, err, delay)
+			if !retry || attempt >= opts.MaxAttempts {
+				return resp, err
+			}
+
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if wait > opts.MaxDelay {
+				wait = opts.MaxDelay
+			}
+
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+
+				return nil, ctx.Err()
+			}
+
+			delay *= 2
+		}
+	}
+}
+
+// shouldRetry decides if a response or error can be retried, and how long to wait
+func shouldRetry(resp *http.Response, err error, delay time.Duration) (time.Duration, bool) {
+	if err != nil {
+		pipeErr := &pipelineError{}
+		if errors.As(err, &pipeErr) {
+			return 0, false
+		}
+
+		return delay, IsRetryable(err)
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+		return 0, false
+	}
+
+	if after := retryAfter(resp); after > delay {
+		return after, true
+	}
+
+	return delay, true
+}
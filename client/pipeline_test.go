@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipelinePolicies(t *testing.T) {
+	calls := int32(0)
+	order := []string{}
+
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-tenant") != "contoso" {
+			t.Error("per call policy header missing")
+		}
+
+		if r.Header.Get("Authorization") == "" {
+			t.Error("request was not signed")
+		}
+
+		// First attempt is throttled
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("retry-after-ms", "10")
+			w.WriteHeader(http.StatusTooManyRequests)
+
+			return
+		}
+
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	WithPolicy(func(req *http.Request, next Next) (*http.Response, error) {
+		order = append(order, "call:"+OperationFromContext(req.Context()))
+		req.Header.Set("x-tenant", "contoso")
+
+		return next(req)
+	})(acsClient)
+
+	WithPerRetryPolicy(func(req *http.Request, next Next) (*http.Response, error) {
+		order = append(order, "retry")
+
+		if req.Header.Get("Authorization") != "" {
+			t.Error("per retry policies should run before signing")
+		}
+
+		return next(req)
+	})(acsClient)
+
+	WithRetry(RetryOptions{Delay: time.Millisecond})(acsClient)
+
+	messageID, err := acsClient.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if messageID != "msg-id" {
+		t.Errorf("unexpected message ID %q", messageID)
+	}
+
+	want := []string{"call:" + OperationSendEmail, "retry", "retry"}
+	if len(order) != len(want) {
+		t.Fatalf("policies ran %v, want %v", order, want)
+	}
+
+	for i := range want {
+		if order[i] != want[i] {
+			t.Errorf("policies ran %v, want %v", order, want)
+		}
+	}
+}
+
+func TestPipelineRetryGivesUp(t *testing.T) {
+	calls := int32(0)
+
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	WithRetry(RetryOptions{MaxAttempts: 2, Delay: time.Millisecond})(acsClient)
+
+	_, err := acsClient.GetEmailStatusContext(context.Background(), "msg-id")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if !IsRetryable(err) {
+		t.Errorf("expected a retryable API error, got %s", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestPipelineStopsRequest(t *testing.T) {
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not be sent")
+	})
+
+	WithPolicy(func(req *http.Request, next Next) (*http.Response, error) {
+		return nil, context.Canceled
+	})(acsClient)
+
+	_, err := acsClient.SendSingleSMS(NewSMS("+10000000000", "+10000000001", smsMessage))
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestNewFromConnectionString(t *testing.T) {
+	c, err := NewFromConnectionString("endpoint=https://test.communication.azure.com/;accesskey=c2VjcmV0PT0=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Endpoint != "https://test.communication.azure.com" || c.AccessKey != "c2VjcmV0PT0=" {
+		t.Errorf("unexpected endpoint %q or key %q", c.Endpoint, c.AccessKey)
+	}
+
+	if _, err = NewFromConnectionString("endpoint=https://test.communication.azure.com/"); err == nil {
+		t.Error("expected an error for a missing access key")
+	}
+}
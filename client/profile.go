@@ -0,0 +1,289 @@
+package client
+
+// ==============================================================================
+// Config file with named profiles, e.g. for dev, staging and prod resources
+// Each profile has the endpoint and key, API versions, default senders, and
+// retry, timeout & tracking settings. Secrets can be read from the environment
+// or files with env:NAME and file:/path references, rather than kept inline
+// ==============================================================================
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProfileEnv is the environment variable naming the profile to use, when none is given
+const ProfileEnv = "ACS_PROFILE"
+
+// ConfigEnv is the environment variable with the path of the config file
+const ConfigEnv = "ACS_CONFIG"
+
+// Profiles is a config file of named profiles
+type Profiles struct {
+	DefaultProfile string              `json:"defaultProfile"` // Used when no profile is named
+	Profiles       map[string]*Profile `json:"profiles"`
+}
+
+// Profile configures a client for an ACS resource
+type Profile struct {
+	Endpoint         string `json:"endpoint"`
+	AccessKey        string `json:"accessKey"`        // The key, or a reference: env:NAME or file:/path
+	ConnectionString string `json:"connectionString"` // Instead of endpoint & key, can also be a reference
+
+	APIVersionEmail string `json:"apiVersionEmail"`
+	APIVersionSMS   string `json:"apiVersionSms"`
+
+	From     string `json:"from"`     // Default email sender address
+	FromName string `json:"fromName"` // Default sender display name, see Defaults.FromName
+	SMSFrom  string `json:"smsFrom"`  // Default number SMS are sent from
+
+	Tracking *bool        `json:"tracking"` // Set false to disable user engagement tracking for all emails
+	Timeout  Duration     `json:"timeout"`  // Request timeout, e.g. "30s"
+	Retry    *RetryConfig `json:"retry"`    // Retries are enabled when set
+}
+
+// RetryConfig is the retry policy of a profile, zero values use the WithRetry defaults
+type RetryConfig struct {
+	MaxAttempts int      `json:"maxAttempts"`
+	Delay       Duration `json:"delay"`
+	MaxDelay    Duration `json:"maxDelay"`
+}
+
+// Duration is a time.Duration written as a string in JSON, e.g. "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	s := ""
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"30s\": %s", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultConfigPath is the config file used when none is given, $ACS_CONFIG,
+// or <user config dir>/acs/config.json
+func DefaultConfigPath() (string, error) {
+	if path := os.Getenv(ConfigEnv); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding config dir: %s", err)
+	}
+
+	return filepath.Join(dir, "acs", "config.json"), nil
+}
+
+// LoadProfiles reads a JSON config file of profiles, an empty path uses DefaultConfigPath
+func LoadProfiles(path string) (*Profiles, error) {
+	if path == "" {
+		var err error
+
+		if path, err = DefaultConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %s", err)
+	}
+
+	p := &Profiles{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %s", path, err)
+	}
+
+	return p, nil
+}
+
+// Get returns a profile by name. When name is empty $ACS_PROFILE is used, then the default profile
+func (p *Profiles) Get(name string) (*Profile, error) {
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+
+	if name == "" {
+		name = p.DefaultProfile
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("no profile named, set %s or defaultProfile in the config", ProfileEnv)
+	}
+
+	profile, found := p.Profiles[name]
+	if !found || profile == nil {
+		names := make([]string, 0, len(p.Profiles))
+		for n := range p.Profiles {
+			names = append(names, n)
+		}
+
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("profile %q not found, the config has: %s", name, strings.Join(names, ", "))
+	}
+
+	return profile, nil
+}
+
+// NewFromProfile creates a client from a profile in the default config file,
+// see LoadProfiles and Get. Options are applied after those from the profile
+func NewFromProfile(name string, opts ...Option) (*Client, error) {
+	profiles, err := LoadProfiles("")
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := profiles.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return profile.NewClient(opts...)
+}
+
+// NewFromEnvironment creates a client for commands and services. A named profile, or $ACS_PROFILE, is
+// used when given. Otherwise ACS_CONNECTION_STRING, or ACS_ENDPOINT and ACS_ACCESS_KEY, are used, and
+// can be env:NAME or file:/path references. Last is the default profile, when there's a config file
+func NewFromEnvironment(profile string, opts ...Option) (*Client, error) {
+	if profile == "" {
+		profile = os.Getenv(ProfileEnv)
+	}
+
+	if profile != "" {
+		return NewFromProfile(profile, opts...)
+	}
+
+	env := Profile{
+		ConnectionString: os.Getenv("ACS_CONNECTION_STRING"),
+		Endpoint:         os.Getenv("ACS_ENDPOINT"),
+		AccessKey:        os.Getenv("ACS_ACCESS_KEY"),
+	}
+
+	if env.ConnectionString != "" || env.Endpoint != "" || env.AccessKey != "" {
+		c, err := env.NewClient(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("environment: %s", err)
+		}
+
+		return c, nil
+	}
+
+	if path, err := DefaultConfigPath(); err == nil {
+		if _, err := os.Stat(path); err == nil {
+			return NewFromProfile("", opts...)
+		}
+	}
+
+	return nil, fmt.Errorf("no ACS configuration, set ACS_CONNECTION_STRING, or ACS_ENDPOINT and ACS_ACCESS_KEY, or use a profile")
+}
+
+// NewClient creates a client configured by the profile, resolving any secret references.
+// Options are applied after those from the profile, so can override it
+func (p *Profile) NewClient(opts ...Option) (*Client, error) {
+	profileOpts := []Option{
+		WithDefaults(Defaults{
+			From:            p.From,
+			FromName:        p.FromName,
+			SMSFrom:         p.SMSFrom,
+			DisableTracking: p.Tracking != nil && !*p.Tracking,
+		}),
+	}
+
+	if p.Timeout > 0 {
+		profileOpts = append(profileOpts, WithTimeout(time.Duration(p.Timeout)))
+	}
+
+	if p.Retry != nil {
+		profileOpts = append(profileOpts, WithRetry(RetryOptions{
+			MaxAttempts: p.Retry.MaxAttempts,
+			Delay:       time.Duration(p.Retry.Delay),
+			MaxDelay:    time.Duration(p.Retry.MaxDelay),
+		}))
+	}
+
+	opts = append(profileOpts, opts...)
+
+	var c *Client
+
+	switch {
+	case p.ConnectionString != "":
+		connectionString, err := ResolveSecret(p.ConnectionString)
+		if err != nil {
+			return nil, fmt.Errorf("connection string: %s", err)
+		}
+
+		if c, err = NewFromConnectionString(connectionString, opts...); err != nil {
+			return nil, err
+		}
+	case p.Endpoint != "" && p.AccessKey != "":
+		accessKey, err := ResolveSecret(p.AccessKey)
+		if err != nil {
+			return nil, fmt.Errorf("access key: %s", err)
+		}
+
+		c = New(accessKey, strings.TrimRight(p.Endpoint, "/"), opts...)
+	default:
+		return nil, errors.New("an endpoint and access key, or a connection string, are needed")
+	}
+
+	if p.APIVersionEmail != "" {
+		c.APIVersionEmail = p.APIVersionEmail
+	}
+
+	if p.APIVersionSMS != "" {
+		c.APIVersionSMS = p.APIVersionSMS
+	}
+
+	return c, nil
+}
+
+// ResolveSecret returns the value of a secret reference, env:NAME reads an environment
+// variable and file:/path reads a file, trimming whitespace. Other values are returned as is
+func ResolveSecret(ref string) (string, error) {
+	if name, found := strings.CutPrefix(ref, "env:"); found {
+		value := os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+
+		return value, nil
+	}
+
+	if path, found := strings.CutPrefix(ref, "file:"); found {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret: %s", err)
+		}
+
+		value := strings.TrimSpace(string(data))
+		if value == "" {
+			return "", fmt.Errorf("secret file %s is empty", path)
+		}
+
+		return value, nil
+	}
+
+	return ref, nil
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewFromProfile(t *testing.T) {
+	calls := int32(0)
+	sent := map[string]any{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request fails, so the profile's retry policy is used
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		sent = map[string]any{"api-version": r.URL.Query().Get("api-version")}
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "prod.key")
+	_ = os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString([]byte("prod"))+"\n"), 0o600)
+
+	config := fmt.Sprintf(`{
+  "defaultProfile": "dev",
+  "profiles": {
+    "dev": {
+      "endpoint": "%s/",
+      "accessKey": "env:TEST_DEV_KEY",
+      "apiVersionEmail": "2023-03-31",
+      "from": "DoNotReply@dev.blah.net",
+      "fromName": "Dev",
+      "smsFrom": "+15550000000",
+      "tracking": false,
+      "timeout": "5s",
+      "retry": { "maxAttempts": 2, "delay": "1ms" }
+    },
+    "prod": { "endpoint": "https://prod.communication.azure.com", "accessKey": "file:%s" }
+  }
+}`, srv.URL, keyFile)
+
+	configFile := filepath.Join(dir, "config.json")
+	_ = os.WriteFile(configFile, []byte(config), 0o600)
+
+	t.Setenv(ConfigEnv, configFile)
+	t.Setenv(ProfileEnv, "")
+
+	if _, err := NewFromProfile(""); err == nil {
+		t.Error("expected an error when the key's environment variable isn't set")
+	}
+
+	t.Setenv("TEST_DEV_KEY", base64.StdEncoding.EncodeToString([]byte("dev")))
+
+	c, err := NewFromProfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Endpoint != srv.URL || c.APIVersionSMS != "2021-03-07" || c.Defaults.FromName != "Dev" || c.timeout != 5*time.Second {
+		t.Errorf("client not configured from the profile: %+v", c)
+	}
+
+	// The default sender is used, and tracking is disabled
+	if _, err := c.SendEmail(NewPlainEmail("", toAddress, subject, "Hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent["sender"] != "DoNotReply@dev.blah.net" || sent["disableUserEngagementTracking"] != true || sent["api-version"] != "2023-03-31" {
+		t.Errorf("defaults not applied to the email: %v", sent)
+	}
+
+	t.Setenv(ProfileEnv, "prod")
+
+	c, err = NewFromProfile("")
+	if err != nil || c.AccessKey != base64.StdEncoding.EncodeToString([]byte("prod")) {
+		t.Errorf("expected the prod key from the file, got %v", err)
+	}
+
+	if _, err := NewFromProfile("staging"); err == nil {
+		t.Error("expected an error for a missing profile")
+	}
+}
+
+func TestSMSDefaultFrom(t *testing.T) {
+	from := ""
+
+	c := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		s := &SMS{}
+		_ = json.NewDecoder(r.Body).Decode(s)
+		from = s.From
+
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(SMSSendResponse{Value: []SMSSendResponseItem{{Successful: true, HTTPStatusCode: http.StatusAccepted}}})
+	})
+
+	WithDefaults(Defaults{SMSFrom: "+15550000000"})(c)
+
+	if _, err := c.SendSingleSMS(NewSMS("", "+15550000001", "Hello")); err != nil || from != "+15550000000" {
+		t.Errorf("expected the default number, got %q %v", from, err)
+	}
+}
+
+func TestNewFromEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	key := base64.StdEncoding.EncodeToString([]byte("key"))
+
+	t.Setenv(ConfigEnv, filepath.Join(dir, "config.json"))
+	t.Setenv(ProfileEnv, "")
+	t.Setenv("ACS_CONNECTION_STRING", "")
+	t.Setenv("ACS_ENDPOINT", "")
+	t.Setenv("ACS_ACCESS_KEY", "")
+
+	if _, err := NewFromEnvironment(""); err == nil {
+		t.Error("expected an error with no configuration")
+	}
+
+	// Environment variables can be secret references
+	_ = os.WriteFile(filepath.Join(dir, "key"), []byte(key+"\n"), 0o600)
+	t.Setenv("ACS_ENDPOINT", "https://env.communication.azure.com")
+	t.Setenv("ACS_ACCESS_KEY", "file:"+filepath.Join(dir, "key"))
+
+	c, err := NewFromEnvironment("")
+	if err != nil || c.Endpoint != "https://env.communication.azure.com" || c.AccessKey != key {
+		t.Errorf("expected the client from the environment, got %v", err)
+	}
+
+	t.Setenv("ACS_ACCESS_KEY", "")
+
+	if _, err := NewFromEnvironment(""); err == nil {
+		t.Error("expected an error for an endpoint without a key")
+	}
+
+	// A profile wins over the environment, and the default profile is used when there's nothing else
+	_ = os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+  "defaultProfile": "dev",
+  "profiles": {
+    "dev": { "endpoint": "https://dev.communication.azure.com", "accessKey": "ZGV2" },
+    "prod": { "endpoint": "https://prod.communication.azure.com", "accessKey": "cHJvZA==" }
+  }
+}`), 0o600)
+
+	t.Setenv("ACS_ENDPOINT", "https://env.communication.azure.com")
+	t.Setenv("ACS_ACCESS_KEY", key)
+
+	for _, test := range []struct{ name, env, endpoint string }{
+		{name: "prod", endpoint: "https://prod.communication.azure.com"},
+		{env: "prod", endpoint: "https://prod.communication.azure.com"},
+		{endpoint: "https://env.communication.azure.com"},
+	} {
+		t.Setenv(ProfileEnv, test.env)
+
+		c, err := NewFromEnvironment(test.name)
+		if err != nil || c.Endpoint != test.endpoint {
+			t.Errorf("profile %q with %s=%q: expected %s, got %v", test.name, ProfileEnv, test.env, test.endpoint, err)
+		}
+	}
+
+	t.Setenv(ProfileEnv, "")
+	t.Setenv("ACS_ENDPOINT", "")
+	t.Setenv("ACS_ACCESS_KEY", "")
+
+	c, err = NewFromEnvironment("")
+	if err != nil || c.Endpoint != "https://dev.communication.azure.com" {
+		t.Errorf("expected the default profile, got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package client
+
+// ==============================================================================
+// Client side rate limiting, using token buckets to match the ACS quotas
+// Email sends, status polls and SMS (per sending number) have separate
+// buckets. A token is taken for every attempt, so retries are limited too.
+// When the API asks us to back off with Retry-After, the bucket is paused for
+// that long
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a client side rate limit is hit, and the client is not set to wait
+var ErrRateLimited = errors.New("client side rate limit exceeded")
+
+// RateLimits configures client side rate limiting, zero or missing rates are not limited
+type RateLimits struct {
+	EmailPerMinute  float64
+	EmailPerHour    float64
+	StatusPerMinute float64 // Calls to GetEmailStatus
+	SMSPerSecond    float64 // For each From number, unless overridden in SMSPerNumber
+
+	// SMSPerNumber overrides the rate for specific From numbers, so short codes,
+	// toll free and long code numbers can each have their own rate
+	SMSPerNumber map[string]float64
+
+	// Wait blocks calls until they are allowed, or the context is done.
+	// When false, calls over the limit fail immediately with ErrRateLimited
+	Wait bool
+}
+
+// WithRateLimits enables client side rate limiting
+func WithRateLimits(limits RateLimits) Option {
+	return func(c *Client) {
+		c.limiter = newRateLimiter(limits)
+	}
+}
+
+// rateLimiter holds the buckets for each type of call
+type rateLimiter struct {
+	limits RateLimits
+	email  []*tokenBucket
+	status []*tokenBucket
+
+	mu  sync.Mutex
+	sms map[string][]*tokenBucket
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	l := &rateLimiter{
+		limits: limits,
+		sms:    map[string][]*tokenBucket{},
+	}
+
+	if limits.EmailPerMinute > 0 {
+		l.email = append(l.email, newTokenBucket(limits.EmailPerMinute/60, int(math.Ceil(limits.EmailPerMinute))))
+	}
+
+	if limits.EmailPerHour > 0 {
+		l.email = append(l.email, newTokenBucket(limits.EmailPerHour/3600, int(math.Ceil(limits.EmailPerHour))))
+	}
+
+	if limits.StatusPerMinute > 0 {
+		l.status = append(l.status, newTokenBucket(limits.StatusPerMinute/60, int(math.Ceil(limits.StatusPerMinute))))
+	}
+
+	return l
+}
+
+// smsBuckets gets the buckets for a sending number, creating them on first use
+func (l *rateLimiter) smsBuckets(from string) []*tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if buckets, found := l.sms[from]; found {
+		return buckets
+	}
+
+	rate, found := l.limits.SMSPerNumber[from]
+	if !found {
+		rate = l.limits.SMSPerSecond
+	}
+
+	buckets := []*tokenBucket{}
+	if rate > 0 {
+		buckets = append(buckets, newTokenBucket(rate, int(math.Ceil(rate))))
+	}
+
+	l.sms[from] = buckets
+
+	return buckets
+}
+
+// buckets gets the buckets for the operation in the request context
+func (l *rateLimiter) buckets(ctx context.Context) []*tokenBucket {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+
+	switch info.operation {
+	case OperationSendEmail:
+		return l.email
+	case OperationGetEmailStatus:
+		return l.status
+	case OperationSendSMS:
+		return l.smsBuckets(info.from)
+	}
+
+	return nil
+}
+
+// policy takes a token for each attempt, so retries can't go over the limits, and
+// pauses the buckets when a response asks the client to back off
+func (l *rateLimiter) policy(req *http.Request, next Next) (*http.Response, error) {
+	buckets := l.buckets(req.Context())
+
+	if err := l.acquire(req.Context(), buckets); err != nil {
+		return nil, &pipelineError{msg: err.Error(), err: err}
+	}
+
+	resp, err := next(req)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		if after := retryAfter(resp); after > 0 {
+			for _, b := range buckets {
+				b.pause(after)
+			}
+		}
+	}
+
+	return resp, err
+}
+
+// acquire takes a token from every bucket, waiting or failing fast as configured
+func (l *rateLimiter) acquire(ctx context.Context, buckets []*tokenBucket) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	if l.limits.Wait {
+		return waitAll(ctx, buckets)
+	}
+
+	for i, b := range buckets {
+		if !b.tryTake() {
+			for _, taken := range buckets[:i] {
+				taken.refund()
+			}
+
+			return ErrRateLimited
+		}
+	}
+
+	return nil
+}
+
+// tokenBucket allows bursts up to its size, refilling at a fixed rate per second
+type tokenBucket struct {
+	mu          sync.Mutex
+	rate        float64
+	size        float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		size:   float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds tokens for the time passed, must be called with the lock held
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.size {
+		b.tokens = b.size
+	}
+
+	b.last = now
+}
+
+// reserve takes a token, returning how long the caller must wait before using it
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.refill(now)
+	b.tokens--
+
+	delay := time.Duration(0)
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+
+	if paused := b.pausedUntil.Sub(now); paused > delay {
+		delay = paused
+	}
+
+	return delay
+}
+
+// tryTake takes a token only if one is available now
+func (b *tokenBucket) tryTake() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.refill(now)
+
+	if b.tokens < 1 || now.Before(b.pausedUntil) {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// refund gives back a token which was taken but not used
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens++
+}
+
+// pause stops tokens being used for the given time
+func (b *tokenBucket) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// Wait blocks until a token is available or the context is done
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	return waitAll(ctx, []*tokenBucket{b})
+}
+
+// waitAll takes a token from every bucket, blocking until all are available or the context is done
+func waitAll(ctx context.Context, buckets []*tokenBucket) error {
+	delay := time.Duration(0)
+
+	for _, b := range buckets {
+		if d := b.reserve(); d > delay {
+			delay = d
+		}
+	}
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give back the tokens we didn't use
+		for _, b := range buckets {
+			b.refund()
+		}
+
+		return ctx.Err()
+	}
+}
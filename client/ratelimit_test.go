@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitFailFast(t *testing.T) {
+	calls := int32(0)
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	WithRateLimits(RateLimits{EmailPerMinute: 2})(client)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+		if i < 2 && err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 2 && !errors.Is(err, ErrRateLimited) {
+			t.Error("Expected ErrRateLimited, got:", err)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("Expected 2 calls to the API, got %d", calls)
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	WithRateLimits(RateLimits{StatusPerMinute: 100})(client)
+
+	_, err := client.GetEmailStatus("abc")
+
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != 30*time.Second || !IsRetryable(err) {
+		t.Fatal("Expected retryable API error, got:", err)
+	}
+
+	// The status bucket is now paused, but email is unaffected
+	if _, err = client.GetEmailStatus("abc"); !errors.Is(err, ErrRateLimited) {
+		t.Error("Expected ErrRateLimited, got:", err)
+	}
+
+	if _, err = client.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello")); errors.Is(err, ErrRateLimited) {
+		t.Error("Expected email not to be rate limited")
+	}
+}
+
+func TestRateLimitSMSPerNumber(t *testing.T) {
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(SMSSendResponse{Value: []SMSSendResponseItem{{Successful: true}}})
+	})
+	WithRateLimits(RateLimits{
+		SMSPerSecond: 1,
+		SMSPerNumber: map[string]float64{"12345": 100},
+		Wait:         true,
+	})(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// Short code has a high limit, so all of these go straight through
+	for i := 0; i < 10; i++ {
+		if _, err := client.SendSingleSMSContext(ctx, NewSMS("12345", toNumber, smsMessage)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Long code allows one per second, so the second has to wait longer than the context allows
+	_, err := client.SendSingleSMSContext(ctx, NewSMS("+15550000000", toNumber, smsMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.SendSingleSMSContext(ctx, NewSMS("+15550000000", toNumber, smsMessage))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected deadline exceeded, got:", err)
+	}
+}
+
+func TestRateLimitRetries(t *testing.T) {
+	calls := int32(0)
+	client := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	WithRetry(RetryOptions{MaxAttempts: 5, Delay: time.Millisecond})(client)
+	WithRateLimits(RateLimits{EmailPerMinute: 2})(client)
+
+	// Each attempt takes a token, so the third attempt is over the limit and isn't retried
+	_, err := client.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Error("Expected ErrRateLimited, got:", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("Expected 2 calls to the API, got %d", calls)
+	}
+}
@@ -8,13 +8,13 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"slices"
 	"time"
 
-	"github.com/benc-uk/go-acs-client/auth"
 	"github.com/google/uuid"
 )
 
@@ -33,44 +33,76 @@ func NewSMS(from, to, msg string) *SMS {
 	}
 }
 
+// SetIdempotencyKey sets the key for the first recipient, sends with the same key are only delivered once.
+// The repeatability fields generated by NewSMS are cleared, so the key is used. Like
+// Email.SetIdempotencyKey, keys are remembered in memory by the client
+func (s *SMS) SetIdempotencyKey(key string) {
+	if len(s.SMSRecipients) > 0 {
+		s.SMSRecipients[0].IdempotencyKey = key
+		s.SMSRecipients[0].RepeatabilityRequestID = ""
+		s.SMSRecipients[0].RepeatabilityFirstSent = ""
+	}
+}
+
+// Clone returns a copy of the SMS, recipients can be changed without changing the original
+func (s *SMS) Clone() *SMS {
+	cp := *s
+	cp.SMSRecipients = slices.Clone(s.SMSRecipients)
+
+	return &cp
+}
+
 // SendSingleSMS sends a single SMS and returns the API response and/or error
 func (c *Client) SendSingleSMS(s *SMS) (smsResp *SMSSendResponseItem, err error) {
+	return c.SendSingleSMSContext(context.Background(), s)
+}
+
+// SendSingleSMSContext sends a single SMS and returns the API response and/or error,
+// the context can be used to cancel the request
+func (c *Client) SendSingleSMSContext(ctx context.Context, s *SMS) (smsResp *SMSSendResponseItem, err error) {
+	// Work on a copy of the recipients, so keys are mapped without changing the caller's SMS
+	sms := *s
+	sms.SMSRecipients = make([]SMSRecipient, len(s.SMSRecipients))
+
+	for i, r := range s.SMSRecipients {
+		if r.IdempotencyKey != "" && r.RepeatabilityRequestID == "" {
+			r.RepeatabilityRequestID, r.RepeatabilityFirstSent = c.Repeatability(r.IdempotencyKey)
+		}
+
+		sms.SMSRecipients[i] = r
+	}
+
+	if sms.From == "" {
+		sms.From = c.Defaults.SMSFrom
+	}
+
+	s = &sms
+
 	postBody, err := json.Marshal(s)
 	if err != nil {
 		return nil, fmt.Errorf("sms failed JSON marshalling: %s", err)
 	}
 
 	bodyBuffer := bytes.NewBuffer(postBody)
+	ctx = withRequestInfo(ctx, OperationSendSMS, len(s.SMSRecipients), s.From)
 
-	req, err := http.NewRequest("POST", c.Endpoint+sendSMSEndpoint+"?api-version="+c.APIVersionSMS, bodyBuffer)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint+sendSMSEndpoint+"?api-version="+c.APIVersionSMS, bodyBuffer)
 	if err != nil {
 		return nil, fmt.Errorf("error creating API request: %s", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	// Sign the request using the ACS access key and HMAC-SHA256
-	err = auth.SignRequestHMAC(c.AccessKey, req)
-	if err != nil {
-		return nil, fmt.Errorf("error signing API request: %s", err)
-	}
-
-	client := &http.Client{
-		Timeout: time.Second * clientTimeout,
-	}
-
-	resp, err := client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error sending API request: %s", err)
+		return nil, fmt.Errorf("error sending API request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted {
 		// For some reason the API returns various body content on error (bad API design)
-		// So we just return the raw body as a string
-		body, _ := io.ReadAll(resp.Body)
-
-		return nil, fmt.Errorf("error %d sending sms: %s", resp.StatusCode, string(body))
+		// So if it's not a regular error response, the raw body is used as the message
+		return nil, newAPIError("sending sms", resp)
 	}
 
 	smsRespList := &SMSSendResponse{}
@@ -82,3 +114,13 @@ func (c *Client) SendSingleSMS(s *SMS) (smsResp *SMSSendResponseItem, err error)
 
 	return &smsRespList.Value[0], nil
 }
+
+// Err returns the failure of a single recipient as an *APIError with its status code, so it can be
+// checked with errors.As and IsRetryable like other API errors. It's nil when the SMS was accepted
+func (r *SMSSendResponseItem) Err() error {
+	if r.Successful {
+		return nil
+	}
+
+	return &APIError{Op: "sending sms", StatusCode: r.HTTPStatusCode, Message: r.ErrorMessage}
+}
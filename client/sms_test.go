@@ -11,6 +11,9 @@ package client
 // ==============================================================================
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
 	"testing"
 
 	_ "github.com/joho/godotenv/autoload"
@@ -19,7 +22,7 @@ import (
 const smsMessage = "Test SMS from Azure Communication Services"
 
 func TestSendSMS(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 
 	s := NewSMS(fromNumber, toNumber, smsMessage)
 
@@ -34,7 +37,7 @@ func TestSendSMS(t *testing.T) {
 }
 
 func TestSendSMSNoMessage(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 
 	s := NewSMS(fromNumber, toNumber, "")
 
@@ -45,7 +48,7 @@ func TestSendSMSNoMessage(t *testing.T) {
 }
 
 func TestSendSMSBadFrom(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 
 	s := NewSMS("hello", toNumber, smsMessage)
 
@@ -56,7 +59,7 @@ func TestSendSMSBadFrom(t *testing.T) {
 }
 
 func TestSendSMSBadTo(t *testing.T) {
-	client := New(accessKey, endpoint)
+	client := newTestClient(t)
 
 	s := NewSMS(fromNumber, "goats", smsMessage)
 
@@ -72,3 +75,29 @@ func TestSendSMSBadTo(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestSMSRecipientErr(t *testing.T) {
+	c := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(SMSSendResponse{Value: []SMSSendResponseItem{{
+			To:             "+15550000001",
+			HTTPStatusCode: http.StatusServiceUnavailable,
+			ErrorMessage:   "carrier unavailable",
+		}}})
+	})
+
+	resp, err := c.SendSingleSMS(NewSMS("+15550000000", "+15550000001", smsMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The recipient's status code is kept, so the failure can be retried like an API error
+	apiErr := &APIError{}
+	if err := resp.Err(); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || !IsRetryable(err) {
+		t.Errorf("expected a retryable API error with the status code, got %v", err)
+	}
+
+	if (&SMSSendResponseItem{Successful: true}).Err() != nil {
+		t.Error("expected no error for a successful recipient")
+	}
+}
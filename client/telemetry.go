@@ -0,0 +1,164 @@
+package client
+
+// ==============================================================================
+// OpenTelemetry tracing and metrics for ACS operations, enabled with the
+// WithTelemetry option. When not enabled nothing is created or recorded.
+// No PII is recorded, e.g. addresses and numbers are never added to spans
+// ==============================================================================
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/benc-uk/go-acs-client/client"
+
+// Operation names used for spans and metrics
+const (
+	OperationSendEmail      = "SendEmail"
+	OperationGetEmailStatus = "GetEmailStatus"
+	OperationSendSMS        = "SendSMS"
+)
+
+// TelemetryOptions configures tracing and metrics, nil providers use the OpenTelemetry globals
+type TelemetryOptions struct {
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
+	Propagator     propagation.TextMapPropagator
+}
+
+// WithTelemetry enables OpenTelemetry spans and metrics for all ACS operations,
+// and propagates the trace context on outgoing requests
+func WithTelemetry(opts TelemetryOptions) Option {
+	return func(c *Client) {
+		c.telemetry = newTelemetry(opts)
+	}
+}
+
+type attemptKey struct{}
+
+// WithAttempt records the attempt number in the context, so retries are counted
+// in the metrics and added to spans. Attempts start at 1
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+func attemptFromContext(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok && attempt > 0 {
+		return attempt
+	}
+
+	return 1
+}
+
+type telemetry struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	requests   metric.Int64Counter
+	retries    metric.Int64Counter
+	duration   metric.Float64Histogram
+}
+
+func newTelemetry(opts TelemetryOptions) *telemetry {
+	if opts.TracerProvider == nil {
+		opts.TracerProvider = otel.GetTracerProvider()
+	}
+
+	if opts.MeterProvider == nil {
+		opts.MeterProvider = otel.GetMeterProvider()
+	}
+
+	if opts.Propagator == nil {
+		opts.Propagator = otel.GetTextMapPropagator()
+	}
+
+	meter := opts.MeterProvider.Meter(instrumentationName)
+	t := &telemetry{
+		tracer:     opts.TracerProvider.Tracer(instrumentationName),
+		propagator: opts.Propagator,
+	}
+
+	// Instrument errors only happen with invalid names, the no-op instruments returned are safe to use
+	t.requests, _ = meter.Int64Counter("acs.client.requests",
+		metric.WithDescription("Number of requests made to ACS"), metric.WithUnit("{request}"))
+	t.retries, _ = meter.Int64Counter("acs.client.retries",
+		metric.WithDescription("Number of requests to ACS which were retries"), metric.WithUnit("{request}"))
+	t.duration, _ = meter.Float64Histogram("acs.client.request.duration",
+		metric.WithDescription("Duration of requests made to ACS"), metric.WithUnit("s"))
+
+	return t
+}
+
+// policy creates a span for each request, and records the metrics when it completes
+func (t *telemetry) policy(req *http.Request, next Next) (*http.Response, error) {
+	ctx := req.Context()
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+	attempt := attemptFromContext(ctx)
+	start := time.Now()
+
+	attrs := []attribute.KeyValue{
+		attribute.String("acs.operation", info.operation),
+		attribute.String("acs.api_version", req.URL.Query().Get("api-version")),
+	}
+
+	ctx, span := t.tracer.Start(ctx, "acs."+info.operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(
+			attribute.Int("acs.recipient_count", info.recipients),
+			attribute.Int("acs.attempt", attempt),
+		),
+	)
+
+	req = req.WithContext(ctx)
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := next(req)
+
+	outcome := "success"
+
+	switch {
+	case err != nil:
+		outcome = "error"
+
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case resp.StatusCode >= http.StatusBadRequest:
+		outcome = "error"
+
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+
+	if resp != nil {
+		attrs = append(attrs, attribute.Int("http.response.status_code", resp.StatusCode))
+
+		if requestID := resp.Header.Get("x-ms-request-id"); requestID != "" {
+			span.SetAttributes(attribute.String("acs.request_id", requestID))
+		}
+	}
+
+	attrs = append(attrs, attribute.String("acs.outcome", outcome))
+	span.SetAttributes(attrs...)
+	span.End()
+
+	// Use a context without cancellation, so metrics are recorded for cancelled requests
+	ctx = context.WithoutCancel(ctx)
+	set := metric.WithAttributes(attrs...)
+
+	t.requests.Add(ctx, 1, set)
+	t.duration.Record(ctx, time.Since(start).Seconds(), set)
+
+	if attempt > 1 {
+		t.retries.Add(ctx, 1, set)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTelemetry(t *testing.T) {
+	traceparent := ""
+
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	WithTelemetry(TelemetryOptions{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		Propagator:     propagation.TraceContext{},
+	})(acsClient)
+
+	e := NewPlainEmail(fromAddress, toAddress, subject, "Hello")
+	e.AddCC(ccAddress, "CC")
+
+	_, err := acsClient.SendEmailContext(WithAttempt(context.Background(), 2), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if traceparent == "" {
+		t.Error("trace context was not propagated")
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(ended))
+	}
+
+	attrs := map[string]string{}
+	for _, kv := range ended[0].Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+
+	want := map[string]string{
+		"acs.operation":             OperationSendEmail,
+		"acs.request_id":            "msg-id",
+		"acs.recipient_count":       "2",
+		"acs.attempt":               "2",
+		"http.response.status_code": "202",
+	}
+
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("span attribute %s = %q, want %q", k, attrs[k], v)
+		}
+	}
+
+	for k, v := range attrs {
+		if strings.Contains(v, toAddress) || strings.Contains(v, ccAddress) {
+			t.Errorf("span attribute %s contains an email address", k)
+		}
+	}
+
+	data := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]bool{}
+
+	for _, sm := range data.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			found[m.Name] = true
+		}
+	}
+
+	for _, name := range []string{"acs.client.requests", "acs.client.request.duration", "acs.client.retries"} {
+		if !found[name] {
+			t.Errorf("metric %s was not recorded", name)
+		}
+	}
+}
+
+func TestTelemetryDisabled(t *testing.T) {
+	acsClient := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("traceparent") != "" {
+			t.Error("trace context should not be sent when telemetry is off")
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	_, err := acsClient.SendEmail(NewPlainEmail(fromAddress, toAddress, subject, "Hello"))
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -20,8 +20,31 @@ type Email struct {
 	Importance  string         `json:"importance"`
 	ReplyTo     []Address      `json:"replyTo"`
 	Attachments []Attachment   `json:"attachments"`
+
+	// SenderName is the display name in the From header of MIME exports and archives. ACS only
+	// takes the address, and shows the display name configured for it on the email domain
+	SenderName string `json:"-"`
+
+	// When set, PlainText is generated from HTML on send, if PlainText is empty
+	GeneratePlainText bool `json:"-"`
+
+	// Transforms are run in order, to modify the email before it is sent
+	Transforms []Transform `json:"-"`
+
+	// IdempotencyKey identifies the email, so retries with the same key are only
+	// delivered once. It's ignored when RepeatabilityRequestID is set. The key is
+	// in process only, it's not in the JSON so stores which save emails drop it
+	IdempotencyKey string `json:"-"`
+
+	// Repeatability headers, when empty new values are generated for every send.
+	// Set these to retry a send, without the risk of the email being sent twice
+	RepeatabilityRequestID string `json:"-"`
+	RepeatabilityFirstSent string `json:"-"`
 }
 
+// Transform modifies an email before it is sent, see Email.AddTransform
+type Transform func(e *Email) error
+
 // Recipients contains the To, CC and BCC recipients of the email
 type Recipients struct {
 	To  []Address `json:"to"`
@@ -68,6 +91,11 @@ type SMSRecipient struct {
 	To                     string `json:"to"`
 	RepeatabilityFirstSent string `json:"repeatabilityFirstSent"`
 	RepeatabilityRequestID string `json:"repeatabilityRequestId"`
+
+	// IdempotencyKey identifies the message to this recipient, so retries with the same key
+	// are only delivered once. It's ignored when RepeatabilityRequestID is set, and like the
+	// email key it's in process only
+	IdempotencyKey string `json:"-"`
 }
 
 type SMSOptions struct {
@@ -101,10 +129,10 @@ type SMSSendResponse struct {
 
 // SmsSendResponseItem contains the response for a single SMS
 type SMSSendResponseItem struct {
-	ErrorMessage        string `json:"errorMessage"`
-	HTTPStatusCode      int    `json:"httpStatusCode"`
-	MessageID           string `json:"messageId"`
-	RepeatabilityResult string `json:"repeatabilityResult"`
-	Successful          bool   `json:"successful"`
-	To                  string `json:"to"`
+	ErrorMessage        string              `json:"errorMessage"`
+	HTTPStatusCode      int                 `json:"httpStatusCode"`
+	MessageID           string              `json:"messageId"`
+	RepeatabilityResult RepeatabilityResult `json:"repeatabilityResult"`
+	Successful          bool                `json:"successful"`
+	To                  string              `json:"to"`
 }
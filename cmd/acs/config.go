@@ -0,0 +1,192 @@
+package main
+
+// ==============================================================================
+// Configuration, from flags, then environment variables, then a JSON config
+// file. The config file defaults to <user config dir>/acs/config.json, and can
+// have named profiles, selected with --profile or ACS_PROFILE
+// ==============================================================================
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+// config is the JSON config file
+type config struct {
+	ConnectionString string `json:"connectionString"`
+	Endpoint         string `json:"endpoint"`
+	AccessKey        string `json:"accessKey"`
+	From             string `json:"from"`    // Default email sender
+	SMSFrom          string `json:"smsFrom"` // Default SMS number
+
+	// Named profiles, used instead of the settings above when selected
+	client.Profiles
+}
+
+// globalFlags are the flags shared by all commands
+type globalFlags struct {
+	connectionString string
+	endpoint         string
+	accessKey        string
+	configFile       string
+	profile          string
+	asJSON           bool
+}
+
+func addGlobalFlags(fs *flag.FlagSet) *globalFlags {
+	g := &globalFlags{}
+
+	fs.StringVar(&g.connectionString, "connection-string", "", "ACS connection string")
+	fs.StringVar(&g.endpoint, "endpoint", "", "ACS endpoint, e.g. https://<resource>.communication.azure.com")
+	fs.StringVar(&g.accessKey, "access-key", "", "ACS access key")
+	fs.StringVar(&g.configFile, "config", "", "Config file, defaults to $ACS_CONFIG or <user config dir>/acs/config.json")
+	fs.StringVar(&g.profile, "profile", "", "Profile in the config file, defaults to $ACS_PROFILE or defaultProfile")
+	fs.BoolVar(&g.asJSON, "json", false, "Output JSON, for scripting")
+
+	return g
+}
+
+// load merges the flags, environment and config file, and creates the client
+func (g *globalFlags) load() (*config, *client.Client, error) {
+	cfg, err := readConfig(g.configFile)
+	if err != nil {
+		return nil, nil, withCode(exitConfig, err)
+	}
+
+	flags := connection{g.connectionString, g.endpoint, g.accessKey}
+	env := connection{os.Getenv("ACS_CONNECTION_STRING"), os.Getenv("ACS_ENDPOINT"), os.Getenv("ACS_ACCESS_KEY")}
+	file := connection{cfg.ConnectionString, cfg.Endpoint, cfg.AccessKey}
+
+	// A named profile is used, or the default profile when there's no connection in the flags or environment
+	name := g.profile
+	if name == "" {
+		name = os.Getenv(client.ProfileEnv)
+	}
+
+	if name == "" && !flags.complete() && !env.complete() {
+		name = cfg.DefaultProfile
+	}
+
+	if name != "" {
+		return g.loadProfile(cfg, name)
+	}
+
+	// The first complete connection is used, otherwise each source overrides the one before it,
+	// so a connection string in the environment isn't mixed with an endpoint from the file
+	merged := connection{}
+
+	switch {
+	case flags.complete():
+		merged = flags
+	case env.complete():
+		merged = env
+	case file.complete():
+		merged = file
+	default:
+		override(&merged.endpoint, file.endpoint, env.endpoint, flags.endpoint)
+		override(&merged.accessKey, file.accessKey, env.accessKey, flags.accessKey)
+	}
+
+	if !merged.complete() {
+		return nil, nil, withCode(exitConfig, errors.New("no ACS configuration, set a connection string, or endpoint and access key"))
+	}
+
+	// As in a profile, the key and connection string can be env:NAME or file:/path references
+	p := client.Profile{ConnectionString: merged.connectionString, Endpoint: merged.endpoint, AccessKey: merged.accessKey}
+
+	c, err := p.NewClient()
+	if err != nil {
+		return nil, nil, withCode(exitConfig, err)
+	}
+
+	return cfg, c, nil
+}
+
+// loadProfile creates the client from a profile, connection flags override the profile
+func (g *globalFlags) loadProfile(cfg *config, name string) (*config, *client.Client, error) {
+	if (g.endpoint == "") != (g.accessKey == "") {
+		return nil, nil, withCode(exitUsage, errors.New("--endpoint and --access-key must be given together to override a profile"))
+	}
+
+	profile, err := cfg.Get(name)
+	if err != nil {
+		return nil, nil, withCode(exitConfig, err)
+	}
+
+	p := *profile
+
+	if g.connectionString != "" {
+		p.ConnectionString = g.connectionString
+	}
+
+	if g.endpoint != "" && g.accessKey != "" {
+		p.Endpoint, p.AccessKey, p.ConnectionString = g.endpoint, g.accessKey, ""
+	}
+
+	c, err := p.NewClient()
+	if err != nil {
+		return nil, nil, withCode(exitConfig, fmt.Errorf("profile %s: %s", name, err))
+	}
+
+	cfg.From = c.Defaults.From
+	cfg.SMSFrom = c.Defaults.SMSFrom
+
+	return cfg, c, nil
+}
+
+// connection is the connection settings from one source
+type connection struct {
+	connectionString string
+	endpoint         string
+	accessKey        string
+}
+
+// complete is true when the source has enough to create a client on its own
+func (c connection) complete() bool {
+	return c.connectionString != "" || (c.endpoint != "" && c.accessKey != "")
+}
+
+func override(v *string, values ...string) {
+	for _, value := range values {
+		if value != "" {
+			*v = value
+		}
+	}
+}
+
+// readConfig reads the config file, a missing default config file is not an error
+func readConfig(path string) (*config, error) {
+	cfg := &config{}
+	explicit := path != ""
+
+	if !explicit {
+		explicit = os.Getenv(client.ConfigEnv) != ""
+
+		var err error
+
+		if path, err = client.DefaultConfigPath(); err != nil {
+			return cfg, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return cfg, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %s", err)
+	}
+
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %s", path, err)
+	}
+
+	return cfg, nil
+}
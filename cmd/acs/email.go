@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+// Email statuses that mean the email is still being processed, or has failed
+var (
+	pendingStatuses = map[string]bool{"queued": true, "outfordelivery": true, "notstarted": true, "running": true}
+	failedStatuses  = map[string]bool{"dropped": true, "failed": true, "canceled": true, "cancelled": true}
+)
+
+type emailSendResult struct {
+	MessageID           string `json:"messageId"`
+	RepeatabilityResult string `json:"repeatabilityResult,omitempty"`
+}
+
+type emailStatusResult struct {
+	MessageID string `json:"messageId"`
+	Status    string `json:"status"`
+}
+
+func emailSend(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("email send", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	g := addGlobalFlags(fs)
+
+	var to, cc, bcc, replyTo stringList
+
+	var attachments, headers repeated
+
+	from := fs.String("from", "", "Sender address, defaults to 'from' in the config file")
+	subject := fs.String("subject", "", "Subject (required)")
+	body := fs.String("body", "", "File with the body, or - for stdin. Defaults to stdin")
+	isHTML := fs.Bool("html", false, "The body is HTML, a plain text alternative is generated")
+	importance := fs.String("importance", client.ImportanceNormal, "Importance: low, normal or high")
+	key := fs.String("idempotency-key", "", "Key so retries of this command are only delivered once")
+
+	fs.Var(&to, "to", "Recipient address, can be repeated or comma separated (required)")
+	fs.Var(&cc, "cc", "CC address, can be repeated or comma separated")
+	fs.Var(&bcc, "bcc", "BCC address, can be repeated or comma separated")
+	fs.Var(&replyTo, "reply-to", "Reply to address, can be repeated or comma separated")
+	fs.Var(&attachments, "attach", "File to attach, can be repeated")
+	fs.Var(&headers, "header", "Custom header as 'Name: value', can be repeated")
+
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	// Bad usage is reported before any problem with the configuration
+	if len(to) == 0 || *subject == "" {
+		fs.Usage()
+
+		return withCode(exitUsage, errors.New("--to and --subject are required"))
+	}
+
+	switch *importance {
+	case client.ImportanceLow, client.ImportanceNormal, client.ImportanceHigh:
+	default:
+		return withCode(exitUsage, fmt.Errorf("invalid importance %q", *importance))
+	}
+
+	cfg, acsClient, err := g.load()
+	if err != nil {
+		return err
+	}
+
+	override(from, cfg.From, *from)
+
+	if *from == "" {
+		fs.Usage()
+
+		return withCode(exitUsage, errors.New("--from is required, or set 'from' in the config file"))
+	}
+
+	content, err := readBody(*body, stdin)
+	if err != nil {
+		return withCode(exitUsage, fmt.Errorf("error reading body: %s", err))
+	}
+
+	var e *client.Email
+	if *isHTML {
+		e = client.NewHTMLEmail(*from, to[0], *subject, content)
+	} else {
+		e = client.NewPlainEmail(*from, to[0], *subject, content)
+	}
+
+	for _, address := range to[1:] {
+		e.Recipients.To = append(e.Recipients.To, client.Address{Email: address, DisplayName: address})
+	}
+
+	for _, address := range cc {
+		e.AddCC(address, address)
+	}
+
+	for _, address := range bcc {
+		e.AddBCC(address, address)
+	}
+
+	for _, address := range replyTo {
+		e.AddReplyTo(address, address)
+	}
+
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			return withCode(exitUsage, fmt.Errorf("invalid header %q, use 'Name: value'", header))
+		}
+
+		e.AddCustomHeader(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	for _, path := range attachments {
+		if err := e.AddAttachmentFile(path); err != nil {
+			return withCode(exitUsage, fmt.Errorf("error attaching %s: %s", path, err))
+		}
+	}
+
+	e.Importance = *importance
+	e.SetIdempotencyKey(*key)
+
+	result, err := acsClient.SendEmailWithResult(ctx, e)
+	if err != nil {
+		return withCode(exitError, err)
+	}
+
+	return output(stdout, g.asJSON, emailSendResult{
+		MessageID:           result.MessageID,
+		RepeatabilityResult: string(result.RepeatabilityResult),
+	}, result.MessageID)
+}
+
+func emailStatus(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("email status", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	g := addGlobalFlags(fs)
+
+	wait := fs.Bool("wait", false, "Wait until the email has been delivered or has failed")
+	interval := fs.Duration("interval", 5*time.Second, "How often to check the status when waiting")
+	timeout := fs.Duration("timeout", 5*time.Minute, "How long to wait")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: acs email status <message-id> [flags]")
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		fs.Usage()
+
+		return withCode(exitUsage, errors.New("a message ID is required"))
+	}
+
+	_, acsClient, err := g.load()
+	if err != nil {
+		return err
+	}
+
+	messageID := positional[0]
+
+	if *wait {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	for {
+		status, err := acsClient.GetEmailStatusContext(ctx, messageID)
+		if err != nil {
+			if *wait && errors.Is(err, context.DeadlineExceeded) {
+				return withCode(exitNotDelivered, fmt.Errorf("email not delivered after %s", *timeout))
+			}
+
+			return withCode(exitError, err)
+		}
+
+		normalised := strings.ToLower(status)
+		if !*wait || !pendingStatuses[normalised] {
+			if err := output(stdout, g.asJSON, emailStatusResult{MessageID: messageID, Status: status}, status); err != nil {
+				return err
+			}
+
+			if failedStatuses[normalised] {
+				return withCode(exitNotDelivered, fmt.Errorf("email status is %s", status))
+			}
+
+			return nil
+		}
+
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			return withCode(exitNotDelivered, fmt.Errorf("email still %s after %s", status, *timeout))
+		}
+	}
+}
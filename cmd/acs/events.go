@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/events"
+)
+
+const shutdownTimeout = 5 * time.Second
+const readHeaderTimeout = 10 * time.Second
+
+func eventsListen(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("events listen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var types, messageIDs stringList
+
+	host := fs.String("host", "127.0.0.1", "Address to listen on, use 0.0.0.0 to accept events from other hosts")
+	port := fs.Int("port", 8080, "Port to listen on")
+	path := fs.String("path", "/", "URL path of the webhook")
+	asJSON := fs.Bool("json", false, "Output each event as a line of JSON, for scripting")
+
+	fs.Var(&types, "type", "Only show these event types, e.g. email.delivery, email.engagement, sms.delivery, sms.received")
+	fs.Var(&messageIDs, "message-id", "Only show events for these message IDs, can be repeated or comma separated")
+
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	filter := events.Filter{MessageIDs: messageIDs}
+
+	for _, name := range types {
+		eventType, err := events.ParseType(name)
+		if err != nil {
+			return withCode(exitUsage, err)
+		}
+
+		filter.Types = append(filter.Types, eventType)
+	}
+
+	// Events can arrive on several requests at once
+	mu := sync.Mutex{}
+	enc := json.NewEncoder(stdout)
+
+	mux := http.NewServeMux()
+	mux.Handle(*path, events.Handler(filter, func(e *events.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if *asJSON {
+			_ = enc.Encode(e)
+
+			return
+		}
+
+		fmt.Fprintln(stdout, formatEvent(e))
+	}))
+
+	server := &http.Server{
+		Addr:              net.JoinHostPort(*host, strconv.Itoa(*port)),
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(stderr, "Listening for Event Grid events on http://%s%s, press Ctrl+C to stop\n", server.Addr, *path)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return withCode(exitError, err)
+	}
+
+	return nil
+}
+
+// formatEvent returns a one line summary of the event
+func formatEvent(e *events.Event) string {
+	name := e.EventType
+
+	for short, eventType := range events.ShortNames {
+		if eventType == e.EventType {
+			name = short
+		}
+	}
+
+	fields := []string{e.EventTime.Local().Format(time.TimeOnly), fmt.Sprintf("%-16s", name), e.MessageID()}
+
+	data, err := e.Decode()
+	if err != nil {
+		return strings.Join(append(fields, err.Error()), "  ")
+	}
+
+	switch d := data.(type) {
+	case *events.EmailDeliveryReport:
+		fields = append(fields, d.Status, d.Sender+" -> "+d.Recipient, d.DeliveryStatusDetails.StatusMessage)
+	case *events.EmailEngagement:
+		fields = append(fields, d.EngagementType, d.Recipient, d.EngagementContext)
+	case *events.SMSDeliveryReport:
+		fields = append(fields, d.DeliveryStatus, d.From+" -> "+d.To, d.DeliveryStatusDetails)
+	case *events.SMSReceived:
+		fields = append(fields, d.From+" -> "+d.To, fmt.Sprintf("%q", d.Message))
+	default:
+		fields = append(fields, e.Subject)
+	}
+
+	return strings.TrimSpace(strings.Join(fields, "  "))
+}
@@ -0,0 +1,200 @@
+package main
+
+// ==============================================================================
+// Command line tool for sending email and SMS with Azure Communication Services
+//   acs email send --from x --to y --subject z < body.txt
+//   acs email status <message-id> [--wait]
+//   acs sms send --from x --to y --message z
+//   acs events listen [--host 127.0.0.1] --port 8080 [--type sms.delivery] [--message-id id]
+// ==============================================================================
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Exit codes, so scripts can tell failures apart
+const (
+	exitOK           = 0
+	exitError        = 1 // The API call failed
+	exitUsage        = 2 // Bad command or flags
+	exitConfig       = 3 // Missing or invalid configuration
+	exitNotDelivered = 4 // The message failed, or wasn't delivered before --timeout
+)
+
+const usage = `Usage: acs <command> [flags]
+
+Commands:
+  email send     Send an email, the body is read from --body or stdin
+  email status   Get the status of an email, optionally waiting for delivery
+  sms send       Send a SMS
+  events listen  Receive Event Grid events on a local webhook and print them
+
+Run 'acs <command> -h' for the flags of each command
+
+Configuration is taken from flags, then the environment, then the config file:
+  --connection-string  ACS_CONNECTION_STRING  connectionString
+  --endpoint           ACS_ENDPOINT           endpoint
+  --access-key         ACS_ACCESS_KEY         accessKey
+
+Exit codes:
+  0  Success
+  1  The API call failed
+  2  Bad command or flags
+  3  Missing or invalid configuration
+  4  The message failed, or wasn't delivered in time
+`
+
+// cliError carries an exit code with an error
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string {
+	return e.err.Error()
+}
+
+func withCode(code int, err error) error {
+	return &cliError{code: code, err: err}
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+
+	stop()
+
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+
+	os.Exit(exitCode(err))
+}
+
+// exitCode returns the exit code for an error returned by run
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	cliErr := &cliError{}
+	if errors.As(err, &cliErr) {
+		return cliErr.code
+	}
+
+	return exitError
+}
+
+// run runs a command, usage and progress are written to stderr and results to stdout
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) < 2 {
+		fmt.Fprint(stderr, usage)
+
+		return withCode(exitUsage, errors.New("no command given"))
+	}
+
+	command := args[0] + " " + args[1]
+	args = args[2:]
+
+	switch command {
+	case "email send":
+		return emailSend(ctx, args, stdin, stdout, stderr)
+	case "email status":
+		return emailStatus(ctx, args, stdout, stderr)
+	case "sms send":
+		return smsSend(ctx, args, stdin, stdout, stderr)
+	case "events listen":
+		return eventsListen(ctx, args, stdout, stderr)
+	default:
+		fmt.Fprint(stderr, usage)
+
+		return withCode(exitUsage, fmt.Errorf("unknown command %q", command))
+	}
+}
+
+// parseFlags parses flags which can appear before or after positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, withCode(exitUsage, err)
+		}
+
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// output writes the result as JSON, or as text
+func output(w io.Writer, asJSON bool, v any, text string) error {
+	if !asJSON {
+		_, err := fmt.Fprintln(w, text)
+
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+// readBody reads from a file, or stdin if the path is empty or "-"
+func readBody(path string, stdin io.Reader) (string, error) {
+	if path == "" || path == "-" {
+		data, err := io.ReadAll(stdin)
+
+		return strings.TrimRight(string(data), "\n"), err
+	}
+
+	data, err := os.ReadFile(path)
+
+	return string(data), err
+}
+
+// stringList is a flag which can be repeated, and also accepts comma separated values
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*s = append(*s, item)
+		}
+	}
+
+	return nil
+}
+
+// repeated is a flag which can be repeated, values are kept as they are
+type repeated []string
+
+func (r *repeated) String() string {
+	return strings.Join(*r, " ")
+}
+
+func (r *repeated) Set(v string) error {
+	*r = append(*r, v)
+
+	return nil
+}
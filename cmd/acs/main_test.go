@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+// badNumber is rejected by fakeACS, other numbers are sent
+const badNumber = "+15550000002"
+
+// fakeACS answers with its name as the message ID, so a test can tell which resource was used
+func fakeACS(t *testing.T, name string, status int) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sms" {
+			s := client.SMS{}
+			_ = json.NewDecoder(r.Body).Decode(&s)
+
+			to := s.SMSRecipients[0].To
+			if to == badNumber {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error": {"code": "BadRequest", "message": "Invalid number"}}`)
+
+				return
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, `{"value": [{"to": %q, "messageId": %q, "successful": true, "httpStatusCode": 202}]}`, to, name)
+
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			messageID := strings.Split(strings.TrimPrefix(r.URL.Path, "/emails/"), "/")[0]
+
+			status := "Succeeded"
+			if messageID == "failed" {
+				status = "Failed"
+			}
+
+			fmt.Fprintf(w, `{"id": %q, "status": %q}`, messageID, status)
+
+			return
+		}
+
+		w.Header().Set("x-ms-request-id", name)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL
+}
+
+func TestRun(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("key"))
+
+	urls := map[string]string{}
+	for _, name := range []string{"file", "env", "flag", "profile-a", "profile-b"} {
+		urls[name] = fakeACS(t, name, http.StatusAccepted)
+	}
+
+	urls["bad"] = fakeACS(t, "bad", http.StatusBadRequest)
+
+	dir := t.TempDir()
+	flat := filepath.Join(dir, "flat.json")
+	profiles := filepath.Join(dir, "profiles.json")
+	missing := filepath.Join(dir, "missing.json")
+	keyFile := filepath.Join(dir, "key")
+
+	_ = os.WriteFile(keyFile, []byte(key+"\n"), 0o600)
+
+	_ = os.WriteFile(flat, []byte(fmt.Sprintf(`{"endpoint": %q, "accessKey": %q, "from": "file@blah.net"}`,
+		urls["file"], key)), 0o600)
+	_ = os.WriteFile(profiles, []byte(fmt.Sprintf(`{
+  "defaultProfile": "b",
+  "profiles": {
+    "a": { "endpoint": %q, "accessKey": %q, "from": "a@blah.net" },
+    "b": { "endpoint": %q, "accessKey": %q, "from": "b@blah.net" }
+  }
+}`, urls["profile-a"], key, urls["profile-b"], key)), 0o600)
+
+	send := []string{"email", "send", "--to", "alice@example.net", "--subject", "Hi"}
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		code   int
+		stdout string
+		usage  bool
+	}{
+		{name: "no command", args: []string{"email"}, code: exitUsage, usage: true},
+		{name: "unknown command", args: []string{"email", "delete"}, code: exitUsage, usage: true},
+		{name: "unknown flag", args: append(send, "--nope"), code: exitUsage},
+		{name: "bad usage before config", args: []string{"email", "send", "--config", missing}, code: exitUsage, usage: true},
+		{name: "bad importance before config", args: append(send, "--importance", "urgent", "--config", missing), code: exitUsage},
+		{name: "sms bad usage before config", args: []string{"sms", "send", "--config", missing}, code: exitUsage, usage: true},
+		{name: "missing config", args: append(send, "--config", missing), code: exitConfig},
+		{name: "no config", args: send, code: exitConfig},
+		{name: "missing from", args: append(send, "--endpoint", urls["flag"], "--access-key", key), code: exitUsage},
+		{name: "file", args: append(send, "--config", flat), stdout: "file"},
+		{
+			name:   "env over file",
+			args:   append(send, "--config", flat),
+			env:    map[string]string{"ACS_ENDPOINT": urls["env"], "ACS_ACCESS_KEY": key},
+			stdout: "env",
+		},
+		{
+			name:   "env connection string over file",
+			args:   append(send, "--config", flat),
+			env:    map[string]string{"ACS_CONNECTION_STRING": "endpoint=" + urls["env"] + ";accesskey=" + key},
+			stdout: "env",
+		},
+		{
+			name:   "env key reference",
+			args:   append(send, "--config", flat),
+			env:    map[string]string{"ACS_ENDPOINT": urls["env"], "ACS_ACCESS_KEY": "file:" + keyFile},
+			stdout: "env",
+		},
+		{
+			name: "missing key reference",
+			args: append(send, "--config", flat),
+			env:  map[string]string{"ACS_ENDPOINT": urls["env"], "ACS_ACCESS_KEY": "env:ACS_TEST_UNSET_KEY"},
+			code: exitConfig,
+		},
+		{
+			name:   "flags over env",
+			args:   append(send, "--config", flat, "--endpoint", urls["flag"], "--access-key", key),
+			env:    map[string]string{"ACS_ENDPOINT": urls["env"], "ACS_ACCESS_KEY": key},
+			stdout: "flag",
+		},
+		{name: "default profile", args: append(send, "--config", profiles), stdout: "profile-b"},
+		{name: "profile flag", args: append(send, "--config", profiles, "--profile", "a"), stdout: "profile-a"},
+		{
+			name:   "profile env",
+			args:   append(send, "--config", profiles),
+			env:    map[string]string{"ACS_PROFILE": "a"},
+			stdout: "profile-a",
+		},
+		{
+			name:   "profile flag over env",
+			args:   append(send, "--config", profiles, "--profile", "a"),
+			env:    map[string]string{"ACS_PROFILE": "b"},
+			stdout: "profile-a",
+		},
+		{
+			name:   "env connection over default profile",
+			args:   append(send, "--config", profiles, "--from", "env@blah.net"),
+			env:    map[string]string{"ACS_ENDPOINT": urls["env"], "ACS_ACCESS_KEY": key},
+			stdout: "env",
+		},
+		{
+			name:   "config from env",
+			args:   send,
+			env:    map[string]string{"ACS_CONFIG": profiles, "ACS_PROFILE": "a"},
+			stdout: "profile-a",
+		},
+		{name: "access key without endpoint with profile", args: append(send, "--config", profiles, "--access-key", key), code: exitUsage},
+		{
+			name:   "flags over profile",
+			args:   append(send, "--config", profiles, "--profile", "a", "--endpoint", urls["flag"], "--access-key", key),
+			stdout: "flag",
+		},
+		{name: "unknown profile", args: append(send, "--config", profiles, "--profile", "c"), code: exitConfig},
+		{name: "API error", args: append(send, "--config", flat, "--endpoint", urls["bad"], "--access-key", key), code: exitError},
+		{name: "sms", args: []string{"sms", "send", "--config", flat, "--from", "+15550000000", "--to", "+15550000001"}, stdout: "+15550000001 file"},
+		{
+			name:   "sms failed recipient",
+			args:   []string{"sms", "send", "--config", flat, "--from", "+15550000000", "--to", "+15550000001," + badNumber + ",+15550000003"},
+			code:   exitError,
+			stdout: "+15550000001 file\n" + badNumber + " \n+15550000003 file",
+		},
+		{name: "status", args: []string{"email", "status", "sent", "--config", flat}, stdout: "Succeeded"},
+		{name: "status failed", args: []string{"email", "status", "failed", "--config", flat}, code: exitNotDelivered, stdout: "Failed"},
+		{name: "status without message ID", args: []string{"email", "status", "--config", flat}, code: exitUsage, usage: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Nothing from the environment running the tests is used
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+			for _, name := range []string{"ACS_CONFIG", "ACS_PROFILE", "ACS_CONNECTION_STRING", "ACS_ENDPOINT", "ACS_ACCESS_KEY"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+			err := run(context.Background(), test.args, strings.NewReader("Hello"), stdout, stderr)
+
+			if code := exitCode(err); code != test.code {
+				t.Errorf("expected exit code %d, got %d: %v", test.code, exitCode(err), err)
+			}
+
+			if strings.TrimSpace(stdout.String()) != test.stdout {
+				t.Errorf("expected output %q, got %q", test.stdout, stdout.String())
+			}
+
+			if test.usage && !strings.Contains(stderr.String(), "Usage") {
+				t.Errorf("expected usage on stderr, got %q", stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+func smsSend(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("sms send", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	g := addGlobalFlags(fs)
+
+	var to stringList
+
+	from := fs.String("from", "", "Sender number, defaults to 'smsFrom' in the config file")
+	message := fs.String("message", "", "Message text, read from stdin when not set")
+	deliveryReport := fs.Bool("delivery-report", false, "Request a delivery report event")
+	tag := fs.String("tag", "", "Tag included in delivery reports")
+
+	fs.Var(&to, "to", "Recipient number, can be repeated or comma separated (required)")
+
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	// Bad usage is reported before any problem with the configuration
+	if len(to) == 0 {
+		fs.Usage()
+
+		return withCode(exitUsage, errors.New("--to is required"))
+	}
+
+	cfg, acsClient, err := g.load()
+	if err != nil {
+		return err
+	}
+
+	override(from, cfg.SMSFrom, *from)
+
+	if *from == "" {
+		fs.Usage()
+
+		return withCode(exitUsage, errors.New("--from is required, or set 'smsFrom' in the config file"))
+	}
+
+	if *message == "" {
+		if *message, err = readBody("", stdin); err != nil {
+			return withCode(exitUsage, fmt.Errorf("error reading message: %s", err))
+		}
+	}
+
+	results := []*client.SMSSendResponseItem{}
+	failed := []string{}
+
+	for _, number := range to {
+		s := client.NewSMS(*from, number, *message)
+		s.SMSSendOptions.EnableDeliveryReport = *deliveryReport
+		s.SMSSendOptions.Tag = *tag
+
+		// A request which failed is recorded like a failed recipient, so the results are still output
+		resp, err := acsClient.SendSingleSMSContext(ctx, s)
+		if err != nil {
+			resp = &client.SMSSendResponseItem{To: number, ErrorMessage: err.Error()}
+
+			apiErr := &client.APIError{}
+			if errors.As(err, &apiErr) {
+				resp.HTTPStatusCode = apiErr.StatusCode
+			}
+		}
+
+		results = append(results, resp)
+
+		if !resp.Successful {
+			failed = append(failed, fmt.Sprintf("%s: %s", number, resp.ErrorMessage))
+		}
+
+		// Cancelled, e.g. by Ctrl+C, so the rest aren't sent
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	lines := make([]string, 0, len(results))
+	for _, resp := range results {
+		lines = append(lines, resp.To+" "+resp.MessageID)
+	}
+
+	if err := output(stdout, g.asJSON, results, strings.Join(lines, "\n")); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return withCode(exitError, fmt.Errorf("SMS failed for %s", strings.Join(failed, ", ")))
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+// ==============================================================================
+// Prometheus Alertmanager webhook receiver, sends alerts by email and SMS with ACS
+// Set ACS_CONNECTION_STRING, or ACS_ENDPOINT and ACS_ACCESS_KEY in the
+// environment or a .env file, or use a profile from the config file
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/alertmanager"
+	"github.com/benc-uk/go-acs-client/client"
+
+	"github.com/joho/godotenv"
+)
+
+const shutdownTimeout = 5 * time.Second
+const readHeaderTimeout = 10 * time.Second
+
+func main() {
+	_ = godotenv.Load()
+
+	addr := flag.String("addr", "localhost:9095", "Address to listen on")
+	path := flag.String("path", "/alerts", "Path Alertmanager sends webhooks to")
+	configFile := flag.String("config", "alertmanager-acs.json", "Config file with the routes and recipients")
+	dedupWindow := flag.Duration("dedup-window", time.Hour, "Repeats of a notification in this time are not sent again")
+	bearerToken := flag.String("bearer-token", "", "Token Alertmanager must send, or an env:NAME or file:/path reference")
+	basicAuth := flag.String("basic-auth", "", "username:password Alertmanager must send, or an env:NAME or file:/path reference")
+	profile := flag.String("profile", "", "Profile in the config file, defaults to $ACS_PROFILE, then the environment")
+
+	flag.Parse()
+
+	cfg, err := alertmanager.LoadConfig(*configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	acsClient, err := client.NewFromEnvironment(*profile)
+	if err != nil {
+		fail(err)
+	}
+
+	opts := alertmanager.Options{
+		DedupWindow: *dedupWindow,
+		OnResult: func(r alertmanager.Result) {
+			switch {
+			case r.Err != nil:
+				fmt.Printf("❌ %s %s via %s to %s: %s\n", r.Status, r.GroupKey, r.Route, r.Recipient, r.Err)
+			case r.Duplicate:
+				fmt.Printf("🔁 %s %s via %s to %s already sent\n", r.Status, r.GroupKey, r.Route, r.Recipient)
+			default:
+				fmt.Printf("✅ %s %s via %s to %s %s\n", r.Status, r.GroupKey, r.Route, r.Recipient, r.MessageID)
+			}
+		},
+	}
+
+	if *bearerToken != "" {
+		if opts.BearerToken, err = client.ResolveSecret(*bearerToken); err != nil {
+			fail(fmt.Errorf("bearer token: %s", err))
+		}
+	}
+
+	if *basicAuth != "" {
+		credentials, err := client.ResolveSecret(*basicAuth)
+		if err != nil {
+			fail(fmt.Errorf("basic auth: %s", err))
+		}
+
+		var found bool
+		if opts.Username, opts.Password, found = strings.Cut(credentials, ":"); !found {
+			fail(errors.New("basic auth must be username:password"))
+		}
+	}
+
+	if opts.BearerToken == "" && opts.Username == "" {
+		fmt.Fprintln(os.Stderr, "Warning: anyone who can reach the receiver can send alerts, use -bearer-token or -basic-auth")
+	}
+
+	receiver, err := alertmanager.New(acsClient, cfg, opts)
+	if err != nil {
+		fail(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(*path, receiver)
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("🚨 Alertmanager receiver listening on %s%s\n", *addr, *path)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,84 @@
+package main
+
+// ==============================================================================
+// Development mail catcher, captures emails and SMS instead of sending them
+// Point apps at it with ACS_CONNECTION_STRING="endpoint=http://localhost:8025;accesskey=ZGV2"
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/devmail"
+)
+
+const shutdownTimeout = 5 * time.Second
+const readHeaderTimeout = 10 * time.Second
+
+func main() {
+	addr := flag.String("addr", "localhost:8025", "Address to listen on")
+	dir := flag.String("dir", "", "Directory to keep messages in, by default they are kept in memory")
+
+	opts := []devmail.Option{}
+
+	flag.Func("sender", "Display name of a sender address as \"Name <address>\", shown like ACS does, can be repeated", func(v string) error {
+		a, err := mail.ParseAddress(v)
+		if err != nil {
+			return err
+		}
+
+		opts = append(opts, devmail.WithSenderName(a.Address, a.Name))
+
+		return nil
+	})
+
+	flag.Parse()
+
+	var store devmail.Store = devmail.NewMemoryStore()
+
+	if *dir != "" {
+		fileStore, err := devmail.NewFileStore(*dir)
+		if err != nil {
+			fail(err)
+		}
+
+		store = fileStore
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           devmail.New(store, opts...),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("📬 Dev mail UI on http://%s\n", *addr)
+	fmt.Printf("   Send to it with ACS_CONNECTION_STRING=\"endpoint=http://%s;accesskey=ZGV2\", any key works\n", *addr)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,126 @@
+package main
+
+// ==============================================================================
+// Notification gateway, lets systems which can fire a webhook send email and SMS
+// Set ACS_CONNECTION_STRING, or ACS_ENDPOINT and ACS_ACCESS_KEY in the
+// environment or a .env file, or use a profile from the config file
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+	"github.com/benc-uk/go-acs-client/gateway"
+	"github.com/benc-uk/go-acs-client/outbox"
+
+	"github.com/joho/godotenv"
+)
+
+const shutdownTimeout = 5 * time.Second
+const readHeaderTimeout = 10 * time.Second
+
+func main() {
+	_ = godotenv.Load()
+
+	addr := flag.String("addr", ":8080", "Address to listen on")
+	configFile := flag.String("config", "gateway.json", "Config file with the callers, their keys, quotas and senders")
+	dir := flag.String("dir", "", "Directory to queue messages in, by default they are queued in memory")
+	quotaPeriod := flag.Duration("quota-period", 24*time.Hour, "Period caller quotas apply to")
+	workers := flag.Int("workers", 4, "Number of messages sent in parallel")
+	profile := flag.String("profile", "", "Profile in the config file, defaults to $ACS_PROFILE, then the environment")
+
+	flag.Parse()
+
+	cfg, err := gateway.LoadConfig(*configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	acsClient, err := client.NewFromEnvironment(*profile)
+	if err != nil {
+		fail(err)
+	}
+
+	var store outbox.Store = outbox.NewMemoryStore()
+
+	if *dir != "" {
+		fileStore, err := outbox.NewFileStore(*dir)
+		if err != nil {
+			fail(err)
+		}
+
+		store = fileStore
+	} else {
+		fmt.Fprintln(os.Stderr, "Warning: messages are queued in memory, unsent messages are lost on restart, use -dir to keep them")
+	}
+
+	box := outbox.New(acsClient, store, outbox.Options{
+		Workers: *workers,
+		OnComplete: func(m *outbox.Message) {
+			if m.State == outbox.StateFailed {
+				fmt.Printf("❌ %s %s failed: %s\n", m.Kind, m.ID, m.LastError)
+
+				return
+			}
+
+			fmt.Printf("✅ %s %s sent %s\n", m.Kind, m.ID, m.MessageID)
+		},
+	})
+
+	g, err := gateway.New(acsClient, box, cfg, gateway.Options{
+		QuotaPeriod: *quotaPeriod,
+		OnRequest: func(caller, kind string, ids []string, err error) {
+			if err != nil {
+				fmt.Printf("⛔ %s %s rejected: %s\n", caller, kind, err)
+
+				return
+			}
+
+			fmt.Printf("📥 %s %s queued %s\n", caller, kind, strings.Join(ids, ", "))
+		},
+	})
+	if err != nil {
+		fail(err)
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           g,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	box.Start(ctx)
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("📡 Notification gateway listening on %s\n", *addr)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fail(err)
+	}
+
+	box.Stop()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
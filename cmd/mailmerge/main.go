@@ -0,0 +1,136 @@
+package main
+
+// ==============================================================================
+// Mail merge command, sends personalised emails from a CSV or JSON Lines file
+// Set ACS_CONNECTION_STRING, or ACS_ENDPOINT and ACS_ACCESS_KEY in the
+// environment or a .env file, or use a profile from the config file
+// ==============================================================================
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/benc-uk/go-acs-client/client"
+	"github.com/benc-uk/go-acs-client/mailmerge"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	dataFile := flag.String("data", "", "CSV or JSON Lines file with a row per recipient (required)")
+	from := flag.String("from", "", "Sender address (required)")
+	subject := flag.String("subject", "", "Subject template (required)")
+	htmlFile := flag.String("html", "", "File with the HTML body template")
+	textFile := flag.String("text", "", "File with the plain text body template")
+	markdownFile := flag.String("markdown", "", "File with the Markdown body template")
+	toCol := flag.String("to-column", "email", "Column with the recipient address")
+	nameCol := flag.String("name-column", "", "Column with the recipient display name")
+	ccCol := flag.String("cc-column", "", "Column with CC addresses")
+	keyCol := flag.String("key-column", "", "Column uniquely identifying each row, defaults to the address")
+	dryRun := flag.String("dry-run", "", "Write rendered emails to this directory rather than sending")
+	checkpoint := flag.String("checkpoint", "", "Checkpoint file, used to resume a merge without re-sending")
+	concurrency := flag.Int("concurrency", 4, "Number of emails to send in parallel")
+	rate := flag.Float64("rate", 0, "Maximum emails sent per second, zero for no limit")
+	attempts := flag.Int("attempts", 3, "Attempts per email for throttling or server errors")
+	profile := flag.String("profile", "", "Profile in the config file, defaults to $ACS_PROFILE, then the environment")
+
+	flag.Parse()
+
+	if *dataFile == "" || *from == "" || *subject == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rows, err := mailmerge.ReadFile(*dataFile)
+	if err != nil {
+		fail(err)
+	}
+
+	m := &mailmerge.Merge{
+		Template: mailmerge.Template{
+			From:     *from,
+			Subject:  *subject,
+			HTML:     readFile(*htmlFile),
+			Text:     readFile(*textFile),
+			Markdown: readFile(*markdownFile),
+		},
+		Mapping: mailmerge.Mapping{
+			To:   *toCol,
+			Name: *nameCol,
+			CC:   *ccCol,
+			Key:  *keyCol,
+		},
+		DryRunDir:  *dryRun,
+		Checkpoint: *checkpoint,
+		Batch: client.BatchOptions{
+			Concurrency: *concurrency,
+			RateLimit:   *rate,
+			MaxAttempts: *attempts,
+		},
+		OnResult: func(r mailmerge.Result) {
+			switch {
+			case r.Duplicate:
+				fmt.Printf("⏩ %d %s: duplicate of an earlier row\n", r.Row, r.To)
+			case r.Stopped:
+				fmt.Printf("⏹️ %d %s: not sent, the merge stopped\n", r.Row, r.To)
+			case r.Skipped:
+				fmt.Printf("⏩ %d %s: already sent\n", r.Row, r.To)
+			case r.Err != nil:
+				fmt.Printf("❌ %d %s: %s\n", r.Row, r.To, r.Err)
+			default:
+				fmt.Printf("✅ %d %s %s\n", r.Row, r.To, r.MessageID)
+			}
+		},
+	}
+
+	if *dryRun == "" {
+		acsClient, err := client.NewFromEnvironment(*profile)
+		if err != nil {
+			fail(err)
+		}
+
+		m.Client = acsClient
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	summary, err := m.Run(ctx, rows)
+
+	if *dryRun != "" {
+		fmt.Printf("\nTotal: %d, rendered: %d, skipped: %d, failed: %d\n", summary.Total, summary.Rendered, summary.Skipped, summary.Failed)
+	} else {
+		fmt.Printf("\nTotal: %d, sent: %d, skipped: %d, failed: %d\n", summary.Total, summary.Sent, summary.Skipped, summary.Failed)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func readFile(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fail(err)
+	}
+
+	return string(data)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
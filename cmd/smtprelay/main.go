@@ -0,0 +1,143 @@
+package main
+
+// ==============================================================================
+// SMTP relay, lets apps and appliances which only speak SMTP send with ACS
+// Set ACS_CONNECTION_STRING, or ACS_ENDPOINT and ACS_ACCESS_KEY in the
+// environment or a .env file, or use a profile from the config file
+// ==============================================================================
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/benc-uk/go-acs-client/client"
+	"github.com/benc-uk/go-acs-client/smtprelay"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	addr := flag.String("addr", "localhost:2525", "Address to listen on")
+	domain := flag.String("domain", "", "Name given in the SMTP greeting, defaults to the hostname")
+	certFile := flag.String("cert", "", "TLS certificate file, enables STARTTLS")
+	keyFile := flag.String("key", "", "TLS key file")
+	usersFile := flag.String("users", "", "File with a username:password per line, when set clients must AUTH")
+	senders := flag.String("allow-senders", "", "Comma separated addresses or @domains allowed to send")
+	recipients := flag.String("allow-recipients", "", "Comma separated addresses or @domains allowed to receive")
+	sender := flag.String("sender", "", "Send all messages from this ACS address, the original sender becomes the reply to")
+	maxSize := flag.Int("max-size", 0, "Maximum message size in bytes, defaults to 10MB")
+	openRelay := flag.Bool("allow-open-relay", false, "Start without -users or -allow-senders, anyone who can connect can send")
+	profile := flag.String("profile", "", "Profile in the config file, defaults to $ACS_PROFILE, then the environment")
+
+	flag.Parse()
+
+	acsClient, err := client.NewFromEnvironment(*profile)
+	if err != nil {
+		fail(err)
+	}
+
+	opts := smtprelay.Options{
+		Addr:              *addr,
+		Domain:            *domain,
+		AllowedSenders:    split(*senders),
+		AllowedRecipients: split(*recipients),
+		Sender:            *sender,
+		MaxMessageSize:    *maxSize,
+		AllowOpenRelay:    *openRelay,
+		OnMessage: func(r smtprelay.Result) {
+			if r.Err != nil {
+				fmt.Printf("❌ %s %s -> %s: %s\n", r.Remote, r.From, strings.Join(r.Recipients, ", "), r.Err)
+
+				return
+			}
+
+			fmt.Printf("✅ %s %s -> %s %s\n", r.Remote, r.From, strings.Join(r.Recipients, ", "), r.MessageID)
+
+			for _, part := range r.Unmapped {
+				fmt.Printf("⚠️ %s part %s was not sent: %s\n", part.ContentType, part.Name, part.Reason)
+			}
+		},
+	}
+
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			fail(fmt.Errorf("error loading certificate: %s", err))
+		}
+
+		opts.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	if *usersFile != "" {
+		opts.Users, err = readUsers(*usersFile)
+		if err != nil {
+			fail(err)
+		}
+	}
+
+	if opts.TLSConfig == nil && opts.Users != nil {
+		fmt.Fprintln(os.Stderr, "Warning: passwords will be sent unencrypted, use -cert and -key to enable STARTTLS")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("📨 SMTP relay listening on %s\n", *addr)
+
+	if err := smtprelay.New(acsClient, opts).ListenAndServe(ctx); err != nil {
+		fail(err)
+	}
+}
+
+// readUsers reads a file of username:password lines, blank lines and # comments are skipped
+func readUsers(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading users: %s", err)
+	}
+	defer f.Close()
+
+	users := map[string]string{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, password, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid line in %s, use username:password", path)
+		}
+
+		users[user] = password
+	}
+
+	return users, scanner.Err()
+}
+
+func split(list string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,474 @@
+package devmail
+
+// ==============================================================================
+// Development mail catcher, a fake ACS endpoint which captures emails and SMS
+// rather than sending them, with a web UI and JSON API to view them. Point the
+// client at it by changing the endpoint, e.g.
+// ACS_CONNECTION_STRING="endpoint=http://localhost:8025;accesskey=ZGV2"
+// ==============================================================================
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+
+	"github.com/google/uuid"
+)
+
+// Emails captured are always reported as out for delivery
+const emailStatus = "OutForDelivery"
+
+// Request bodies are limited, ACS allows up to 10MB of attachments
+const maxRequestSize = 16 * 1024 * 1024
+
+//go:embed ui.html
+var uiFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"addresses": formatAddresses,
+	"time":      func(t time.Time) string { return t.Local().Format("2 Jan 15:04:05") },
+}).ParseFS(uiFS, "ui.html"))
+
+// Server captures messages sent to it, and serves the web UI and JSON API
+type Server struct {
+	store Store
+	mux   *http.ServeMux
+
+	// Display names by lower case sender address, see WithSenderName
+	senderNames map[string]string
+
+	// Held while checking for a repeat and capturing, so concurrent retries aren't both captured
+	mu sync.Mutex
+}
+
+// Option configures the server
+type Option func(s *Server)
+
+// WithSenderName sets the display name of a sender address, used in the From header of downloaded
+// emails. ACS doesn't take a display name, it shows the one configured for the address on the domain
+func WithSenderName(address, name string) Option {
+	return func(s *Server) {
+		s.senderNames[strings.ToLower(address)] = name
+	}
+}
+
+// New creates a server which captures messages into the store
+func New(store Store, opts ...Option) *Server {
+	s := &Server{store: store, mux: http.NewServeMux(), senderNames: map[string]string{}}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// The ACS API, as used by the client
+	s.mux.HandleFunc("/emails:send", s.sendEmail)
+	s.mux.HandleFunc("/emails/", s.emailStatus)
+	s.mux.HandleFunc("/sms", s.sendSMS)
+
+	// JSON API
+	s.mux.HandleFunc("/api/messages", s.apiMessages)
+	s.mux.HandleFunc("/api/messages/", s.apiMessage)
+
+	// Web UI
+	s.mux.HandleFunc("/", s.uiIndex)
+	s.mux.HandleFunc("/messages/", s.uiEmail)
+	s.mux.HandleFunc("/texts", s.uiTexts)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ==== ACS API ====
+
+func (s *Server) sendEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	e := &client.Email{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(e); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+
+		return
+	}
+
+	recipients := len(e.Recipients.To) + len(e.Recipients.CC) + len(e.Recipients.BCC)
+	if e.Sender == "" || recipients == 0 || e.Content.Subject == "" {
+		apiError(w, http.StatusBadRequest, "sender, recipients and subject are required")
+
+		return
+	}
+
+	m := &Message{Kind: KindEmail, Received: time.Now(), Email: e}
+
+	repeated, err := s.capture(r.Context(), r.Header.Get("repeatability-request-id"), m)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+
+		return
+	}
+
+	w.Header().Set("x-ms-request-id", m.ID)
+	w.Header().Set("repeatability-result", repeatabilityResult(repeated))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) emailStatus(w http.ResponseWriter, r *http.Request) {
+	id, found := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/emails/"), "/status")
+	if !found || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if _, err := s.store.Get(r.Context(), id); err != nil {
+		apiError(w, http.StatusNotFound, "message not found")
+
+		return
+	}
+
+	writeJSON(w, client.SendStatusResult{MessageID: id, Status: emailStatus})
+}
+
+func (s *Server) sendSMS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	req := &client.SMS{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(req); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+
+		return
+	}
+
+	if req.From == "" || req.Message == "" || len(req.SMSRecipients) == 0 {
+		apiError(w, http.StatusBadRequest, "from, message and recipients are required")
+
+		return
+	}
+
+	resp := client.SMSSendResponse{}
+
+	for _, rcpt := range req.SMSRecipients {
+		m := &Message{Kind: KindSMS, Received: time.Now(), SMS: &SMS{
+			From:           req.From,
+			To:             rcpt.To,
+			Message:        req.Message,
+			DeliveryReport: req.SMSSendOptions.EnableDeliveryReport,
+			Tag:            req.SMSSendOptions.Tag,
+		}}
+
+		repeated, err := s.capture(r.Context(), rcpt.RepeatabilityRequestID, m)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+
+			return
+		}
+
+		resp.Value = append(resp.Value, client.SMSSendResponseItem{
+			To:                  rcpt.To,
+			MessageID:           m.ID,
+			HTTPStatusCode:      http.StatusAccepted,
+			RepeatabilityResult: client.RepeatabilityResult(repeatabilityResult(repeated)),
+			Successful:          true,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// capture stores the message, unless its repeatability request ID has been seen before. The message
+// ID is derived from the request ID, so repeats are found in the store, even after a restart
+func (s *Server) capture(ctx context.Context, requestID string, m *Message) (bool, error) {
+	if requestID == "" {
+		m.ID = uuid.New().String()
+
+		return false, s.store.Add(ctx, m)
+	}
+
+	m.ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte("repeatability-request-id:"+requestID)).String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.store.Get(ctx, m.ID)
+	if err == nil {
+		return true, nil
+	}
+
+	if !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+
+	return false, s.store.Add(ctx, m)
+}
+
+func repeatabilityResult(repeated bool) string {
+	if repeated {
+		return string(client.RepeatabilityRejected)
+	}
+
+	return string(client.RepeatabilityAccepted)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(client.ErrorResponse{
+		Error: client.CommunicationError{Code: http.StatusText(status), Message: message},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// ==== JSON API ====
+
+// apiMessages lists messages, optionally filtered with ?kind=email|sms, or deletes them all
+func (s *Server) apiMessages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := s.list(r.Context(), Kind(r.URL.Query().Get("kind")))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(w, list)
+	case http.MethodDelete:
+		if err := s.store.Clear(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// apiMessage serves /api/messages/{id}, and the /html, /mime and /attachments/{n} of emails
+func (s *Server) apiMessage(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/messages/"), "/")
+
+	m, err := s.store.Get(r.Context(), parts[0])
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if len(parts) == 1 {
+		writeJSON(w, m)
+
+		return
+	}
+
+	if m.Email == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	switch {
+	case parts[1] == "html":
+		// Sandboxed, so scripts in the email can't run
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(w, m.Email.Content.HTML)
+	case parts[1] == "mime":
+		e := *m.Email
+		if name, found := s.senderNames[strings.ToLower(e.Sender)]; found {
+			e.SenderName = name
+		}
+
+		buf := &bytes.Buffer{}
+		if err := e.WriteMIME(buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": m.ID + ".eml"}))
+		_, _ = buf.WriteTo(w)
+	case parts[1] == "attachments" && len(parts) == 3:
+		s.attachment(w, r, m.Email, parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) attachment(w http.ResponseWriter, r *http.Request, e *client.Email, index string) {
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(e.Attachments) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	a := e.Attachments[i]
+
+	data, err := base64.StdEncoding.DecodeString(a.Content)
+	if err != nil {
+		http.Error(w, "invalid attachment content", http.StatusUnprocessableEntity)
+
+		return
+	}
+
+	contentType := mime.TypeByExtension("." + a.AttachmentType)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	_, _ = w.Write(data)
+}
+
+func (s *Server) list(ctx context.Context, kind Kind) ([]*Message, error) {
+	all, err := s.store.List(ctx)
+	if err != nil || kind == "" {
+		return all, err
+	}
+
+	list := []*Message{}
+
+	for _, m := range all {
+		if m.Kind == kind {
+			list = append(list, m)
+		}
+	}
+
+	return list, nil
+}
+
+// ==== Web UI ====
+
+type thread struct {
+	From     string
+	To       string
+	Messages []*Message
+}
+
+func (s *Server) uiIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	emails, err := s.list(r.Context(), KindEmail)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	render(w, "index", emails)
+}
+
+func (s *Server) uiEmail(w http.ResponseWriter, r *http.Request) {
+	m, err := s.store.Get(r.Context(), strings.TrimPrefix(r.URL.Path, "/messages/"))
+	if err != nil || m.Email == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	render(w, "email", m)
+}
+
+// uiTexts shows SMS as conversations between each pair of numbers
+func (s *Server) uiTexts(w http.ResponseWriter, r *http.Request) {
+	texts, err := s.list(r.Context(), KindSMS)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	threads := []*thread{}
+	byNumbers := map[string]*thread{}
+
+	// Oldest first within a thread, like a phone
+	for i := len(texts) - 1; i >= 0; i-- {
+		m := texts[i]
+		key := m.SMS.From + " " + m.SMS.To
+
+		t, found := byNumbers[key]
+		if !found {
+			t = &thread{From: m.SMS.From, To: m.SMS.To}
+			byNumbers[key] = t
+			threads = append(threads, t)
+		}
+
+		t.Messages = append(t.Messages, m)
+	}
+
+	// Most recently active threads first
+	sort.SliceStable(threads, func(i, j int) bool {
+		return threads[i].Messages[len(threads[i].Messages)-1].Received.After(threads[j].Messages[len(threads[j].Messages)-1].Received)
+	})
+
+	render(w, "texts", threads)
+}
+
+func render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, fmt.Sprintf("error rendering %s: %s", name, err), http.StatusInternalServerError)
+	}
+}
+
+func formatAddresses(addresses []client.Address) string {
+	formatted := make([]string, 0, len(addresses))
+
+	for _, a := range addresses {
+		if a.DisplayName == "" || a.DisplayName == a.Email {
+			formatted = append(formatted, a.Email)
+		} else {
+			formatted = append(formatted, fmt.Sprintf("%s <%s>", a.DisplayName, a.Email))
+		}
+	}
+
+	return strings.Join(formatted, ", ")
+}
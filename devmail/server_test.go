@@ -0,0 +1,175 @@
+package devmail
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	return resp, string(body)
+}
+
+func TestCapture(t *testing.T) {
+	srv := httptest.NewServer(New(NewMemoryStore(), WithSenderName("donotreply@blah.net", "Blah")))
+	defer srv.Close()
+
+	// Any access key works, only the endpoint needs to change
+	acsClient := client.New(base64.StdEncoding.EncodeToString([]byte("dev")), srv.URL)
+
+	e := client.NewHTMLEmail("DoNotReply@blah.net", "alice@example.net", "Welcome", "<h1>Hello</h1><script>alert(1)</script>")
+	e.AddAttachmentRaw("notes.txt", []byte("some notes"), "txt")
+	e.SetIdempotencyKey("welcome-alice")
+
+	messageID, err := acsClient.SendEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retries with the same key are not captured twice
+	result, err := acsClient.SendEmailWithResult(context.Background(), e)
+	if err != nil || !result.RepeatabilityResult.AlreadyProcessed() || result.MessageID != messageID {
+		t.Errorf("expected the repeat to be rejected, got %+v %v", result, err)
+	}
+
+	status, err := acsClient.GetEmailStatus(messageID)
+	if err != nil || status != emailStatus {
+		t.Errorf("unexpected status %q, %v", status, err)
+	}
+
+	sms := client.NewSMS("+15550000000", "+15550000001", "Your code is 1234")
+	sms.SMSRecipients = append(sms.SMSRecipients, client.SMSRecipient{To: "+15550000002"})
+
+	if _, err := acsClient.SendSingleSMS(sms); err != nil {
+		t.Fatal(err)
+	}
+
+	_, body := get(t, srv.URL+"/api/messages?kind=sms")
+
+	texts := []*Message{}
+	if err := json.Unmarshal([]byte(body), &texts); err != nil || len(texts) != 2 {
+		t.Fatalf("expected a SMS for each recipient, got %s", body)
+	}
+
+	_, body = get(t, srv.URL+"/api/messages/"+messageID)
+
+	m := &Message{}
+	if err := json.Unmarshal([]byte(body), m); err != nil || m.Email == nil || m.Email.Content.Subject != "Welcome" {
+		t.Fatalf("unexpected message %s", body)
+	}
+
+	resp, body := get(t, srv.URL+"/api/messages/"+messageID+"/html")
+	if resp.Header.Get("Content-Security-Policy") != "sandbox" || !strings.Contains(body, "<h1>Hello</h1>") {
+		t.Error("HTML body should be served sandboxed")
+	}
+
+	// The display name is set on the server, as ACS does
+	_, body = get(t, srv.URL+"/api/messages/"+messageID+"/mime")
+	if !strings.Contains(body, `From: "Blah" <DoNotReply@blah.net>`) {
+		t.Errorf("expected the sender's display name in the MIME, got %q", body)
+	}
+
+	resp, body = get(t, srv.URL+"/api/messages/"+messageID+"/attachments/0")
+	if body != "some notes" || !strings.Contains(resp.Header.Get("Content-Disposition"), "notes.txt") {
+		t.Errorf("unexpected attachment %q", body)
+	}
+
+	for path, want := range map[string]string{"/": "Welcome", "/messages/" + messageID: "notes.txt", "/texts": "Your code is 1234"} {
+		resp, body = get(t, srv.URL+path)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, want) {
+			t.Errorf("expected %s to contain %q, got %d", path, want, resp.StatusCode)
+		}
+	}
+
+	// Invalid requests are rejected like ACS does
+	_, err = acsClient.SendEmail(client.NewPlainEmail("DoNotReply@blah.net", "alice@example.net", "", "No subject"))
+	if apiErr := (&client.APIError{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a bad request, got %v", err)
+	}
+}
+
+func TestRepeatAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	messageIDs := []string{}
+
+	// A retry which arrives after a restart is still a repeat, as the store is checked
+	for i := 0; i < 2; i++ {
+		store, err := NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv := httptest.NewServer(New(store))
+		acsClient := client.New(base64.StdEncoding.EncodeToString([]byte("dev")), srv.URL)
+
+		e := client.NewPlainEmail("DoNotReply@blah.net", "alice@example.net", "Welcome", "Hello")
+		e.SetIdempotencyKey("welcome-alice")
+
+		result, err := acsClient.SendEmailWithResult(context.Background(), e)
+		srv.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.RepeatabilityResult.AlreadyProcessed() != (i == 1) {
+			t.Errorf("send %d: unexpected repeatability result %q", i, result.RepeatabilityResult)
+		}
+
+		messageIDs = append(messageIDs, result.MessageID)
+	}
+
+	store, _ := NewFileStore(dir)
+	if list, _ := store.List(context.Background()); len(list) != 1 || messageIDs[0] != messageIDs[1] {
+		t.Errorf("expected one message with a stable ID, got %d and %v", len(list), messageIDs)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	_ = store.Add(ctx, &Message{ID: "old", Kind: KindSMS, Received: now.Add(-time.Minute), SMS: &SMS{Message: "first"}})
+	_ = store.Add(ctx, &Message{ID: "new", Kind: KindSMS, Received: now, SMS: &SMS{Message: "second"}})
+
+	list, err := store.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != "new" {
+		t.Fatalf("expected newest first, got %v %v", list, err)
+	}
+
+	if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := store.Clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, _ = store.List(ctx); len(list) != 0 {
+		t.Error("expected the store to be empty")
+	}
+}
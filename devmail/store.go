@@ -0,0 +1,216 @@
+package devmail
+
+// ==============================================================================
+// Storage for captured messages, an in memory store and a file based store
+// which keeps messages between restarts are provided
+// ==============================================================================
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+const fileMode = 0o600
+const dirMode = 0o750
+
+// ErrNotFound is returned by a Store when a message doesn't exist
+var ErrNotFound = errors.New("message not found")
+
+// Kind is the type of a captured message
+type Kind string
+
+const (
+	KindEmail Kind = "email"
+	KindSMS   Kind = "sms"
+)
+
+// Message is a captured email, or a SMS to a single recipient
+type Message struct {
+	ID       string        `json:"id"`
+	Kind     Kind          `json:"kind"`
+	Received time.Time     `json:"received"`
+	Email    *client.Email `json:"email,omitempty"`
+	SMS      *SMS          `json:"sms,omitempty"`
+}
+
+// SMS is a captured SMS
+type SMS struct {
+	From           string `json:"from"`
+	To             string `json:"to"`
+	Message        string `json:"message"`
+	DeliveryReport bool   `json:"deliveryReport"`
+	Tag            string `json:"tag,omitempty"`
+}
+
+// Store persists captured messages, implementations must be safe for concurrent use
+type Store interface {
+	// Add stores a new message
+	Add(ctx context.Context, m *Message) error
+
+	// Get returns the message with the given ID, or ErrNotFound
+	Get(ctx context.Context, id string) (*Message, error)
+
+	// List returns all messages, newest first
+	List(ctx context.Context) ([]*Message, error)
+
+	// Clear removes all messages
+	Clear(ctx context.Context) error
+}
+
+// MemoryStore keeps messages in memory, they are lost on restart
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// NewMemoryStore creates an empty in memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Add stores the message
+func (s *MemoryStore) Add(_ context.Context, m *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, m)
+
+	return nil
+}
+
+// Get returns the message with the given ID
+func (s *MemoryStore) Get(_ context.Context, id string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.messages {
+		if m.ID == id {
+			return m, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// List returns all messages, newest first
+func (s *MemoryStore) List(_ context.Context) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*Message, len(s.messages))
+	copy(list, s.messages)
+	sortNewestFirst(list)
+
+	return list, nil
+}
+
+// Clear removes all messages
+func (s *MemoryStore) Clear(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = nil
+
+	return nil
+}
+
+// FileStore keeps each message as a JSON file in a directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a store in the given directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("error creating store directory: %s", err)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Add writes the message to a file
+func (s *FileStore) Add(_ context.Context, m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.path(m.ID), data, fileMode)
+}
+
+// Get reads the message with the given ID
+func (s *FileStore) Get(_ context.Context, id string) (*Message, error) {
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{}
+
+	return m, json.Unmarshal(data, m)
+}
+
+// List reads all messages, newest first
+func (s *FileStore) List(ctx context.Context) ([]*Message, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*Message{}
+
+	for _, file := range files {
+		m, err := s.Get(ctx, strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", file, err)
+		}
+
+		list = append(list, m)
+	}
+
+	sortNewestFirst(list)
+
+	return list, nil
+}
+
+// Clear deletes all message files
+func (s *FileStore) Clear(_ context.Context) error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// path returns the file for a message, IDs are generated so are safe file names
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
+
+func sortNewestFirst(list []*Message) {
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Received.After(list[j].Received)
+	})
+}
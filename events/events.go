@@ -0,0 +1,308 @@
+package events
+
+// ==============================================================================
+// Receiver for ACS events delivered by an Event Grid webhook subscription
+// Completes the subscription validation handshake, and decodes email delivery,
+// email engagement, SMS delivery and SMS received events, in the Event Grid or
+// CloudEvents schema. The message IDs match those returned by the client when
+// sending, so sends can be correlated
+// ==============================================================================
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event types sent by ACS
+const (
+	TypeEmailDeliveryReport = "Microsoft.Communication.EmailDeliveryReportReceived"
+	TypeEmailEngagement     = "Microsoft.Communication.EmailEngagementTrackingReportReceived"
+	TypeSMSDeliveryReport   = "Microsoft.Communication.SMSDeliveryReportReceived"
+	TypeSMSReceived         = "Microsoft.Communication.SMSReceived"
+
+	typeSubscriptionValidation = "Microsoft.EventGrid.SubscriptionValidationEvent"
+)
+
+// Event Grid batches are limited to 1MB
+const maxBodySize = 1 << 20
+
+// ShortNames are shorter names for the event types, accepted by ParseType
+var ShortNames = map[string]string{
+	"email.delivery":   TypeEmailDeliveryReport,
+	"email.engagement": TypeEmailEngagement,
+	"sms.delivery":     TypeSMSDeliveryReport,
+	"sms.received":     TypeSMSReceived,
+}
+
+// Event is an event in the Event Grid schema, CloudEvents are converted to it
+type Event struct {
+	ID          string          `json:"id"`
+	Topic       string          `json:"topic"`
+	Subject     string          `json:"subject"`
+	EventType   string          `json:"eventType"`
+	EventTime   time.Time       `json:"eventTime"`
+	Data        json.RawMessage `json:"data"`
+	DataVersion string          `json:"dataVersion"`
+}
+
+// cloudEvent is an event in the CloudEvents 1.0 schema
+type cloudEvent struct {
+	SpecVersion string          `json:"specversion"`
+	ID          string          `json:"id"`
+	Source      string          `json:"source"`
+	Subject     string          `json:"subject"`
+	Type        string          `json:"type"`
+	Time        time.Time       `json:"time"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// EmailDeliveryReport is the data of a TypeEmailDeliveryReport event
+type EmailDeliveryReport struct {
+	Sender                string `json:"sender"`
+	Recipient             string `json:"recipient"`
+	MessageID             string `json:"messageId"`
+	Status                string `json:"status"`
+	DeliveryStatusDetails struct {
+		StatusMessage string `json:"statusMessage"`
+	} `json:"deliveryStatusDetails"`
+	DeliveryAttemptTimestamp time.Time `json:"deliveryAttemptTimestamp"`
+}
+
+// EmailEngagement is the data of a TypeEmailEngagement event
+type EmailEngagement struct {
+	Sender              string    `json:"sender"`
+	Recipient           string    `json:"recipient"`
+	MessageID           string    `json:"messageId"`
+	EngagementType      string    `json:"engagementType"` // view or click
+	EngagementContext   string    `json:"engagementContext"`
+	UserAgent           string    `json:"userAgent"`
+	UserActionTimestamp time.Time `json:"userActionTimestamp"`
+}
+
+// SMSDeliveryReport is the data of a TypeSMSDeliveryReport event
+type SMSDeliveryReport struct {
+	MessageID             string `json:"messageId"`
+	From                  string `json:"from"`
+	To                    string `json:"to"`
+	DeliveryStatus        string `json:"deliveryStatus"`
+	DeliveryStatusDetails string `json:"deliveryStatusDetails"`
+	DeliveryAttempts      []struct {
+		Timestamp         time.Time `json:"timestamp"`
+		SegmentsSucceeded int       `json:"segmentsSucceeded"`
+		SegmentsFailed    int       `json:"segmentsFailed"`
+	} `json:"deliveryAttempts"`
+	ReceivedTimestamp time.Time `json:"receivedTimestamp"`
+	Tag               string    `json:"tag"`
+}
+
+// SMSReceived is the data of a TypeSMSReceived event
+type SMSReceived struct {
+	MessageID         string    `json:"messageId"`
+	From              string    `json:"from"`
+	To                string    `json:"to"`
+	Message           string    `json:"message"`
+	ReceivedTimestamp time.Time `json:"receivedTimestamp"`
+}
+
+// Decode returns the event data as one of the types above, or the raw JSON
+// for other event types
+func (e *Event) Decode() (any, error) {
+	var data any
+
+	switch e.EventType {
+	case TypeEmailDeliveryReport:
+		data = &EmailDeliveryReport{}
+	case TypeEmailEngagement:
+		data = &EmailEngagement{}
+	case TypeSMSDeliveryReport:
+		data = &SMSDeliveryReport{}
+	case TypeSMSReceived:
+		data = &SMSReceived{}
+	default:
+		return e.Data, nil
+	}
+
+	if err := json.Unmarshal(e.Data, data); err != nil {
+		return nil, fmt.Errorf("error decoding %s event: %s", e.EventType, err)
+	}
+
+	return data, nil
+}
+
+// MessageID returns the message ID from the event data, if it has one
+func (e *Event) MessageID() string {
+	data := struct {
+		MessageID string `json:"messageId"`
+	}{}
+
+	_ = json.Unmarshal(e.Data, &data)
+
+	return data.MessageID
+}
+
+// ParseType accepts a full event type or one of the ShortNames
+func ParseType(name string) (string, error) {
+	if eventType, ok := ShortNames[strings.ToLower(name)]; ok {
+		return eventType, nil
+	}
+
+	for _, eventType := range ShortNames {
+		if strings.EqualFold(name, eventType) {
+			return eventType, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown event type %q", name)
+}
+
+// Filter selects events, empty fields match everything
+type Filter struct {
+	Types      []string // Full event types
+	MessageIDs []string
+}
+
+// Match reports whether the event passes the filter
+func (f Filter) Match(e *Event) bool {
+	return matchAny(f.Types, e.EventType) && matchAny(f.MessageIDs, e.MessageID())
+}
+
+func matchAny(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handler returns a webhook handler for an Event Grid subscription, which calls
+// fn for each event that matches the filter. Subscriptions can use the Event Grid
+// or CloudEvents schema, CloudEvents are passed on as an Event
+func Handler(filter Filter, fn func(e *Event)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CloudEvents schema subscriptions validate with an OPTIONS request
+		if r.Method == http.MethodOptions {
+			if origin := r.Header.Get("WebHook-Request-Origin"); origin != "" {
+				w.Header().Set("WebHook-Allowed-Origin", origin)
+			}
+
+			w.WriteHeader(http.StatusOK)
+
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, "error reading body", http.StatusBadRequest)
+
+			return
+		}
+
+		events, err := parseEvents(body)
+		if err != nil {
+			http.Error(w, "body must be Event Grid or CloudEvents events", http.StatusBadRequest)
+
+			return
+		}
+
+		for _, e := range events {
+			if e.EventType == typeSubscriptionValidation {
+				validate(w, e)
+
+				return
+			}
+		}
+
+		for _, e := range events {
+			if filter.Match(e) {
+				fn(e)
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// parseEvents reads an array of events, or a single CloudEvent. Each event can be in
+// the Event Grid or CloudEvents schema, CloudEvents have a specversion
+func parseEvents(body []byte) ([]*Event, error) {
+	raw := []json.RawMessage{}
+
+	body = bytes.TrimSpace(body)
+	single := len(body) > 0 && body[0] == '{'
+
+	if single {
+		raw = append(raw, body)
+	} else if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, 0, len(raw))
+
+	for _, data := range raw {
+		ce := &cloudEvent{}
+		if err := json.Unmarshal(data, ce); err != nil {
+			return nil, err
+		}
+
+		if ce.SpecVersion == "" && single {
+			return nil, errors.New("a single event must be a CloudEvent")
+		}
+
+		if ce.SpecVersion == "" {
+			e := &Event{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return nil, err
+			}
+
+			events = append(events, e)
+
+			continue
+		}
+
+		events = append(events, &Event{
+			ID:        ce.ID,
+			Topic:     ce.Source,
+			Subject:   ce.Subject,
+			EventType: ce.Type,
+			EventTime: ce.Time,
+			Data:      ce.Data,
+		})
+	}
+
+	return events, nil
+}
+
+// validate completes the Event Grid subscription validation handshake
+func validate(w http.ResponseWriter, e *Event) {
+	data := struct {
+		ValidationCode string `json:"validationCode"`
+	}{}
+
+	if err := json.Unmarshal(e.Data, &data); err != nil || data.ValidationCode == "" {
+		http.Error(w, "missing validation code", http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"validationResponse": data.ValidationCode})
+}
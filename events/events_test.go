@@ -0,0 +1,152 @@
+package events
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testEvents = `[
+  {
+    "id": "1",
+    "eventType": "Microsoft.Communication.EmailDeliveryReportReceived",
+    "eventTime": "2026-01-01T10:00:00Z",
+    "data": {"sender": "DoNotReply@blah.net", "recipient": "a@example.net", "messageId": "msg-1", "status": "Delivered"}
+  },
+  {
+    "id": "2",
+    "eventType": "Microsoft.Communication.SMSDeliveryReportReceived",
+    "eventTime": "2026-01-01T10:00:01Z",
+    "data": {"messageId": "msg-2", "from": "+15550000000", "to": "+15550000001", "deliveryStatus": "Delivered"}
+  },
+  {
+    "id": "3",
+    "eventType": "Microsoft.Communication.EmailEngagementTrackingReportReceived",
+    "eventTime": "2026-01-01T10:00:02Z",
+    "data": {"messageId": "MSG-1", "engagementType": "view"}
+  }
+]`
+
+func post(h http.Handler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	return w
+}
+
+func TestHandlerValidation(t *testing.T) {
+	h := Handler(Filter{}, func(e *Event) {
+		t.Error("validation events should not be passed on")
+	})
+
+	w := post(h, `[{"id": "v", "eventType": "Microsoft.EventGrid.SubscriptionValidationEvent",
+		"data": {"validationCode": "code-123"}}]`)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"validationResponse":"code-123"`) {
+		t.Errorf("unexpected validation response %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("WebHook-Request-Origin", "eventgrid.azure.net")
+	h.ServeHTTP(w, req)
+
+	if w.Header().Get("WebHook-Allowed-Origin") != "eventgrid.azure.net" {
+		t.Error("CloudEvents handshake not completed")
+	}
+}
+
+func TestHandlerFilter(t *testing.T) {
+	received := []*Event{}
+	h := Handler(Filter{MessageIDs: []string{"msg-1"}}, func(e *Event) {
+		received = append(received, e)
+	})
+
+	if w := post(h, testEvents); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 events for msg-1, got %d", len(received))
+	}
+
+	data, err := received[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, ok := data.(*EmailDeliveryReport)
+	if !ok || report.Status != "Delivered" || report.Recipient != "a@example.net" {
+		t.Errorf("unexpected delivery report %+v", data)
+	}
+
+	received = received[:0]
+	h = Handler(Filter{Types: []string{TypeSMSDeliveryReport}}, func(e *Event) {
+		received = append(received, e)
+	})
+	post(h, testEvents)
+
+	if len(received) != 1 || received[0].MessageID() != "msg-2" {
+		t.Errorf("expected only the SMS delivery report, got %d events", len(received))
+	}
+
+	if w := post(h, `{"not": "an array"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request, got %d", w.Code)
+	}
+}
+
+func TestHandlerCloudEvents(t *testing.T) {
+	received := []*Event{}
+	h := Handler(Filter{MessageIDs: []string{"msg-1"}}, func(e *Event) {
+		received = append(received, e)
+	})
+
+	cloudEvent := `{
+    "specversion": "1.0",
+    "id": "1",
+    "source": "/subscriptions/x/resourceGroups/y/providers/Microsoft.Communication/CommunicationServices/acs",
+    "subject": "sender/DoNotReply@blah.net/message/msg-1",
+    "type": "Microsoft.Communication.EmailDeliveryReportReceived",
+    "time": "2026-01-01T10:00:00Z",
+    "data": {"sender": "DoNotReply@blah.net", "recipient": "a@example.net", "messageId": "msg-1", "status": "Delivered"}
+  }`
+
+	// A single event, and a batch
+	for _, body := range []string{cloudEvent, "[" + cloudEvent + "]"} {
+		if w := post(h, body); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(received))
+	}
+
+	e := received[0]
+	if e.EventType != TypeEmailDeliveryReport || !strings.HasSuffix(e.Topic, "/acs") || e.EventTime.IsZero() {
+		t.Errorf("CloudEvent not converted, got %+v", e)
+	}
+
+	data, err := e.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report, ok := data.(*EmailDeliveryReport); !ok || report.Status != "Delivered" {
+		t.Errorf("unexpected delivery report %+v", data)
+	}
+}
+
+func TestParseType(t *testing.T) {
+	for _, name := range []string{"sms.received", "SMS.Received", TypeSMSReceived} {
+		eventType, err := ParseType(name)
+		if err != nil || eventType != TypeSMSReceived {
+			t.Errorf("ParseType(%q) = %q, %v", name, eventType, err)
+		}
+	}
+
+	if _, err := ParseType("email.bounced"); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
@@ -0,0 +1,213 @@
+package gateway
+
+// ==============================================================================
+// Callers of the gateway, authenticated with an API key or HMAC signature,
+// each with their own quotas and the senders they're allowed to use
+// ==============================================================================
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/auth"
+)
+
+// APIKeyHeader is the header callers send their API key in
+const APIKeyHeader = "X-API-Key"
+
+// Config is the gateway config, usually loaded from a JSON file with LoadConfig
+type Config struct {
+	DefaultFrom    string   `json:"defaultFrom"`    // Email sender used when a request doesn't set one
+	DefaultSMSFrom string   `json:"defaultSmsFrom"` // Number SMS are sent from when a request doesn't set one
+	Callers        []Caller `json:"callers"`
+}
+
+// Caller is a system allowed to send through the gateway
+type Caller struct {
+	Name string `json:"name"`
+
+	// Callers authenticate with either, or both
+	APIKey     string `json:"apiKey"`     // Sent in the X-API-Key header
+	HMACSecret string `json:"hmacSecret"` // Base64 secret, requests are signed with auth.SignRequestHMAC
+
+	// Senders the caller can use besides the defaults, email addresses or @domains, and phone numbers
+	AllowedSenders []string `json:"allowedSenders"`
+
+	// Recipients the caller can send to in each quota period, zero is unlimited
+	EmailQuota int `json:"emailQuota"`
+	SMSQuota   int `json:"smsQuota"`
+}
+
+// usage counts a caller's recipients in the current quota period
+type usage struct {
+	start  time.Time
+	emails int
+	sms    int
+}
+
+// quotas tracks usage for all callers
+type quotas struct {
+	period time.Duration
+	mu     sync.Mutex
+	usage  map[string]*usage
+}
+
+// signatures remembers the HMAC signatures which have been used, so signed requests can't be replayed
+type signatures struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // When each can be forgotten
+}
+
+// LoadConfig reads a JSON config file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %s", err)
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %s", path, err)
+	}
+
+	return cfg, nil
+}
+
+// check validates the callers
+func (cfg *Config) check() error {
+	if len(cfg.Callers) == 0 {
+		return errors.New("config has no callers")
+	}
+
+	names := map[string]bool{}
+
+	for _, c := range cfg.Callers {
+		if c.Name == "" || names[c.Name] {
+			return fmt.Errorf("callers must have a unique name, %q is not", c.Name)
+		}
+
+		if c.APIKey == "" && c.HMACSecret == "" {
+			return fmt.Errorf("caller %s needs an API key or HMAC secret", c.Name)
+		}
+
+		names[c.Name] = true
+	}
+
+	return nil
+}
+
+// authenticate finds the caller making the request, each signed request can only be used once
+func (cfg *Config) authenticate(r *http.Request, maxSkew time.Duration, used *signatures) (*Caller, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		for i, c := range cfg.Callers {
+			if c.APIKey != "" && subtle.ConstantTimeCompare([]byte(c.APIKey), []byte(key)) == 1 {
+				return &cfg.Callers[i], nil
+			}
+		}
+
+		return nil, errors.New("invalid API key")
+	}
+
+	if strings.HasPrefix(r.Header.Get("Authorization"), "HMAC-SHA256 ") {
+		// Signatures don't name the caller, so try each secret
+		for i, c := range cfg.Callers {
+			if c.HMACSecret == "" || auth.VerifyRequestHMAC(c.HMACSecret, r, maxSkew) != nil {
+				continue
+			}
+
+			_, signature, _ := strings.Cut(r.Header.Get("Authorization"), "Signature=")
+			if !used.first(signature, maxSkew) {
+				return nil, errors.New("signed request has already been used")
+			}
+
+			return &cfg.Callers[i], nil
+		}
+
+		return nil, errors.New("invalid HMAC signature")
+	}
+
+	return nil, fmt.Errorf("send an %s header, or sign the request with HMAC-SHA256", APIKeyHeader)
+}
+
+// first records a signature, returning false if it's been used before. The date signed can be
+// up to maxSkew either side of now, so signatures are kept until it's too old to verify anyway
+func (s *signatures) first(signature string, maxSkew time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	for sig, expires := range s.seen {
+		if now.After(expires) {
+			delete(s.seen, sig)
+		}
+	}
+
+	if _, found := s.seen[signature]; found {
+		return false
+	}
+
+	s.seen[signature] = now.Add(2 * maxSkew)
+
+	return true
+}
+
+// sender returns the sender to use, checking it's allowed for the caller
+func (c *Caller) sender(requested, fallback string) (string, error) {
+	if requested == "" || strings.EqualFold(requested, fallback) {
+		if fallback == "" {
+			return "", errors.New("from is required, there's no default sender")
+		}
+
+		return fallback, nil
+	}
+
+	for _, allowed := range c.AllowedSenders {
+		if strings.EqualFold(requested, allowed) ||
+			(strings.HasPrefix(allowed, "@") && strings.HasSuffix(strings.ToLower(requested), strings.ToLower(allowed))) {
+			return requested, nil
+		}
+	}
+
+	return "", fmt.Errorf("caller %s is not allowed to send from %s", c.Name, requested)
+}
+
+// take uses n email or SMS recipients from the caller's quota, returning false if
+// that would go over it
+func (q *quotas) take(c *Caller, emails, sms int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.usage[c.Name]
+	if u == nil || time.Since(u.start) >= q.period {
+		u = &usage{start: time.Now()}
+		q.usage[c.Name] = u
+	}
+
+	if (c.EmailQuota > 0 && u.emails+emails > c.EmailQuota) || (c.SMSQuota > 0 && u.sms+sms > c.SMSQuota) {
+		return false
+	}
+
+	u.emails += emails
+	u.sms += sms
+
+	return true
+}
+
+// refund gives back quota for messages which couldn't be queued
+func (q *quotas) refund(c *Caller, emails, sms int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if u := q.usage[c.Name]; u != nil {
+		u.emails -= emails
+		u.sms -= sms
+	}
+}
@@ -0,0 +1,342 @@
+package gateway
+
+// ==============================================================================
+// Webhook to notification gateway, lets systems which can fire a webhook but
+// can't talk to ACS send email and SMS. Callers are authenticated, held to
+// quotas and sender allowlists, and messages are queued in an outbox. The IDs
+// returned can be polled for the status of the message
+// ==============================================================================
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+	"github.com/benc-uk/go-acs-client/outbox"
+)
+
+const maxBodySize = 1 << 20
+const defaultQuotaPeriod = 24 * time.Hour
+const defaultMaxClockSkew = 5 * time.Minute
+
+// Options for the gateway, zero values use the defaults
+type Options struct {
+	QuotaPeriod  time.Duration // Period caller quotas apply to, defaults to 24 hours
+	MaxClockSkew time.Duration // How old HMAC signatures can be, defaults to 5 minutes
+
+	// OnRequest is called for each notify request, err is set if it was rejected, optional
+	OnRequest func(caller, kind string, ids []string, err error)
+}
+
+// EmailRequest is the body of POST /notify/email
+type EmailRequest struct {
+	From       string            `json:"from"` // Optional, the default sender is used when empty
+	To         []string          `json:"to"`
+	CC         []string          `json:"cc"`
+	BCC        []string          `json:"bcc"`
+	ReplyTo    string            `json:"replyTo"`
+	Subject    string            `json:"subject"`
+	Text       string            `json:"text"`
+	HTML       string            `json:"html"`
+	Importance string            `json:"importance"`
+	Headers    map[string]string `json:"headers"`
+}
+
+// SMSRequest is the body of POST /notify/sms, a SMS is queued for each number in To
+type SMSRequest struct {
+	From           string   `json:"from"` // Optional, the default number is used when empty
+	To             []string `json:"to"`
+	Message        string   `json:"message"`
+	DeliveryReport bool     `json:"deliveryReport"`
+	Tag            string   `json:"tag"`
+}
+
+// Accepted is the response to a notify request, with the IDs to poll the status of
+type Accepted struct {
+	ID  string   `json:"id,omitempty"`  // Email
+	IDs []string `json:"ids,omitempty"` // SMS, in the same order as the numbers
+}
+
+// Status is the response from GET /notify/status/{id}
+type Status struct {
+	ID        string       `json:"id"`
+	Kind      outbox.Kind  `json:"kind"`
+	State     outbox.State `json:"state"`
+	Attempts  int          `json:"attempts"`
+	MessageID string       `json:"messageId,omitempty"` // ACS message ID, once sent
+	Error     string       `json:"error,omitempty"`
+
+	// DeliveryStatus is the ACS email status, e.g. OutForDelivery, once sent
+	DeliveryStatus string `json:"deliveryStatus,omitempty"`
+}
+
+// Gateway is a http.Handler serving the notify API
+type Gateway struct {
+	client *client.Client
+	outbox *outbox.Outbox
+	config *Config
+	opts   Options
+	quotas *quotas
+	used   *signatures
+	mux    *http.ServeMux
+}
+
+// New creates a gateway, which queues messages in the outbox. The outbox must be
+// started for messages to be sent
+func New(acsClient *client.Client, box *outbox.Outbox, config *Config, opts Options) (*Gateway, error) {
+	if err := config.check(); err != nil {
+		return nil, err
+	}
+
+	if opts.QuotaPeriod <= 0 {
+		opts.QuotaPeriod = defaultQuotaPeriod
+	}
+
+	if opts.MaxClockSkew <= 0 {
+		opts.MaxClockSkew = defaultMaxClockSkew
+	}
+
+	g := &Gateway{
+		client: acsClient,
+		outbox: box,
+		config: config,
+		opts:   opts,
+		quotas: &quotas{period: opts.QuotaPeriod, usage: map[string]*usage{}},
+		used:   &signatures{seen: map[string]time.Time{}},
+		mux:    http.NewServeMux(),
+	}
+
+	g.mux.HandleFunc("/notify/email", g.authenticated(http.MethodPost, g.notifyEmail))
+	g.mux.HandleFunc("/notify/sms", g.authenticated(http.MethodPost, g.notifySMS))
+	g.mux.HandleFunc("/notify/status/", g.authenticated(http.MethodGet, g.status))
+
+	return g, nil
+}
+
+// ServeHTTP serves the notify API
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// authenticated checks the method and caller before calling the handler
+func (g *Gateway) authenticated(method string, fn func(http.ResponseWriter, *http.Request, *Caller)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+
+			return
+		}
+
+		// Limit the body before it's read to check a signature
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		caller, err := g.config.authenticate(r, g.opts.MaxClockSkew, g.used)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+
+			return
+		}
+
+		fn(w, r, caller)
+	}
+}
+
+func (g *Gateway) notifyEmail(w http.ResponseWriter, r *http.Request, caller *Caller) {
+	req := &EmailRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		g.reject(w, caller, outbox.KindEmail, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+
+		return
+	}
+
+	from, err := caller.sender(req.From, g.config.DefaultFrom)
+	if err != nil {
+		g.reject(w, caller, outbox.KindEmail, http.StatusForbidden, err)
+
+		return
+	}
+
+	e, err := newEmail(from, req)
+	if err != nil {
+		g.reject(w, caller, outbox.KindEmail, http.StatusBadRequest, err)
+
+		return
+	}
+
+	recipients := len(req.To) + len(req.CC) + len(req.BCC)
+	if !g.quotas.take(caller, recipients, 0) {
+		g.reject(w, caller, outbox.KindEmail, http.StatusTooManyRequests, fmt.Errorf("caller %s is over its email quota", caller.Name))
+
+		return
+	}
+
+	id, err := g.outbox.EnqueueEmail(r.Context(), e, outbox.WithOwner(caller.Name))
+	if err != nil {
+		g.quotas.refund(caller, recipients, 0)
+		g.reject(w, caller, outbox.KindEmail, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	g.report(caller, outbox.KindEmail, []string{id}, nil)
+	writeJSON(w, http.StatusAccepted, Accepted{ID: id})
+}
+
+func (g *Gateway) notifySMS(w http.ResponseWriter, r *http.Request, caller *Caller) {
+	req := &SMSRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		g.reject(w, caller, outbox.KindSMS, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+
+		return
+	}
+
+	from, err := caller.sender(req.From, g.config.DefaultSMSFrom)
+	if err != nil {
+		g.reject(w, caller, outbox.KindSMS, http.StatusForbidden, err)
+
+		return
+	}
+
+	if len(req.To) == 0 || req.Message == "" {
+		g.reject(w, caller, outbox.KindSMS, http.StatusBadRequest, errors.New("to and message are required"))
+
+		return
+	}
+
+	if !g.quotas.take(caller, 0, len(req.To)) {
+		g.reject(w, caller, outbox.KindSMS, http.StatusTooManyRequests, fmt.Errorf("caller %s is over its SMS quota", caller.Name))
+
+		return
+	}
+
+	ids := []string{}
+
+	for i, to := range req.To {
+		s := client.NewSMS(from, to, req.Message)
+		s.SMSSendOptions = client.SMSOptions{EnableDeliveryReport: req.DeliveryReport, Tag: req.Tag}
+
+		id, err := g.outbox.EnqueueSMS(r.Context(), s, outbox.WithOwner(caller.Name))
+		if err != nil {
+			g.quotas.refund(caller, 0, len(req.To)-i)
+			g.reject(w, caller, outbox.KindSMS, http.StatusInternalServerError, err)
+
+			return
+		}
+
+		ids = append(ids, id)
+	}
+
+	g.report(caller, outbox.KindSMS, ids, nil)
+	writeJSON(w, http.StatusAccepted, Accepted{IDs: ids})
+}
+
+func (g *Gateway) status(w http.ResponseWriter, r *http.Request, caller *Caller) {
+	id := strings.TrimPrefix(r.URL.Path, "/notify/status/")
+
+	// Other callers' messages are not found, rather than forbidden, so IDs can't be probed
+	m, err := g.outbox.Get(r.Context(), id)
+	if err == nil && m.Owner != caller.Name {
+		err = outbox.ErrNotFound
+	}
+
+	if errors.Is(err, outbox.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err)
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	status := Status{
+		ID:        m.ID,
+		Kind:      m.Kind,
+		State:     m.State,
+		Attempts:  m.Attempts,
+		MessageID: m.MessageID,
+		Error:     m.LastError,
+	}
+
+	if m.Kind == outbox.KindEmail && m.State == outbox.StateSent {
+		status.DeliveryStatus, err = g.client.GetEmailStatusContext(r.Context(), m.MessageID)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, fmt.Errorf("error getting email status: %s", err))
+
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// newEmail builds the email for a request
+func newEmail(from string, req *EmailRequest) (*client.Email, error) {
+	if len(req.To) == 0 || req.Subject == "" || (req.Text == "" && req.HTML == "") {
+		return nil, errors.New("to, subject, and text or html are required")
+	}
+
+	e := client.NewPlainEmail(from, req.To[0], req.Subject, req.Text)
+	e.Content.HTML = req.HTML
+
+	switch req.Importance {
+	case "":
+	case client.ImportanceLow, client.ImportanceNormal, client.ImportanceHigh:
+		e.Importance = req.Importance
+	default:
+		return nil, fmt.Errorf("importance must be %s, %s or %s", client.ImportanceLow, client.ImportanceNormal, client.ImportanceHigh)
+	}
+
+	for _, to := range req.To[1:] {
+		e.Recipients.To = append(e.Recipients.To, client.Address{Email: to, DisplayName: to})
+	}
+
+	for _, cc := range req.CC {
+		e.AddCC(cc, cc)
+	}
+
+	for _, bcc := range req.BCC {
+		e.AddBCC(bcc, bcc)
+	}
+
+	if req.ReplyTo != "" {
+		e.AddReplyTo(req.ReplyTo, req.ReplyTo)
+	}
+
+	for name, value := range req.Headers {
+		if !client.ValidHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid header %q", name)
+		}
+
+		e.AddCustomHeader(name, value)
+	}
+
+	return e, nil
+}
+
+func (g *Gateway) reject(w http.ResponseWriter, caller *Caller, kind outbox.Kind, code int, err error) {
+	g.report(caller, kind, nil, err)
+	writeError(w, code, err)
+}
+
+func (g *Gateway) report(caller *Caller, kind outbox.Kind, ids []string, err error) {
+	if g.opts.OnRequest != nil {
+		g.opts.OnRequest(caller.Name, string(kind), ids, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
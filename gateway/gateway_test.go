@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/auth"
+	"github.com/benc-uk/go-acs-client/client"
+	"github.com/benc-uk/go-acs-client/devmail"
+	"github.com/benc-uk/go-acs-client/outbox"
+)
+
+var secret = base64.StdEncoding.EncodeToString([]byte("ci secret"))
+
+func newGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	acs := httptest.NewServer(devmail.New(devmail.NewMemoryStore()))
+	t.Cleanup(acs.Close)
+
+	acsClient := client.New(base64.StdEncoding.EncodeToString([]byte("dev")), acs.URL)
+
+	box := outbox.New(acsClient, outbox.NewMemoryStore(), outbox.Options{PollInterval: 10 * time.Millisecond})
+	box.Start(context.Background())
+	t.Cleanup(box.Stop)
+
+	g, err := New(acsClient, box, &Config{
+		DefaultFrom:    "DoNotReply@blah.net",
+		DefaultSMSFrom: "+15550000000",
+		Callers: []Caller{
+			{Name: "billing", APIKey: "billing-key", AllowedSenders: []string{"@billing.blah.net"}, EmailQuota: 2},
+			{Name: "ci", HMACSecret: secret, SMSQuota: 1},
+			{Name: "ops", APIKey: "ops-key"},
+		},
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func call(t *testing.T, req *http.Request, v any) int {
+	t.Helper()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if v != nil {
+		_ = json.Unmarshal(body, v)
+	}
+
+	return resp.StatusCode
+}
+
+func withKey(t *testing.T, method, url, key string, body any) *http.Request {
+	t.Helper()
+
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, url, bytes.NewReader(data))
+
+	if key != "" {
+		req.Header.Set(APIKeyHeader, key)
+	}
+
+	return req
+}
+
+func TestEmail(t *testing.T) {
+	srv := newGateway(t)
+	email := EmailRequest{From: "invoices@billing.blah.net", To: []string{"alice@example.net"}, Subject: "Invoice", Text: "Attached"}
+
+	accepted := Accepted{}
+	if code := call(t, withKey(t, http.MethodPost, srv.URL+"/notify/email", "billing-key", email), &accepted); code != http.StatusAccepted || accepted.ID == "" {
+		t.Fatalf("expected the email to be accepted, got %d", code)
+	}
+
+	// Poll until the outbox has sent it
+	status := Status{}
+	for i := 0; i < 100 && status.State != outbox.StateSent; i++ {
+		time.Sleep(10 * time.Millisecond)
+		call(t, withKey(t, http.MethodGet, srv.URL+"/notify/status/"+accepted.ID, "billing-key", nil), &status)
+	}
+
+	if status.State != outbox.StateSent || status.DeliveryStatus != "OutForDelivery" || status.MessageID == "" {
+		t.Errorf("expected the email to be sent, got %+v", status)
+	}
+
+	// Callers can only see their own messages
+	if code := call(t, withKey(t, http.MethodGet, srv.URL+"/notify/status/"+accepted.ID, "ops-key", nil), nil); code != http.StatusNotFound {
+		t.Errorf("expected another caller's message to be not found, got %d", code)
+	}
+
+	// In order, the quota of 2 is used by the first email and the one with the default sender
+	tests := []struct {
+		name  string
+		key   string
+		email EmailRequest
+		want  int
+	}{
+		{"no key", "", email, http.StatusUnauthorized},
+		{"wrong key", "nope", email, http.StatusUnauthorized},
+		{"wrong sender", "billing-key", EmailRequest{From: "ceo@blah.net", To: email.To, Subject: "Hi", Text: "Hi"}, http.StatusForbidden},
+		{"no subject", "billing-key", EmailRequest{To: email.To, Text: "Hi"}, http.StatusBadRequest},
+		{"bad importance", "billing-key", EmailRequest{To: email.To, Subject: "Hi", Text: "Hi", Importance: "urgent"}, http.StatusBadRequest},
+		{"bad header name", "billing-key", EmailRequest{To: email.To, Subject: "Hi", Text: "Hi", Headers: map[string]string{"X-A\r\nBcc": "eve@example.net"}}, http.StatusBadRequest},
+		{"bad header value", "billing-key", EmailRequest{To: email.To, Subject: "Hi", Text: "Hi", Headers: map[string]string{"X-A": "a\r\nBcc: eve@example.net"}}, http.StatusBadRequest},
+		{"default sender", "billing-key", EmailRequest{To: email.To, Subject: "Hi", Text: "Hi"}, http.StatusAccepted},
+		{"over quota", "billing-key", EmailRequest{To: email.To, Subject: "Hi", Text: "Hi"}, http.StatusTooManyRequests},
+	}
+
+	for _, tc := range tests {
+		if code := call(t, withKey(t, http.MethodPost, srv.URL+"/notify/email", tc.key, tc.email), nil); code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, code)
+		}
+	}
+
+	if code := call(t, withKey(t, http.MethodGet, srv.URL+"/notify/status/missing", "billing-key", nil), nil); code != http.StatusNotFound {
+		t.Errorf("expected not found, got %d", code)
+	}
+}
+
+func TestSMSWithHMAC(t *testing.T) {
+	srv := newGateway(t)
+
+	signed := func(body any) *http.Request {
+		req := withKey(t, http.MethodPost, srv.URL+"/notify/sms", "", body)
+		if err := auth.SignRequestHMAC(secret, req); err != nil {
+			t.Fatal(err)
+		}
+
+		return req
+	}
+
+	req := signed(SMSRequest{To: []string{"+15550000001"}, Message: "Build failed"})
+	replay := withKey(t, http.MethodPost, srv.URL+"/notify/sms", "", SMSRequest{To: []string{"+15550000001"}, Message: "Build failed"})
+	replay.Header = req.Header.Clone()
+
+	accepted := Accepted{}
+	if code := call(t, req, &accepted); code != http.StatusAccepted || len(accepted.IDs) != 1 {
+		t.Fatalf("expected the SMS to be accepted, got %d", code)
+	}
+
+	// Sending the same signed request again is rejected
+	if code := call(t, replay, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected a replayed request to be rejected, got %d", code)
+	}
+
+	// Changing the body after signing breaks the signature
+	req = signed(SMSRequest{To: []string{"+15550000001"}, Message: "Build failed"})
+	tampered := withKey(t, http.MethodPost, srv.URL+"/notify/sms", "", SMSRequest{To: []string{"+15559999999"}, Message: "Hi"})
+	tampered.Header = req.Header
+
+	if code := call(t, tampered, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected a tampered request to be rejected, got %d", code)
+	}
+
+	if code := call(t, signed(SMSRequest{To: []string{"+15550000001"}, Message: "Again"}), nil); code != http.StatusTooManyRequests {
+		t.Errorf("expected the SMS quota to be used, got %d", code)
+	}
+
+	if code := call(t, signed(SMSRequest{From: "+15551111111", To: []string{"+15550000001"}, Message: "Hi"}), nil); code != http.StatusForbidden {
+		t.Errorf("expected a sender not in the allowlist to be rejected, got %d", code)
+	}
+}
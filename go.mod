@@ -1,8 +1,23 @@
 module github.com/benc-uk/go-acs-client
 
-go 1.19
+go 1.21
 
 require (
-	github.com/google/uuid v1.3.0
+	github.com/andybalholm/cascadia v1.3.3
+	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.4.0
+	github.com/yuin/goldmark v1.7.8
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/metric v1.28.0
+	go.opentelemetry.io/otel/sdk v1.28.0
+	go.opentelemetry.io/otel/sdk/metric v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+	golang.org/x/net v0.35.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
 )
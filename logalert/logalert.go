@@ -0,0 +1,462 @@
+package logalert
+
+// ==============================================================================
+// slog handler which sends log records at or above a level as alerts by email
+// and/or SMS. Records are batched and deduplicated over a window, the number of
+// alerts is capped so a log storm can't send thousands of texts, and sending
+// happens in the background so logging never blocks
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+const defaultWindow = time.Minute
+const defaultMaxPerHour = 10
+const defaultQueueSize = 1000
+const rateWindow = time.Hour
+
+// Long SMS are split into segments by the carrier, keep alerts to a couple
+const maxSMSLength = 300
+
+// Options configures where and how alerts are sent
+type Options struct {
+	Client *client.Client // Required
+	Level  slog.Leveler   // Records at or above this level are sent, defaults to slog.LevelError
+
+	From string   // Email sender, required for email alerts
+	To   []string // Email recipients
+
+	SMSFrom string   // Number SMS alerts are sent from, required for SMS alerts
+	SMSTo   []string // Numbers SMS alerts are sent to
+
+	Subject    string        // Prefix for the email subject, defaults to the level
+	Window     time.Duration // Records are batched for this long, defaults to one minute
+	MaxPerHour int           // Maximum alerts sent in any hour, defaults to 10
+	QueueSize  int           // Records waiting to be batched, more are dropped, defaults to 1000
+
+	// OnError is called when an alert can't be sent
+	OnError func(err error)
+}
+
+// Handler wraps another slog handler, passing all records to it, and sending those
+// at or above the alert level as alerts
+type Handler struct {
+	next   slog.Handler
+	alerts *alerter
+	attrs  []slog.Attr
+	groups []string
+}
+
+// NewHandler creates a handler which alerts as configured, and passes records to next,
+// which can be nil if records should only be alerted. Call Close to send pending alerts
+func NewHandler(next slog.Handler, opts Options) (*Handler, error) {
+	if opts.Client == nil {
+		return nil, errors.New("a client is required")
+	}
+
+	if (opts.From == "" || len(opts.To) == 0) && (opts.SMSFrom == "" || len(opts.SMSTo) == 0) {
+		return nil, errors.New("email or SMS recipients are required")
+	}
+
+	if opts.Level == nil {
+		opts.Level = slog.LevelError
+	}
+
+	if opts.Window <= 0 {
+		opts.Window = defaultWindow
+	}
+
+	if opts.MaxPerHour <= 0 {
+		opts.MaxPerHour = defaultMaxPerHour
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+
+	a := &alerter{
+		opts:    opts,
+		records: make(chan record, opts.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go a.run()
+
+	return &Handler{next: next, alerts: a}, nil
+}
+
+// Enabled is true if the wrapped handler or the alerts want the level
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.alerts.opts.Level.Level() || (h.next != nil && h.next.Enabled(ctx, level))
+}
+
+// Handle passes the record on, and queues it as an alert without blocking
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= h.alerts.opts.Level.Level() {
+		h.alerts.enqueue(h.snapshot(r))
+	}
+
+	if h.next != nil && h.next.Enabled(ctx, r.Level) {
+		return h.next.Handle(ctx, r)
+	}
+
+	return nil
+}
+
+// WithAttrs returns a handler with the attributes added
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], h.prefixed(attrs)...)
+
+	if h.next != nil {
+		h2.next = h.next.WithAttrs(attrs)
+	}
+
+	return &h2
+}
+
+// WithGroup returns a handler with the group added
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := *h
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+
+	if h.next != nil {
+		h2.next = h.next.WithGroup(name)
+	}
+
+	return &h2
+}
+
+// Close sends any pending alerts and stops the handler, records logged after
+// Close are passed on but not alerted
+func (h *Handler) Close(ctx context.Context) error {
+	h.alerts.closeOnce.Do(func() { close(h.alerts.closing) })
+
+	select {
+	case <-h.alerts.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// prefixed qualifies attribute keys with the current groups
+func (h *Handler) prefixed(attrs []slog.Attr) []slog.Attr {
+	if len(h.groups) == 0 {
+		return attrs
+	}
+
+	prefix := strings.Join(h.groups, ".") + "."
+	out := make([]slog.Attr, 0, len(attrs))
+
+	for _, a := range attrs {
+		out = append(out, slog.Attr{Key: prefix + a.Key, Value: a.Value})
+	}
+
+	return out
+}
+
+// snapshot copies what's needed from the record, as records can't be kept after Handle
+func (h *Handler) snapshot(r slog.Record) record {
+	rec := record{level: r.Level, message: r.Message, time: r.Time}
+
+	for _, a := range h.attrs {
+		rec.attrs = appendAttr(rec.attrs, "", a)
+	}
+
+	prefix := ""
+	if len(h.groups) > 0 {
+		prefix = strings.Join(h.groups, ".") + "."
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		rec.attrs = appendAttr(rec.attrs, prefix, a)
+
+		return true
+	})
+
+	return rec
+}
+
+// appendAttr flattens groups into dotted keys
+func appendAttr(attrs [][2]string, prefix string, a slog.Attr) [][2]string {
+	a.Value = a.Value.Resolve()
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+
+		for _, ga := range a.Value.Group() {
+			attrs = appendAttr(attrs, prefix, ga)
+		}
+
+		return attrs
+	}
+
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+
+	return append(attrs, [2]string{prefix + a.Key, a.Value.String()})
+}
+
+// record is a log record waiting to be alerted
+type record struct {
+	level   slog.Level
+	message string
+	time    time.Time
+	attrs   [][2]string
+}
+
+// alert is a deduplicated record, with how often it was seen in the batch
+type alert struct {
+	record
+	count int
+	last  time.Time
+}
+
+// alerter batches records and sends them, it's shared by handlers made with WithAttrs
+type alerter struct {
+	opts      Options
+	records   chan record
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mu         sync.Mutex
+	dropped    int // Records dropped as the queue was full
+	suppressed int // Alerts not sent due to the rate cap
+	sent       []time.Time
+}
+
+func (a *alerter) enqueue(r record) {
+	select {
+	case a.records <- r:
+	default:
+		a.mu.Lock()
+		a.dropped++
+		a.mu.Unlock()
+	}
+}
+
+func (a *alerter) run() {
+	defer close(a.done)
+
+	batch := map[string]*alert{}
+	order := []string{}
+
+	var timer <-chan time.Time
+
+	add := func(r record) {
+		key := r.level.String() + " " + r.message
+
+		if existing, found := batch[key]; found {
+			existing.count++
+			existing.last = r.time
+
+			return
+		}
+
+		batch[key] = &alert{record: r, count: 1, last: r.time}
+		order = append(order, key)
+
+		if timer == nil {
+			timer = time.After(a.opts.Window)
+		}
+	}
+
+	flush := func() {
+		if len(order) > 0 {
+			alerts := make([]*alert, 0, len(order))
+			for _, key := range order {
+				alerts = append(alerts, batch[key])
+			}
+
+			a.send(alerts)
+		}
+
+		batch = map[string]*alert{}
+		order = nil
+		timer = nil
+	}
+
+	for {
+		select {
+		case r := <-a.records:
+			add(r)
+		case <-timer:
+			flush()
+		case <-a.closing:
+			// Take what's queued, then send it
+			for len(a.records) > 0 {
+				add(<-a.records)
+			}
+
+			flush()
+
+			return
+		}
+	}
+}
+
+// allow applies the rate cap, returning any alerts suppressed or records dropped since the last send
+func (a *alerter) allow(now time.Time) (bool, int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	recent := a.sent[:0]
+
+	for _, t := range a.sent {
+		if now.Sub(t) < rateWindow {
+			recent = append(recent, t)
+		}
+	}
+
+	a.sent = recent
+
+	if len(a.sent) >= a.opts.MaxPerHour {
+		a.suppressed++
+
+		return false, 0, 0
+	}
+
+	a.sent = append(a.sent, now)
+	suppressed, dropped := a.suppressed, a.dropped
+	a.suppressed, a.dropped = 0, 0
+
+	return true, suppressed, dropped
+}
+
+func (a *alerter) send(alerts []*alert) {
+	ok, suppressed, dropped := a.allow(time.Now())
+	if !ok {
+		return
+	}
+
+	// Most severe first, then in the order they happened
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].level > alerts[j].level
+	})
+
+	ctx := context.Background()
+
+	if a.opts.From != "" && len(a.opts.To) > 0 {
+		e := client.NewPlainEmail(a.opts.From, a.opts.To[0], a.subject(alerts), renderEmail(alerts, suppressed, dropped))
+		for _, to := range a.opts.To[1:] {
+			e.Recipients.To = append(e.Recipients.To, client.Address{Email: to, DisplayName: to})
+		}
+
+		if alerts[0].level >= slog.LevelError {
+			e.Importance = client.ImportanceHigh
+		}
+
+		if _, err := a.opts.Client.SendEmailContext(ctx, e); err != nil {
+			a.error(fmt.Errorf("error sending alert email: %s", err))
+		}
+	}
+
+	if a.opts.SMSFrom == "" {
+		return
+	}
+
+	message := renderSMS(alerts, suppressed)
+
+	for _, to := range a.opts.SMSTo {
+		resp, err := a.opts.Client.SendSingleSMSContext(ctx, client.NewSMS(a.opts.SMSFrom, to, message))
+		if err == nil {
+			err = resp.Err()
+		}
+
+		if err != nil {
+			a.error(fmt.Errorf("error sending alert SMS to %s: %w", to, err))
+		}
+	}
+}
+
+func (a *alerter) error(err error) {
+	if a.opts.OnError != nil {
+		a.opts.OnError(err)
+	}
+}
+
+func (a *alerter) subject(alerts []*alert) string {
+	prefix := a.opts.Subject
+	if prefix == "" {
+		prefix = "[" + alerts[0].level.String() + "]"
+	}
+
+	subject := prefix + " " + alerts[0].message
+	if len(alerts) > 1 {
+		subject += fmt.Sprintf(" (+%d more)", len(alerts)-1)
+	}
+
+	return subject
+}
+
+func renderEmail(alerts []*alert, suppressed, dropped int) string {
+	b := &strings.Builder{}
+
+	for _, al := range alerts {
+		fmt.Fprintf(b, "%s  %s  %s\n", al.level, al.time.Format(time.RFC3339), al.message)
+
+		if al.count > 1 {
+			fmt.Fprintf(b, "  Repeated %d times, last at %s\n", al.count, al.last.Format(time.RFC3339))
+		}
+
+		for _, attr := range al.attrs {
+			fmt.Fprintf(b, "  %s: %s\n", attr[0], attr[1])
+		}
+
+		b.WriteString("\n")
+	}
+
+	if suppressed > 0 {
+		fmt.Fprintf(b, "%d earlier alerts were not sent, to stay under the rate limit\n", suppressed)
+	}
+
+	if dropped > 0 {
+		fmt.Fprintf(b, "%d log records were dropped, as too many were logged at once\n", dropped)
+	}
+
+	return b.String()
+}
+
+func renderSMS(alerts []*alert, suppressed int) string {
+	parts := []string{}
+
+	for _, al := range alerts {
+		part := al.level.String() + ": " + al.message
+		if al.count > 1 {
+			part += fmt.Sprintf(" (x%d)", al.count)
+		}
+
+		parts = append(parts, part)
+	}
+
+	message := strings.Join(parts, "\n")
+	if suppressed > 0 {
+		message += fmt.Sprintf("\n+%d alerts not sent", suppressed)
+	}
+
+	if runes := []rune(message); len(runes) > maxSMSLength {
+		message = string(runes[:maxSMSLength-3]) + "..."
+	}
+
+	return message
+}
@@ -0,0 +1,151 @@
+package logalert
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+	"github.com/benc-uk/go-acs-client/devmail"
+)
+
+func fakeACS(t *testing.T) (*client.Client, devmail.Store) {
+	t.Helper()
+
+	store := devmail.NewMemoryStore()
+	srv := httptest.NewServer(devmail.New(store))
+	t.Cleanup(srv.Close)
+
+	return client.New(base64.StdEncoding.EncodeToString([]byte("dev")), srv.URL), store
+}
+
+func TestHandler(t *testing.T) {
+	acsClient, store := fakeACS(t)
+	out := &bytes.Buffer{}
+
+	h, err := NewHandler(slog.NewTextHandler(out, nil), Options{
+		Client:  acsClient,
+		From:    "alerts@blah.net",
+		To:      []string{"ops@example.net", "oncall@example.net"},
+		SMSFrom: "+15550000000",
+		SMSTo:   []string{"+15550000001"},
+		Window:  time.Hour,
+		OnError: func(err error) { t.Error(err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := slog.New(h).With("service", "orders")
+
+	for i := 0; i < 3; i++ {
+		log.Error("database unavailable", "db", slog.GroupValue(slog.String("host", "primary")))
+	}
+
+	log.WithGroup("disk").Error("disk full", "mount", "/data")
+	log.Warn("slow request")
+
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "slow request") || strings.Count(out.String(), "database unavailable") != 3 {
+		t.Errorf("all records should be passed on, got %s", out.String())
+	}
+
+	messages, _ := store.List(context.Background())
+	if len(messages) != 2 {
+		t.Fatalf("expected one email and one SMS, got %d messages", len(messages))
+	}
+
+	for _, m := range messages {
+		switch m.Kind {
+		case devmail.KindEmail:
+			e := m.Email
+			if e.Content.Subject != "[ERROR] database unavailable (+1 more)" || len(e.Recipients.To) != 2 {
+				t.Errorf("unexpected email %q to %v", e.Content.Subject, e.Recipients.To)
+			}
+
+			for _, want := range []string{"Repeated 3 times", "service: orders", "db.host: primary", "disk.mount: /data"} {
+				if !strings.Contains(e.Content.PlainText, want) {
+					t.Errorf("expected the body to contain %q, got\n%s", want, e.Content.PlainText)
+				}
+			}
+
+			if strings.Contains(e.Content.PlainText, "slow request") {
+				t.Error("records below the level should not be alerted")
+			}
+		case devmail.KindSMS:
+			if m.SMS.Message != "ERROR: database unavailable (x3)\nERROR: disk full" {
+				t.Errorf("unexpected SMS %q", m.SMS.Message)
+			}
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	acsClient, store := fakeACS(t)
+
+	h, err := NewHandler(nil, Options{
+		Client:     acsClient,
+		SMSFrom:    "+15550000000",
+		SMSTo:      []string{"+15550000001"},
+		Window:     time.Millisecond,
+		MaxPerHour: 2,
+		QueueSize:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := slog.New(h)
+
+	// A log storm must never block, records which don't fit are dropped
+	start := time.Now()
+	for i := 0; i < 10000; i++ {
+		log.Error("storm")
+	}
+
+	if time.Since(start) > time.Second {
+		t.Error("logging should not block")
+	}
+
+	for i := 0; i < 5; i++ {
+		log.Error("alert", "n", i)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_ = h.Close(context.Background())
+
+	messages, _ := store.List(context.Background())
+	if len(messages) != 2 {
+		t.Errorf("expected the rate limit to cap alerts at 2, got %d", len(messages))
+	}
+
+	if ok, _, _ := h.alerts.allow(time.Now().Add(rateWindow)); !ok {
+		t.Error("alerts should be allowed once the rate window has passed")
+	}
+}
+
+func TestRender(t *testing.T) {
+	alerts := []*alert{{record: record{level: slog.LevelError, message: "boom", time: time.Now()}, count: 1}}
+
+	body := renderEmail(alerts, 4, 100)
+	if !strings.Contains(body, "4 earlier alerts were not sent") || !strings.Contains(body, "100 log records were dropped") {
+		t.Errorf("expected suppressed and dropped counts, got %s", body)
+	}
+
+	alerts[0].message = strings.Repeat("x", 500)
+	if sms := renderSMS(alerts, 0); len(sms) != maxSMSLength {
+		t.Errorf("expected the SMS to be truncated, got %d chars", len(sms))
+	}
+
+	if _, err := NewHandler(nil, Options{Client: &client.Client{}, From: "alerts@blah.net"}); err == nil {
+		t.Error("expected an error without recipients")
+	}
+}
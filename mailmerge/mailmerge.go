@@ -0,0 +1,464 @@
+package mailmerge
+
+// ==============================================================================
+// Mail merge, sends a personalised email to each row of a data source
+// Rows are rendered from templates, and can be written to disk in a dry run.
+// Completed rows are recorded in a checkpoint file, so a merge can be resumed
+// ==============================================================================
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+const fileMode = 0o600
+const dirMode = 0o750
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Mapping says which columns hold the recipient details, and which are used as template variables
+type Mapping struct {
+	To   string // Column with the recipient email address, required
+	Name string // Column with the recipient display name, optional
+	CC   string // Column with CC addresses, separated by ; or , optional
+	Key  string // Column uniquely identifying the row, defaults to the To address. Later rows with the same key are skipped, rows without one fail
+
+	// Vars maps template variable names to columns, when empty all columns are
+	// available to templates using their column names
+	Vars map[string]string
+}
+
+// Template is used to render each email, Subject and Text use text/template,
+// HTML uses html/template. Either HTML, Text or Markdown must be set
+type Template struct {
+	From     string
+	Subject  string
+	HTML     string
+	Text     string
+	Markdown string // Rendered with client.NewMarkdownEmail, used instead of HTML & Text
+}
+
+// Merge sends a templated email to each row
+type Merge struct {
+	Client   *client.Client
+	Template Template
+	Mapping  Mapping
+
+	// DryRunDir when set, rendered emails are written to this directory instead of being sent
+	DryRunDir string
+
+	// Checkpoint is the path of a file recording completed rows, optional.
+	// Rows already in the checkpoint are skipped, so a merge can be safely re-run
+	Checkpoint string
+
+	// ID identifies the merge, each email's idempotency key is the ID and the row's key, so a
+	// row sent but not yet checkpointed isn't delivered twice. Defaults to a hash of the template
+	ID string
+
+	// Batch controls concurrency, rate limiting & retries when sending
+	Batch client.BatchOptions
+
+	// OnResult is called as each row completes, optional
+	OnResult func(r Result)
+}
+
+// Result is the outcome for a single row
+type Result struct {
+	Row       int // Index of the row in the data source
+	To        string
+	MessageID string
+	Skipped   bool // Already completed in a previous run, a duplicate, or not sent as the merge stopped
+	Duplicate bool // Has the same key as an earlier row, so was skipped
+	Stopped   bool // Not sent as the merge stopped early, so was skipped
+	Err       error
+}
+
+// Summary counts the outcomes of a merge
+type Summary struct {
+	Total    int
+	Sent     int
+	Rendered int // Written to disk by a dry run, rather than sent
+	Skipped  int
+	Failed   int
+}
+
+// checkpointEntry is written to the checkpoint file, as a line of JSON for each completed row
+type checkpointEntry struct {
+	Key       string `json:"key"`
+	To        string `json:"to"`
+	MessageID string `json:"messageId,omitempty"`
+}
+
+type compiledTemplate struct {
+	subject  *template.Template
+	text     *template.Template
+	markdown *template.Template
+	html     *htmltemplate.Template
+}
+
+// Run renders and sends an email for every row, skipping rows found in the checkpoint
+func (m *Merge) Run(ctx context.Context, rows []Row) (Summary, error) {
+	summary := Summary{Total: len(rows)}
+
+	if m.Mapping.To == "" {
+		return summary, fmt.Errorf("mapping must include the To column")
+	}
+
+	if m.Client == nil && m.DryRunDir == "" {
+		return summary, fmt.Errorf("a client is required, unless doing a dry run")
+	}
+
+	tmpl, err := m.compile()
+	if err != nil {
+		return summary, err
+	}
+
+	done, partial, err := readCheckpoint(m.Checkpoint)
+	if err != nil {
+		return summary, err
+	}
+
+	id := m.ID
+	if id == "" {
+		id = m.Template.hash()
+	}
+
+	emails := []*client.Email{}
+	pending := []Result{}
+	keys := []string{}
+	seen := map[string]bool{}
+
+	for i, row := range rows {
+		to := strings.TrimSpace(row[m.Mapping.To])
+		key := to
+		keyColumn := m.Mapping.To
+
+		if m.Mapping.Key != "" {
+			key = row[m.Mapping.Key]
+			keyColumn = m.Mapping.Key
+		}
+
+		// Without a key the row can't be checkpointed, and would be a duplicate of any other blank row
+		if strings.TrimSpace(key) == "" {
+			summary.Failed++
+			m.report(Result{Row: i, To: to, Err: fmt.Errorf("row %d: no key in column '%s'", i, keyColumn)})
+
+			continue
+		}
+
+		if done[key] {
+			summary.Skipped++
+			m.report(Result{Row: i, To: to, Skipped: true})
+
+			continue
+		}
+
+		if seen[key] {
+			summary.Skipped++
+			m.report(Result{Row: i, To: to, Skipped: true, Duplicate: true})
+
+			continue
+		}
+
+		seen[key] = true
+
+		e, err := m.render(tmpl, row)
+		if err != nil {
+			summary.Failed++
+			m.report(Result{Row: i, To: to, Err: fmt.Errorf("row %d: %s", i, err)})
+
+			continue
+		}
+
+		// The checkpoint is written after sending, the key covers a crash in between
+		e.SetIdempotencyKey(id + "\n" + key)
+
+		emails = append(emails, e)
+		pending = append(pending, Result{Row: i, To: to})
+		keys = append(keys, key)
+	}
+
+	if m.DryRunDir != "" {
+		return summary, m.dryRun(emails, pending, &summary)
+	}
+
+	var checkpoint *os.File
+
+	if m.Checkpoint != "" {
+		checkpoint, err = os.OpenFile(m.Checkpoint, os.O_CREATE|os.O_APPEND|os.O_WRONLY, fileMode)
+		if err != nil {
+			return summary, fmt.Errorf("error opening checkpoint: %s", err)
+		}
+		defer checkpoint.Close()
+
+		// Terminate any partial line left by a crash, so it doesn't corrupt the next entry
+		if partial {
+			if _, err = checkpoint.Write([]byte("\n")); err != nil {
+				return summary, fmt.Errorf("error writing checkpoint: %s", err)
+			}
+		}
+	}
+
+	var checkpointErr error
+
+	batchOpts := m.Batch
+	batchOpts.Progress = func(p client.BatchProgress) {
+		result := pending[p.Result.Index]
+		result.MessageID = p.Result.MessageID
+		result.Err = p.Result.Err
+
+		switch {
+		case errors.Is(result.Err, client.ErrBatchStopped):
+			// Not sent, so a re-run sends it
+			result.Err = nil
+			result.Skipped = true
+			result.Stopped = true
+			summary.Skipped++
+		case result.Err != nil:
+			summary.Failed++
+		default:
+			summary.Sent++
+
+			if checkpoint != nil && checkpointErr == nil {
+				checkpointErr = writeCheckpoint(checkpoint, checkpointEntry{
+					Key:       keys[p.Result.Index],
+					To:        result.To,
+					MessageID: result.MessageID,
+				})
+			}
+		}
+
+		m.report(result)
+
+		if m.Batch.Progress != nil {
+			m.Batch.Progress(p)
+		}
+	}
+
+	_, err = m.Client.SendEmailBatch(ctx, emails, batchOpts)
+	if checkpointErr != nil {
+		return summary, fmt.Errorf("error writing checkpoint: %s", checkpointErr)
+	}
+
+	return summary, err
+}
+
+func (m *Merge) report(r Result) {
+	if m.OnResult != nil {
+		m.OnResult(r)
+	}
+}
+
+// hash identifies the template, so the same merge run again gives the same idempotency keys
+func (t Template) hash() string {
+	data, _ := json.Marshal(t)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Merge) compile() (*compiledTemplate, error) {
+	t := m.Template
+	ct := &compiledTemplate{}
+
+	if t.HTML == "" && t.Text == "" && t.Markdown == "" {
+		return nil, fmt.Errorf("template must have a HTML, Text or Markdown body")
+	}
+
+	var err error
+
+	if ct.subject, err = template.New("subject").Option("missingkey=error").Parse(t.Subject); err != nil {
+		return nil, fmt.Errorf("error parsing subject template: %s", err)
+	}
+
+	if t.Markdown != "" {
+		if ct.markdown, err = template.New("markdown").Option("missingkey=error").Parse(t.Markdown); err != nil {
+			return nil, fmt.Errorf("error parsing markdown template: %s", err)
+		}
+
+		return ct, nil
+	}
+
+	if t.HTML != "" {
+		if ct.html, err = htmltemplate.New("html").Option("missingkey=error").Parse(t.HTML); err != nil {
+			return nil, fmt.Errorf("error parsing HTML template: %s", err)
+		}
+	}
+
+	if t.Text != "" {
+		if ct.text, err = template.New("text").Option("missingkey=error").Parse(t.Text); err != nil {
+			return nil, fmt.Errorf("error parsing text template: %s", err)
+		}
+	}
+
+	return ct, nil
+}
+
+// render builds the email for a row
+func (m *Merge) render(ct *compiledTemplate, row Row) (*client.Email, error) {
+	to := strings.TrimSpace(row[m.Mapping.To])
+	if to == "" {
+		return nil, fmt.Errorf("no address in column '%s'", m.Mapping.To)
+	}
+
+	vars := map[string]string{}
+
+	if len(m.Mapping.Vars) == 0 {
+		for k, v := range row {
+			vars[k] = v
+		}
+	} else {
+		for name, col := range m.Mapping.Vars {
+			vars[name] = row[col]
+		}
+	}
+
+	subject, err := execute(ct.subject, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var e *client.Email
+
+	switch {
+	case ct.markdown != nil:
+		md, err := execute(ct.markdown, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err = client.NewMarkdownEmail(m.Template.From, to, subject, md)
+		if err != nil {
+			return nil, err
+		}
+	case ct.html != nil:
+		buf := bytes.Buffer{}
+		if err := ct.html.Execute(&buf, vars); err != nil {
+			return nil, err
+		}
+
+		e = client.NewHTMLEmail(m.Template.From, to, subject, buf.String())
+	default:
+		e = client.NewPlainEmail(m.Template.From, to, subject, "")
+	}
+
+	if ct.text != nil {
+		if e.Content.PlainText, err = execute(ct.text, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	if name := strings.TrimSpace(row[m.Mapping.Name]); m.Mapping.Name != "" && name != "" {
+		e.Recipients.To[0].DisplayName = name
+	}
+
+	if m.Mapping.CC != "" {
+		for _, cc := range strings.FieldsFunc(row[m.Mapping.CC], func(r rune) bool { return r == ';' || r == ',' }) {
+			if cc = strings.TrimSpace(cc); cc != "" {
+				e.AddCC(cc, cc)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// dryRun writes each email to disk, as the JSON which would be sent, plus previews of the content
+func (m *Merge) dryRun(emails []*client.Email, pending []Result, summary *Summary) error {
+	err := os.MkdirAll(m.DryRunDir, dirMode)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range emails {
+		result := pending[i]
+		base := filepath.Join(m.DryRunDir, fmt.Sprintf("%04d-%s", result.Row, unsafeFileChars.ReplaceAllString(result.To, "_")))
+
+		result.Err = e.Prepare()
+
+		if result.Err == nil {
+			data, _ := json.MarshalIndent(e, "", "  ")
+			result.Err = os.WriteFile(base+".json", data, fileMode)
+		}
+
+		if result.Err == nil && e.Content.HTML != "" {
+			result.Err = os.WriteFile(base+".html", []byte(e.Content.HTML), fileMode)
+		}
+
+		if result.Err == nil && e.Content.PlainText != "" {
+			result.Err = os.WriteFile(base+".txt", []byte(e.Content.PlainText), fileMode)
+		}
+
+		if result.Err != nil {
+			summary.Failed++
+		} else {
+			summary.Rendered++
+		}
+
+		m.report(result)
+	}
+
+	return nil
+}
+
+func execute(t *template.Template, vars map[string]string) (string, error) {
+	buf := bytes.Buffer{}
+
+	err := t.Execute(&buf, vars)
+
+	return buf.String(), err
+}
+
+// readCheckpoint loads the keys of completed rows, a missing file is not an error.
+// Partial is true when the file doesn't end with a complete line
+func readCheckpoint(path string) (done map[string]bool, partial bool, err error) {
+	done = map[string]bool{}
+	if path == "" {
+		return done, false, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return done, false, nil
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading checkpoint: %s", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		entry := checkpointEntry{}
+
+		// A partial last line can be left by a crash, it's safe to ignore
+		if err := json.Unmarshal([]byte(line), &entry); err == nil {
+			done[entry.Key] = true
+		}
+	}
+
+	return done, len(data) > 0 && data[len(data)-1] != '\n', nil
+}
+
+// writeCheckpoint appends an entry and syncs it to disk straight away
+func writeCheckpoint(f *os.File, entry checkpointEntry) error {
+	data, _ := json.Marshal(entry)
+
+	_, err := f.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
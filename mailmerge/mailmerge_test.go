@@ -0,0 +1,233 @@
+package mailmerge
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+const csvData = `email,name,cc,plan
+alice@example.com,Alice,,gold
+bob@example.com,Bob,carol@example.com;dave@example.com,silver
+`
+
+func TestReadJSONLines(t *testing.T) {
+	rows, err := ReadJSONLines(strings.NewReader("{\"email\":\"a@example.com\",\"count\":3}\n\n{\"email\":\"b@example.com\",\"count\":null}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || rows[0]["count"] != "3" || rows[1]["email"] != "b@example.com" || rows[1]["count"] != "" {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	rows, err := ReadCSV(strings.NewReader(csvData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	m := &Merge{
+		Template: Template{
+			From:    "noreply@example.com",
+			Subject: "Your {{.plan}} plan",
+			HTML:    "<p>Hi {{.name}}</p>",
+		},
+		Mapping:   Mapping{To: "email", Name: "name", CC: "cc"},
+		DryRunDir: dir,
+	}
+
+	summary, err := m.Run(context.Background(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Rendered != 2 || summary.Sent != 0 || summary.Failed != 0 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "0001-bob_example.com.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := client.Email{}
+	_ = json.Unmarshal(data, &e)
+
+	if e.Content.Subject != "Your silver plan" || e.Content.HTML != "<p>Hi Bob</p>" || e.Content.PlainText != "Hi Bob" {
+		t.Errorf("Unexpected content: %+v", e.Content)
+	}
+
+	if e.Recipients.To[0].DisplayName != "Bob" || len(e.Recipients.CC) != 2 {
+		t.Errorf("Unexpected recipients: %+v", e.Recipients)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	sent := int32(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		w.Header().Set("x-ms-request-id", "id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	rows, _ := ReadCSV(strings.NewReader(csvData))
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+
+	// Pretend a previous run got as far as Alice
+	_ = os.WriteFile(checkpoint, []byte(`{"key":"alice@example.com","to":"alice@example.com"}`+"\n{\"key\":\"bo"), 0o600)
+
+	m := &Merge{
+		Client:     client.New(base64.StdEncoding.EncodeToString([]byte("key")), srv.URL),
+		Template:   Template{From: "noreply@example.com", Subject: "Hi", Text: "Hello {{.name}}"},
+		Mapping:    Mapping{To: "email"},
+		Checkpoint: checkpoint,
+	}
+
+	summary, err := m.Run(context.Background(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Sent != 1 || summary.Skipped != 1 || sent != 1 {
+		t.Errorf("Unexpected summary: %+v, requests: %d", summary, sent)
+	}
+
+	// Second run should send nothing
+	summary, _ = m.Run(context.Background(), rows)
+	if summary.Skipped != 2 || sent != 1 {
+		t.Errorf("Unexpected summary on resume: %+v, requests: %d", summary, sent)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	ids := map[string]int{}
+	mu := sync.Mutex{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		ids[r.Header.Get("repeatability-request-id")]++
+
+		w.Header().Set("x-ms-request-id", "id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	// Bob is in the file twice
+	rows, _ := ReadCSV(strings.NewReader(csvData + "bob@example.com,Robert,,gold\n"))
+	duplicates := 0
+
+	m := &Merge{
+		Client:   client.New(base64.StdEncoding.EncodeToString([]byte("key")), srv.URL),
+		Template: Template{From: "noreply@example.com", Subject: "Hi", Text: "Hello {{.name}}"},
+		Mapping:  Mapping{To: "email"},
+		OnResult: func(r Result) {
+			if r.Duplicate {
+				duplicates++
+			}
+		},
+	}
+
+	summary, err := m.Run(context.Background(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Sent != 2 || summary.Skipped != 1 || duplicates != 1 {
+		t.Errorf("Expected the duplicate row to be skipped: %+v", summary)
+	}
+
+	// Without a checkpoint, as if it crashed before writing one, the same request IDs are sent again
+	_, _ = m.Run(context.Background(), rows)
+
+	if len(ids) != 2 {
+		t.Errorf("Expected the same request ID for each row in both runs, got %v", ids)
+	}
+
+	for id, n := range ids {
+		if n != 2 {
+			t.Errorf("Expected request ID %s twice, got %d", id, n)
+		}
+	}
+}
+
+func TestEmptyKeys(t *testing.T) {
+	// The first two rows have no plan, so no key
+	rows, _ := ReadCSV(strings.NewReader("email,plan\nalice@example.com,\nbob@example.com, \n,gold\n"))
+	failed := 0
+
+	m := &Merge{
+		Template:  Template{From: "noreply@example.com", Subject: "Hi", Text: "Hello"},
+		Mapping:   Mapping{To: "email", Key: "plan"},
+		DryRunDir: t.TempDir(),
+		OnResult: func(r Result) {
+			if r.Duplicate {
+				t.Errorf("Expected row %d to fail, not be a duplicate", r.Row)
+			}
+
+			if r.Err != nil {
+				failed++
+			}
+		},
+	}
+
+	summary, err := m.Run(context.Background(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last row has a key, but no address
+	if summary.Failed != 3 || summary.Skipped != 0 || failed != 3 {
+		t.Errorf("Expected rows without a key to fail: %+v", summary)
+	}
+}
+
+func TestStopOnError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	rows, _ := ReadCSV(strings.NewReader(csvData + "erin@example.com,Erin,,gold\n"))
+	results := 0
+	stopped := 0
+
+	m := &Merge{
+		Client:   client.New(base64.StdEncoding.EncodeToString([]byte("key")), srv.URL),
+		Template: Template{From: "noreply@example.com", Subject: "Hi", Text: "Hello {{.name}}"},
+		Mapping:  Mapping{To: "email"},
+		Batch:    client.BatchOptions{Concurrency: 1, StopOnError: true},
+		OnResult: func(r Result) {
+			results++
+
+			if r.Stopped {
+				stopped++
+			}
+		},
+	}
+
+	summary, err := m.Run(context.Background(), rows)
+	if err == nil {
+		t.Error("Expected the merge to stop with an error")
+	}
+
+	// Every row is reported, and the totals add up
+	if summary.Failed != 1 || summary.Skipped != 2 || stopped != 2 || results != summary.Total {
+		t.Errorf("Expected the rows after the failure to be skipped: %+v", summary)
+	}
+}
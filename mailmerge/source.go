@@ -0,0 +1,120 @@
+package mailmerge
+
+// ==============================================================================
+// Data sources for mail merge, rows are read from CSV or JSON Lines
+// ==============================================================================
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const maxLineSize = 1024 * 1024
+
+// Row is a single recipient, mapping column names to values
+type Row map[string]string
+
+// ReadCSV reads rows from CSV, the first line must hold the column names
+func ReadCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %s", err)
+	}
+
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	rows := []Row{}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %s", err)
+		}
+
+		row := Row{}
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = value
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// ReadJSONLines reads rows from JSON Lines, one object per line,
+// values which aren't strings are converted to their JSON text
+func ReadJSONLines(r io.Reader) ([]Row, error) {
+	rows := []Row{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		obj := map[string]interface{}{}
+
+		err := json.Unmarshal([]byte(line), &obj)
+		if err != nil {
+			return nil, fmt.Errorf("error reading JSON on line %d: %s", lineNum, err)
+		}
+
+		row := Row{}
+
+		for k, v := range obj {
+			switch val := v.(type) {
+			case string:
+				row[k] = val
+			case nil:
+				row[k] = ""
+			default:
+				b, _ := json.Marshal(val)
+				row[k] = string(b)
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+// ReadFile reads rows from a file, using the extension to pick
+// between CSV and JSON Lines (.jsonl, .ndjson or .json)
+func ReadFile(path string) ([]Row, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson", ".json":
+		return ReadJSONLines(f)
+	default:
+		return ReadCSV(f)
+	}
+}
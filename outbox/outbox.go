@@ -0,0 +1,415 @@
+package outbox
+
+// ==============================================================================
+// Durable outbox for email and SMS messages
+// Messages are persisted before being sent by a pool of background workers,
+// retries reuse the same repeatability ID so ACS won't deliver a message twice.
+// Pending messages are picked up again when the outbox restarts
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+
+	"github.com/google/uuid"
+)
+
+const defaultWorkers = 4
+const defaultMaxAttempts = 5
+const defaultRetryDelay = 2 * time.Second
+const defaultMaxRetryDelay = 5 * time.Minute
+const defaultPollInterval = 5 * time.Second
+
+// Kind is the type of message held in the outbox
+type Kind string
+
+// State of a message in the outbox
+type State string
+
+const (
+	KindEmail Kind = "email"
+	KindSMS   Kind = "sms"
+
+	StatePending State = "pending" // Waiting to be sent, or retried
+	StateSent    State = "sent"    // Accepted by ACS
+	StateFailed  State = "failed"  // Gave up, see LastError
+)
+
+// Message is an email or SMS held in the outbox
+type Message struct {
+	ID    string        `json:"id"`
+	Kind  Kind          `json:"kind"`
+	Email *client.Email `json:"email,omitempty"`
+	SMS   *client.SMS   `json:"sms,omitempty"`
+	Owner string        `json:"owner,omitempty"` // Who enqueued the message, see WithOwner
+
+	// Used for every attempt, SMS messages carry these per recipient
+	RepeatabilityRequestID string `json:"repeatabilityRequestId,omitempty"`
+	RepeatabilityFirstSent string `json:"repeatabilityFirstSent,omitempty"`
+
+	State       State     `json:"state"`
+	Attempts    int       `json:"attempts"`
+	MessageID   string    `json:"messageId,omitempty"` // Message ID returned by ACS once sent
+	LastError   string    `json:"lastError,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Options for the outbox dispatcher, zero values use the defaults
+type Options struct {
+	Workers       int           // Number of messages sent in parallel, defaults to 4
+	MaxAttempts   int           // Attempts before a message is marked as failed, defaults to 5
+	RetryDelay    time.Duration // Delay before the first retry, doubled for each retry, defaults to 2s
+	MaxRetryDelay time.Duration // Maximum delay between retries, defaults to 5m
+	PollInterval  time.Duration // How often the store is checked for messages due a retry, defaults to 5s
+
+	// OnComplete is called when a message is sent or has failed, optional
+	OnComplete func(m *Message)
+}
+
+// EnqueueOption sets optional fields of a message as it's enqueued
+type EnqueueOption func(m *Message)
+
+// WithOwner records who the message belongs to, so a service sending for several
+// callers can check they only see their own messages
+func WithOwner(owner string) EnqueueOption {
+	return func(m *Message) {
+		m.Owner = owner
+	}
+}
+
+// Outbox persists messages and sends them in the background
+type Outbox struct {
+	client *client.Client
+	store  Store
+	opts   Options
+
+	wake     chan struct{}
+	mu       sync.Mutex
+	inFlight map[string]bool
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// New creates an outbox, call Start to begin sending messages
+func New(c *client.Client, store Store, opts Options) *Outbox {
+	if opts.Workers < 1 {
+		opts.Workers = defaultWorkers
+	}
+
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+
+	if opts.MaxRetryDelay <= 0 {
+		opts.MaxRetryDelay = defaultMaxRetryDelay
+	}
+
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	return &Outbox{
+		client:   c,
+		store:    store,
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		inFlight: map[string]bool{},
+	}
+}
+
+// EnqueueEmail stores an email for sending, and returns the outbox message ID
+// The email is prepared first, so any transforms are run before it's stored
+func (o *Outbox) EnqueueEmail(ctx context.Context, e *client.Email, opts ...EnqueueOption) (string, error) {
+	// A prepared copy is stored, without the transforms so they don't run again when it's sent
+	e = e.Clone()
+
+	err := e.Prepare()
+	if err != nil {
+		return "", fmt.Errorf("error preparing email: %s", err)
+	}
+
+	e.Transforms = nil
+
+	m := newMessage(KindEmail)
+	m.Email = e
+
+	// The key isn't serialised, so the headers it maps to are stored instead
+	switch {
+	case e.RepeatabilityRequestID != "":
+		m.RepeatabilityRequestID = e.RepeatabilityRequestID
+		m.RepeatabilityFirstSent = e.RepeatabilityFirstSent
+	case e.IdempotencyKey != "":
+		m.RepeatabilityRequestID, m.RepeatabilityFirstSent = o.client.Repeatability(e.IdempotencyKey)
+	default:
+		m.RepeatabilityRequestID = uuid.New().String()
+	}
+
+	if m.RepeatabilityFirstSent == "" {
+		m.RepeatabilityFirstSent = m.CreatedAt.Format(http.TimeFormat)
+	}
+
+	return m.ID, o.enqueue(ctx, m, opts)
+}
+
+// EnqueueSMS stores a SMS for sending, and returns the outbox message ID
+func (o *Outbox) EnqueueSMS(ctx context.Context, s *client.SMS, opts ...EnqueueOption) (string, error) {
+	// The repeatability IDs are set on a copy, so the caller's SMS can be enqueued again
+	s = s.Clone()
+
+	m := newMessage(KindSMS)
+	m.SMS = s
+
+	for i := range s.SMSRecipients {
+		r := &s.SMSRecipients[i]
+
+		switch {
+		case r.RepeatabilityRequestID != "":
+			// Set by the caller, so kept as it is
+		case r.IdempotencyKey != "":
+			r.RepeatabilityRequestID, r.RepeatabilityFirstSent = o.client.Repeatability(r.IdempotencyKey)
+		default:
+			r.RepeatabilityRequestID = uuid.New().String()
+			r.RepeatabilityFirstSent = m.CreatedAt.Format(http.TimeFormat)
+		}
+	}
+
+	return m.ID, o.enqueue(ctx, m, opts)
+}
+
+// Get returns the current state of a message in the outbox
+func (o *Outbox) Get(ctx context.Context, id string) (*Message, error) {
+	return o.store.Get(ctx, id)
+}
+
+// Start begins sending messages in the background, including any pending from before a restart
+func (o *Outbox) Start(ctx context.Context) {
+	ctx, o.cancel = context.WithCancel(ctx)
+	jobs := make(chan *Message)
+
+	for i := 0; i < o.opts.Workers; i++ {
+		o.wg.Add(1)
+
+		go func() {
+			defer o.wg.Done()
+
+			for m := range jobs {
+				o.dispatch(ctx, m)
+			}
+		}()
+	}
+
+	o.wg.Add(1)
+
+	go func() {
+		defer o.wg.Done()
+		defer close(jobs)
+
+		o.poll(ctx, jobs)
+	}()
+}
+
+// Stop stops sending and waits for in progress sends to finish
+// Anything not yet sent stays pending, and is sent when the outbox is next started
+func (o *Outbox) Stop() {
+	if o.cancel != nil {
+		o.cancel()
+	}
+
+	o.wg.Wait()
+}
+
+func (o *Outbox) enqueue(ctx context.Context, m *Message, opts []EnqueueOption) error {
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	err := o.store.Save(ctx, m)
+	if err != nil {
+		return fmt.Errorf("error saving message: %s", err)
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// poll hands messages that are due to the workers, whenever woken or on each interval
+func (o *Outbox) poll(ctx context.Context, jobs chan<- *Message) {
+	ticker := time.NewTicker(o.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		pending, err := o.store.ListPending(ctx)
+
+		// Store errors are assumed to be transient, the next poll will try again
+		if err == nil {
+			for _, m := range pending {
+				if m.NextAttempt.After(time.Now()) || !o.claim(m.ID) {
+					continue
+				}
+
+				// The list is a snapshot, the message may have been sent or retried since it was
+				// read, so get it again now it's claimed
+				current, err := o.store.Get(ctx, m.ID)
+				if err != nil || current.State != StatePending || current.NextAttempt.After(time.Now()) {
+					o.release(m.ID)
+
+					continue
+				}
+
+				select {
+				case jobs <- current:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// claim marks a message as in flight, returning false if it already is
+func (o *Outbox) claim(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.inFlight[id] {
+		return false
+	}
+
+	o.inFlight[id] = true
+
+	return true
+}
+
+func (o *Outbox) release(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.inFlight, id)
+}
+
+// dispatch makes a single attempt to send a message, and records the outcome
+func (o *Outbox) dispatch(ctx context.Context, m *Message) {
+	defer o.release(m.ID)
+
+	// Record the attempt before sending, so it's counted even if we crash
+	m.Attempts++
+	m.UpdatedAt = time.Now().UTC()
+
+	if err := o.store.Save(ctx, m); err != nil {
+		return
+	}
+
+	messageID, err := o.send(client.WithAttempt(ctx, m.Attempts), m)
+
+	// Shutting down mid send, leave the message pending to be resent with the same ID
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.UpdatedAt = time.Now().UTC()
+
+	switch {
+	case err == nil:
+		m.State = StateSent
+		m.MessageID = messageID
+		m.LastError = ""
+	case client.IsRetryable(err) && m.Attempts < o.opts.MaxAttempts:
+		m.LastError = err.Error()
+		m.NextAttempt = m.UpdatedAt.Add(o.backoff(m.Attempts, err))
+	default:
+		m.State = StateFailed
+		m.LastError = err.Error()
+	}
+
+	// Use a fresh context, the outcome must be saved even if we're stopping
+	saveCtx, cancel := context.WithTimeout(context.Background(), o.opts.PollInterval)
+	defer cancel()
+
+	if err := o.store.Save(saveCtx, m); err != nil {
+		return
+	}
+
+	if m.State != StatePending && o.opts.OnComplete != nil {
+		o.opts.OnComplete(m)
+	}
+}
+
+func (o *Outbox) send(ctx context.Context, m *Message) (string, error) {
+	switch m.Kind {
+	case KindEmail:
+		e := *m.Email
+		e.RepeatabilityRequestID = m.RepeatabilityRequestID
+		e.RepeatabilityFirstSent = m.RepeatabilityFirstSent
+
+		return o.client.SendEmailContext(ctx, &e)
+	case KindSMS:
+		resp, err := o.client.SendSingleSMSContext(ctx, m.SMS)
+		if err != nil {
+			return "", err
+		}
+
+		// The recipient status is used as an API error, so throttling etc. can be retried
+		if err := resp.Err(); err != nil {
+			return "", err
+		}
+
+		return resp.MessageID, nil
+	default:
+		return "", errors.New("unknown message kind: " + string(m.Kind))
+	}
+}
+
+// backoff doubles the delay for each attempt, up to the maximum, unless the API asked for longer
+func (o *Outbox) backoff(attempts int, err error) time.Duration {
+	delay := o.opts.RetryDelay
+	for i := 1; i < attempts && delay < o.opts.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > o.opts.MaxRetryDelay {
+		delay = o.opts.MaxRetryDelay
+	}
+
+	apiErr := &client.APIError{}
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+
+	return delay
+}
+
+func newMessage(kind Kind) *Message {
+	now := time.Now().UTC()
+
+	return &Message{
+		ID:          uuid.New().String(),
+		Kind:        kind,
+		State:       StatePending,
+		NextAttempt: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
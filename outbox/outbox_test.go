@@ -0,0 +1,275 @@
+package outbox
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+// fakeACS accepts emails and SMS, but fails the first attempt of each with a 503
+type fakeACS struct {
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func (f *fakeACS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := r.Header.Get("repeatability-request-id")
+	if r.URL.Path == "/sms" {
+		s := client.SMS{}
+		_ = json.NewDecoder(r.Body).Decode(&s)
+		id = s.SMSRecipients[0].RepeatabilityRequestID
+	}
+
+	f.attempts[id]++
+
+	if f.attempts[id] == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	if r.URL.Path == "/sms" {
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(client.SMSSendResponse{Value: []client.SMSSendResponseItem{
+			{MessageID: "acs-" + id, Successful: true, HTTPStatusCode: http.StatusAccepted},
+		}})
+
+		return
+	}
+
+	w.Header().Set("x-ms-request-id", "acs-"+id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func newTestClient(t *testing.T) (*client.Client, *fakeACS) {
+	fake := &fakeACS{attempts: map[string]int{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	return client.New(base64.StdEncoding.EncodeToString([]byte("key")), srv.URL), fake
+}
+
+func waitFor(t *testing.T, store Store, id string, state State) *Message {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		m, err := store.Get(context.Background(), id)
+		if err == nil && m.State == state {
+			return m
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Message %s did not reach state %s", id, state)
+
+	return nil
+}
+
+func TestOutboxRetry(t *testing.T) {
+	c, fake := newTestClient(t)
+	store := NewMemoryStore()
+	completed := make(chan *Message, 1)
+
+	o := New(c, store, Options{
+		RetryDelay:   time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+		OnComplete:   func(m *Message) { completed <- m },
+	})
+	o.Start(context.Background())
+
+	defer o.Stop()
+
+	id, err := o.EnqueueEmail(context.Background(), client.NewPlainEmail("from@example.com", "to@example.com", "Hi", "Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-completed
+	if m.ID != id || m.State != StateSent || m.Attempts != 2 {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+
+	if m.MessageID != "acs-"+m.RepeatabilityRequestID || fake.attempts[m.RepeatabilityRequestID] != 2 {
+		t.Error("Expected both attempts to use the same repeatability ID")
+	}
+}
+
+func TestOutboxResume(t *testing.T) {
+	c, _ := newTestClient(t)
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enqueue without starting, as if the process crashed before sending
+	o := New(c, store, Options{})
+
+	id, err := o.EnqueueSMS(context.Background(), client.NewSMS("+15550000000", "+15551111111", "Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new outbox over the same directory picks up the pending message
+	store, _ = NewFileStore(dir)
+	o = New(c, store, Options{RetryDelay: time.Millisecond, PollInterval: 10 * time.Millisecond})
+	o.Start(context.Background())
+
+	defer o.Stop()
+
+	m := waitFor(t, store, id, StateSent)
+	if m.Attempts != 2 || m.MessageID != "acs-"+m.SMS.SMSRecipients[0].RepeatabilityRequestID {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+}
+
+func TestOutboxSendsOnce(t *testing.T) {
+	mu := sync.Mutex{}
+	attempts := map[string]int{}
+	sent := map[string]int{}
+
+	// Slow sends, where all but the last email fail first time. The last is still being sent
+	// when the next poll lists it as pending, and is sent before that poll gets to it
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := client.Email{}
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		id := r.Header.Get("repeatability-request-id")
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts[id]++
+		if attempts[id] == 1 && e.Content.Subject != "Last" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		sent[e.Content.Subject]++
+
+		w.Header().Set("x-ms-request-id", "acs-"+id)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	c := client.New(base64.StdEncoding.EncodeToString([]byte("key")), srv.URL)
+	store := NewMemoryStore()
+	o := New(c, store, Options{Workers: 1, RetryDelay: time.Millisecond, PollInterval: time.Millisecond})
+	ids := []string{}
+
+	for _, subject := range []string{"First", "Second", "Third", "Last"} {
+		id, err := o.EnqueueEmail(context.Background(), client.NewPlainEmail("from@example.com", "to@example.com", subject, "Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	o.Start(context.Background())
+
+	for _, id := range ids {
+		waitFor(t, store, id, StateSent)
+	}
+
+	// Give a repeated send time to happen
+	time.Sleep(100 * time.Millisecond)
+	o.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for subject, n := range sent {
+		if n != 1 {
+			t.Errorf("Expected %s to be sent once, it was sent %d times", subject, n)
+		}
+	}
+
+	if len(sent) != len(ids) {
+		t.Errorf("Expected %d emails to be sent, got %v", len(ids), sent)
+	}
+}
+
+func TestOutboxEnqueueSMSCopies(t *testing.T) {
+	c, _ := newTestClient(t)
+	store := NewMemoryStore()
+	o := New(c, store, Options{})
+
+	s := &client.SMS{From: "+15550000000", Message: "Hello", SMSRecipients: []client.SMSRecipient{{To: "+15551111111"}}}
+
+	ids := map[string]bool{}
+
+	for i := 0; i < 2; i++ {
+		id, err := o.EnqueueSMS(context.Background(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, _ := store.Get(context.Background(), id)
+		ids[m.SMS.SMSRecipients[0].RepeatabilityRequestID] = true
+	}
+
+	if s.SMSRecipients[0].RepeatabilityRequestID != "" || s.SMSRecipients[0].RepeatabilityFirstSent != "" {
+		t.Errorf("Expected the caller's SMS to be unchanged, got: %+v", s.SMSRecipients[0])
+	}
+
+	if len(ids) != 2 || ids[""] {
+		t.Errorf("Expected a different repeatability ID for each message, got: %v", ids)
+	}
+}
+
+func TestOutboxIdempotencyKey(t *testing.T) {
+	c, _ := newTestClient(t)
+	store := NewMemoryStore()
+	o := New(c, store, Options{RetryDelay: time.Millisecond, PollInterval: 10 * time.Millisecond})
+
+	ids := []string{}
+
+	for _, key := range []string{"order-1234", "order-1234", "order-5678"} {
+		e := client.NewPlainEmail("from@blah.net", "to@blah.net", "Your order", "Thanks")
+		e.SetIdempotencyKey(key)
+
+		id, err := o.EnqueueEmail(context.Background(), e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	o.Start(context.Background())
+
+	defer o.Stop()
+
+	first := waitFor(t, store, ids[0], StateSent)
+	again := waitFor(t, store, ids[1], StateSent)
+	other := waitFor(t, store, ids[2], StateSent)
+
+	requestID, firstSent := c.Repeatability("order-1234")
+	if first.RepeatabilityRequestID != requestID || again.RepeatabilityRequestID != requestID {
+		t.Errorf("Expected the key's request ID %s, got %s and %s", requestID, first.RepeatabilityRequestID, again.RepeatabilityRequestID)
+	}
+
+	if first.RepeatabilityFirstSent != firstSent || again.RepeatabilityFirstSent != firstSent {
+		t.Error("Expected the same first sent time for the same key")
+	}
+
+	if first.MessageID != again.MessageID || other.RepeatabilityRequestID == requestID {
+		t.Errorf("Unexpected messages: %+v %+v %+v", first, again, other)
+	}
+}
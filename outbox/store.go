@@ -0,0 +1,228 @@
+package outbox
+
+// ==============================================================================
+// Storage for outbox messages, the Store interface can be implemented for
+// databases, a file based store and an in memory store are provided
+// ==============================================================================
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const fileMode = 0o600
+const dirMode = 0o750
+
+// ErrNotFound is returned by a Store when a message doesn't exist
+var ErrNotFound = errors.New("message not found")
+
+// Store persists outbox messages, implementations must be safe for concurrent use
+type Store interface {
+	// Save inserts or updates a message, it must be durable when Save returns
+	Save(ctx context.Context, m *Message) error
+
+	// Get returns the message with the given ID, or ErrNotFound
+	Get(ctx context.Context, id string) (*Message, error)
+
+	// ListPending returns all messages in the pending state, oldest first
+	ListPending(ctx context.Context) ([]*Message, error)
+}
+
+// MemoryStore keeps messages in memory, it's not durable so is only useful for testing
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages map[string]*Message
+}
+
+// NewMemoryStore creates an empty in memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{messages: map[string]*Message{}}
+}
+
+// Save stores a copy of the message
+func (s *MemoryStore) Save(_ context.Context, m *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp := *m
+	s.messages[m.ID] = &cp
+
+	return nil
+}
+
+// Get returns a copy of the message
+func (s *MemoryStore) Get(_ context.Context, id string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, found := s.messages[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	cp := *m
+
+	return &cp, nil
+}
+
+// ListPending returns copies of all pending messages
+func (s *MemoryStore) ListPending(_ context.Context) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := []*Message{}
+
+	for _, m := range s.messages {
+		if m.State == StatePending {
+			cp := *m
+			pending = append(pending, &cp)
+		}
+	}
+
+	sortByCreated(pending)
+
+	return pending, nil
+}
+
+// FileStore keeps each message as a JSON file in a directory
+// Files are replaced atomically, so a crash never leaves a partial message
+type FileStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileStore creates a store in the given directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("error creating store directory: %s", err)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes the message to a temporary file, syncs it, then renames it into place
+func (s *FileStore) Save(_ context.Context, m *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), s.path(m.ID))
+	if err != nil {
+		return err
+	}
+
+	return syncDir(s.dir)
+}
+
+// Get reads a message from its file
+func (s *FileStore) Get(_ context.Context, id string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(s.path(id))
+}
+
+// ListPending reads all messages and returns those which are pending
+func (s *FileStore) ListPending(_ context.Context) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []*Message{}
+
+	for _, file := range files {
+		m, err := s.read(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if m.State == StatePending {
+			pending = append(pending, m)
+		}
+	}
+
+	sortByCreated(pending)
+
+	return pending, nil
+}
+
+func (s *FileStore) path(id string) string {
+	// IDs are generated UUIDs, but guard against anything being used as a path
+	return filepath.Join(s.dir, strings.ReplaceAll(filepath.Base(id), ".", "_")+".json")
+}
+
+func (s *FileStore) read(path string) (*Message, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{}
+
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	return m, nil
+}
+
+// syncDir makes a rename durable, by syncing the directory holding the file
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// Not supported on all platforms, so errors are ignored
+	_ = d.Sync()
+
+	return nil
+}
+
+func sortByCreated(messages []*Message) {
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+}
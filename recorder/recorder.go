@@ -0,0 +1,257 @@
+package recorder
+
+// ==============================================================================
+// Record and replay of HTTP interactions, so tests can run without live ACS.
+// In record mode requests are sent for real, and each request/response pair
+// is saved to a JSON cassette file with secrets scrubbed. In replay mode the
+// responses are played back from the cassette, and nothing is sent
+// ==============================================================================
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const fileMode = 0o600
+const dirMode = 0o750
+
+// Mode controls if interactions are recorded or replayed
+type Mode int
+
+const (
+	// ModeReplay plays back responses from the cassette, requests are never sent
+	ModeReplay Mode = iota
+
+	// ModeRecord sends requests for real, and saves them to the cassette when stopped
+	ModeRecord
+)
+
+// ErrNoMatch is returned in replay mode when no recorded interaction matches the request
+var ErrNoMatch = errors.New("no recorded interaction matches request")
+
+// ParseMode converts "record" or "replay" to a Mode
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "record":
+		return ModeRecord, nil
+	case "replay", "playback":
+		return ModeReplay, nil
+	default:
+		return ModeReplay, fmt.Errorf("unknown recorder mode: %q", s)
+	}
+}
+
+// Options for the recorder, all are optional
+type Options struct {
+	// Transport sends requests in record mode, defaults to http.DefaultTransport
+	Transport http.RoundTripper
+
+	// Secrets are extra values to scrub from cassettes, e.g. the endpoint host or access key
+	Secrets []string
+}
+
+// Cassette holds the recorded interactions
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the part of a request used for matching, with secrets scrubbed
+type Request struct {
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// Response is a recorded response, with secrets scrubbed
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Recorder is a http.RoundTripper which records or replays interactions
+type Recorder struct {
+	path     string
+	mode     Mode
+	opts     Options
+	scrubber *scrubber
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// New creates a recorder for the cassette file, in replay mode the cassette must exist
+func New(path string, mode Mode, opts Options) (*Recorder, error) {
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+
+	r := &Recorder{
+		path:     path,
+		mode:     mode,
+		opts:     opts,
+		scrubber: newScrubber(opts.Secrets),
+		cassette: &Cassette{},
+	}
+
+	if mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cassette: %s", err)
+		}
+
+		err = json.Unmarshal(data, r.cassette)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cassette %s: %s", path, err)
+		}
+
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+
+	return r, nil
+}
+
+// Mode returns the mode of the recorder
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// RoundTrip records or replays a request
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := Request{
+		Method:  req.Method,
+		Path:    r.scrubber.scrub(req.URL.RequestURI()),
+		Headers: r.scrubber.headers(req.Header),
+		Body:    r.scrubber.body(body),
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req, recorded)
+	}
+
+	return r.record(req, recorded)
+}
+
+// Stop saves the cassette in record mode, it does nothing in replay mode
+func (r *Recorder) Stop() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(r.path), dirMode)
+	if err != nil {
+		return fmt.Errorf("error creating cassette directory: %s", err)
+	}
+
+	return os.WriteFile(r.path, append(data, '\n'), fileMode)
+}
+
+func (r *Recorder) record(req *http.Request, recorded Request) (*http.Response, error) {
+	resp, err := r.opts.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Headers:    r.scrubber.headers(resp.Header),
+			Body:       r.scrubber.body(data),
+		},
+	})
+
+	return resp, nil
+}
+
+// replay returns the response of the first unused interaction which matches the request
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || !matches(in.Request, recorded) {
+			continue
+		}
+
+		r.used[i] = true
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Headers.Clone(),
+			Body:          io.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s", ErrNoMatch, recorded.Method, recorded.Path)
+}
+
+// matches compares method, path and the normalised body, headers are not compared
+func matches(a, b Request) bool {
+	return a.Method == b.Method && a.Path == b.Path && a.Body == b.Body
+}
+
+// readBody reads the request body, and replaces it so it can still be sent
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(data))
+
+	return data, nil
+}
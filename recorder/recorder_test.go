@@ -0,0 +1,103 @@
+package recorder
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func send(t *testing.T, rt http.RoundTripper, url, body, date string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", url+"/emails:send?api-version=1", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", "HMAC-SHA256 Signature=abc")
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("repeatability-request-id", date)
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-request-id", "msg-id")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"to":"+14255550123","accesskey":"endpoint=x;accesskey=c2VjcmV0"}`))
+	}))
+	defer srv.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassettes", "test.json")
+
+	rec, err := New(cassette, ModeRecord, Options{Secrets: []string{"topsecret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"sender":"real@contoso.com","content":{"subject":"topsecret"},"smsRecipients":[{"to":"+14255550123","repeatabilityRequestId":"1"}]}`
+	resp := send(t, rec, srv.URL, body, "Mon, 01 Jan 2024 00:00:00 GMT")
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	if err = rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"contoso.com", "4255550123", "topsecret", "c2VjcmV0", "HMAC-SHA256", "2024"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, data)
+		}
+	}
+
+	// Replay with different volatile values, and a different but equivalent body
+	player, err := New(cassette, ModeReplay, Options{Secrets: []string{"topsecret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body = `{"smsRecipients":[{"repeatabilityRequestId":"2","to":"+14255550199"}],"content":{"subject":"topsecret"},"sender":"other@contoso.com"}`
+	resp = send(t, player, "http://replay.invalid", body, "Tue, 02 Jan 2024 00:00:00 GMT")
+
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("x-ms-request-id") != "msg-id" {
+		t.Errorf("unexpected replayed response %d %v", resp.StatusCode, resp.Header)
+	}
+
+	replayed, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(replayed), PlaceholderPhone) {
+		t.Errorf("unexpected replayed body %s", replayed)
+	}
+
+	// Each interaction is only used once
+	req, _ := http.NewRequest("POST", "http://replay.invalid/emails:send?api-version=1", strings.NewReader(body))
+
+	_, err = player.RoundTrip(req)
+	if !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch, got %v", err)
+	}
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	_, err := New(filepath.Join(t.TempDir(), "missing.json"), ModeReplay, Options{})
+	if err == nil {
+		t.Error("expected an error for a missing cassette")
+	}
+}
@@ -0,0 +1,136 @@
+package recorder
+
+// ==============================================================================
+// Scrubbing of secrets and personal data from recorded interactions
+// Values are replaced with fixed placeholders, so a request made with real
+// values in replay mode still matches what was recorded
+// ==============================================================================
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Placeholders used in place of scrubbed values
+const (
+	PlaceholderEmail  = "user@example.com"
+	PlaceholderPhone  = "+15550000000"
+	PlaceholderSecret = "[SECRET]"
+)
+
+// Headers which change on every request, so are not recorded
+var volatileHeaders = []string{
+	"Authorization",
+	"X-Ms-Date",
+	"X-Ms-Content-Sha256",
+	"Repeatability-Request-Id",
+	"Repeatability-First-Sent",
+	"Traceparent",
+	"Tracestate",
+	"Date",
+}
+
+// Body fields which change on every request, so are removed before matching
+var volatileFields = map[string]bool{
+	"repeatabilityRequestId": true,
+	"repeatabilityFirstSent": true,
+}
+
+var (
+	emailPattern     = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	phonePattern     = regexp.MustCompile(`\+[0-9]{7,15}`)
+	accessKeyPattern = regexp.MustCompile(`(?i)(accesskey=)[^;&"\s]+`)
+)
+
+type scrubber struct {
+	secrets []string
+}
+
+func newScrubber(secrets []string) *scrubber {
+	s := &scrubber{}
+
+	for _, secret := range secrets {
+		if secret != "" {
+			s.secrets = append(s.secrets, secret)
+		}
+	}
+
+	return s
+}
+
+// scrub replaces secrets, email addresses, phone numbers and access keys in a string
+func (s *scrubber) scrub(v string) string {
+	for _, secret := range s.secrets {
+		v = strings.ReplaceAll(v, secret, PlaceholderSecret)
+	}
+
+	v = accessKeyPattern.ReplaceAllString(v, "${1}"+PlaceholderSecret)
+	v = emailPattern.ReplaceAllString(v, PlaceholderEmail)
+
+	return phonePattern.ReplaceAllString(v, PlaceholderPhone)
+}
+
+// headers returns a scrubbed copy of the headers, without volatile headers
+func (s *scrubber) headers(h http.Header) http.Header {
+	out := http.Header{}
+
+	for name, values := range h {
+		for _, v := range values {
+			out.Add(name, s.scrub(v))
+		}
+	}
+
+	for _, name := range volatileHeaders {
+		out.Del(name)
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	return out
+}
+
+// body scrubs a body, JSON is normalised with sorted keys and volatile fields removed
+func (s *scrubber) body(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+
+	var doc any
+	if json.Unmarshal(data, &doc) != nil {
+		return s.scrub(string(data))
+	}
+
+	normalised, err := json.Marshal(s.json(doc))
+	if err != nil {
+		return s.scrub(string(data))
+	}
+
+	return string(normalised)
+}
+
+func (s *scrubber) json(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			if volatileFields[k] {
+				delete(val, k)
+
+				continue
+			}
+
+			val[k] = s.json(child)
+		}
+	case []any:
+		for i, child := range val {
+			val[i] = s.json(child)
+		}
+	case string:
+		return s.scrub(val)
+	}
+
+	return v
+}
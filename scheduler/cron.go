@@ -0,0 +1,158 @@
+package scheduler
+
+// ==============================================================================
+// Minimal cron expression parser, supporting the standard five fields
+//   minute hour day-of-month month day-of-week
+// With *, lists, ranges and steps, plus @hourly, @daily, @weekly, @monthly
+// & @yearly. Month and day names are not supported, use numbers instead
+// ==============================================================================
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronSearch limits how far ahead Next looks, impossible dates like 30th Feb never match
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+// Cron is a parsed cron expression
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // Bit sets of allowed values
+	domStar, dowStar              bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a five field cron expression or descriptor such as @daily
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if desc, found := cronDescriptors[strings.ToLower(expr)]; found {
+		expr = desc
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields: %q", expr)
+	}
+
+	c := &Cron{}
+	bounds := [5][2]uint{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %s", expr, err)
+		}
+
+		*sets[i] = set
+	}
+
+	// Sunday can be 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+
+	return c, nil
+}
+
+func parseCronField(field string, lowest, highest uint) (uint64, error) {
+	set := uint64(0)
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := uint(1)
+
+		if hasStep {
+			n, err := strconv.ParseUint(stepPart, 10, 8)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+
+			step = uint(n)
+		}
+
+		low, high := lowest, highest
+
+		if rangePart != "*" {
+			lowStr, highStr, isRange := strings.Cut(rangePart, "-")
+
+			n, err := strconv.ParseUint(lowStr, 10, 8)
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+
+			low, high = uint(n), uint(n)
+
+			if isRange {
+				n, err = strconv.ParseUint(highStr, 10, 8)
+				if err != nil {
+					return 0, fmt.Errorf("bad range %q", part)
+				}
+
+				high = uint(n)
+			} else if hasStep {
+				high = highest
+			}
+		}
+
+		if low < lowest || high > highest || low > high {
+			return 0, fmt.Errorf("value out of range %q", part)
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+// Next returns the first time matching the expression strictly after t, in the location of t
+// A zero time is returned if no match is found
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxCronSearch)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows cron rules, if both day fields are restricted either can match
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
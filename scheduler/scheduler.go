@@ -0,0 +1,492 @@
+package scheduler
+
+// ==============================================================================
+// Scheduled and recurring sending of email and SMS
+// ACS has no way to send at a given time, so jobs are persisted to a store
+// and dispatched in the background when due. Recurring jobs use cron
+// expressions evaluated in the job's time zone, and SMS jobs can have quiet
+// hours during which nothing is sent
+// ==============================================================================
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+
+	"github.com/google/uuid"
+)
+
+const defaultMaxAttempts = 3
+const defaultRetryDelay = time.Minute
+const defaultPollInterval = time.Minute
+
+// Kind is the type of message a job sends
+type Kind string
+
+// State of a job
+type State string
+
+const (
+	KindEmail Kind = "email"
+	KindSMS   Kind = "sms"
+
+	StateScheduled State = "scheduled" // Waiting for the next run
+	StateDone      State = "done"      // One off job which has been sent
+	StateFailed    State = "failed"    // One off job which failed, see LastError
+	StateCancelled State = "cancelled"
+)
+
+// ErrNotScheduled is returned when cancelling a job which is no longer scheduled
+var ErrNotScheduled = errors.New("job is not scheduled")
+
+// Schedule says when a job runs, set either SendAt or Cron
+type Schedule struct {
+	SendAt   time.Time `json:"sendAt,omitempty"`   // Send once at this time
+	Cron     string    `json:"cron,omitempty"`     // Recurring cron expression, e.g. "30 9 * * 1-5"
+	TimeZone string    `json:"timeZone,omitempty"` // IANA time zone for Cron and QuietHours, defaults to UTC
+
+	// Quiet hours for SMS, sends due inside this window are held until it ends
+	QuietHours *QuietHours `json:"quietHours,omitempty"`
+}
+
+// QuietHours is a daily window, times are "HH:MM" in the schedule's time zone
+// The window can wrap past midnight, e.g. Start "21:00" and End "08:00"
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Job is a scheduled email or SMS
+type Job struct {
+	ID       string        `json:"id"`
+	Kind     Kind          `json:"kind"`
+	Email    *client.Email `json:"email,omitempty"`
+	SMS      *client.SMS   `json:"sms,omitempty"`
+	Schedule Schedule      `json:"schedule"`
+
+	State   State     `json:"state"`
+	NextRun time.Time `json:"nextRun"`
+
+	// Identifies the current run, retries of a run reuse it as the repeatability ID
+	RunID        string    `json:"runId,omitempty"`
+	RunFirstSent string    `json:"runFirstSent,omitempty"`
+	RunAttempts  int       `json:"runAttempts"`
+	Runs         int       `json:"runs"` // Number of completed runs
+	LastRun      time.Time `json:"lastRun,omitempty"`
+	LastError    string    `json:"lastError,omitempty"`
+	MessageID    string    `json:"messageId,omitempty"` // ACS message ID from the last successful run
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Options for the scheduler, zero values use the defaults
+type Options struct {
+	MaxAttempts  int           // Attempts per run for retryable errors, defaults to 3
+	RetryDelay   time.Duration // Delay between attempts, defaults to 1m
+	PollInterval time.Duration // Maximum time between checks of the store, defaults to 1m
+
+	// OnRun is called after every run of a job, err is nil on success
+	OnRun func(j *Job, err error)
+}
+
+// Scheduler dispatches jobs when they are due
+type Scheduler struct {
+	client *client.Client
+	store  Store
+	opts   Options
+
+	wake   chan struct{}
+	mu     sync.Mutex // Serialises changes to jobs between the API and the dispatcher
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a scheduler, call Start to begin dispatching jobs
+func New(c *client.Client, store Store, opts Options) *Scheduler {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	return &Scheduler{
+		client: c,
+		store:  store,
+		opts:   opts,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// ScheduleEmail schedules an email, returning the job ID
+// The email is prepared first, so any transforms are run before it's stored
+func (s *Scheduler) ScheduleEmail(ctx context.Context, e *client.Email, when Schedule) (string, error) {
+	// A prepared copy is stored, without the transforms so they don't run again when it's sent
+	e = e.Clone()
+
+	err := e.Prepare()
+	if err != nil {
+		return "", fmt.Errorf("error preparing email: %s", err)
+	}
+
+	e.Transforms = nil
+
+	return s.schedule(ctx, &Job{Kind: KindEmail, Email: e, Schedule: when})
+}
+
+// ScheduleSMS schedules a SMS, returning the job ID
+func (s *Scheduler) ScheduleSMS(ctx context.Context, sms *client.SMS, when Schedule) (string, error) {
+	return s.schedule(ctx, &Job{Kind: KindSMS, SMS: sms, Schedule: when})
+}
+
+// Cancel stops a job from running again
+func (s *Scheduler) Cancel(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, err := s.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if j.State != StateScheduled {
+		return ErrNotScheduled
+	}
+
+	j.State = StateCancelled
+
+	return s.store.Save(ctx, j)
+}
+
+// Get returns a job by ID
+func (s *Scheduler) Get(ctx context.Context, id string) (*Job, error) {
+	return s.store.Get(ctx, id)
+}
+
+// Pending lists all scheduled jobs, ordered by when they will next run
+func (s *Scheduler) Pending(ctx context.Context) ([]*Job, error) {
+	return s.store.ListScheduled(ctx)
+}
+
+// Start begins dispatching jobs in the background, including any already in the store
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		s.loop(ctx)
+	}()
+}
+
+// Stop stops dispatching and waits for any in progress send to finish
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+
+	s.cancel()
+	<-s.done
+}
+
+func (s *Scheduler) schedule(ctx context.Context, j *Job) (string, error) {
+	if j.Schedule.SendAt.IsZero() == (j.Schedule.Cron == "") {
+		return "", fmt.Errorf("schedule must have either SendAt or Cron")
+	}
+
+	now := time.Now().UTC()
+	j.ID = uuid.New().String()
+	j.State = StateScheduled
+	j.CreatedAt = now
+
+	next, err := j.Schedule.next(j.Kind, now, true)
+	if err != nil {
+		return "", err
+	}
+
+	j.NextRun = next
+
+	err = s.store.Save(ctx, j)
+	if err != nil {
+		return "", fmt.Errorf("error saving job: %s", err)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return j.ID, nil
+}
+
+// loop runs due jobs, then sleeps until the next job is due or it is woken
+func (s *Scheduler) loop(ctx context.Context) {
+	for {
+		sleep := s.opts.PollInterval
+
+		jobs, err := s.store.ListScheduled(ctx)
+
+		// Store errors are assumed to be transient, the next poll will try again
+		if err == nil {
+			for _, j := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if wait := time.Until(j.NextRun); wait > 0 {
+					if wait < sleep {
+						sleep = wait
+					}
+
+					break
+				}
+
+				s.run(ctx, j.ID)
+			}
+		}
+
+		timer := time.NewTimer(sleep)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// run makes one attempt at sending a job, and works out when it runs next
+func (s *Scheduler) run(ctx context.Context, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Re-read the job, it may have been cancelled
+	j, err := s.store.Get(ctx, id)
+	if err != nil || j.State != StateScheduled {
+		return
+	}
+
+	now := time.Now().UTC()
+	loc := j.Schedule.location()
+
+	// Held by quiet hours, possibly because we were down when it was due
+	if j.Kind == KindSMS && j.Schedule.QuietHours != nil {
+		if end, quiet := j.Schedule.QuietHours.until(now.In(loc)); quiet {
+			j.NextRun = end.UTC()
+			_ = s.store.Save(ctx, j)
+
+			return
+		}
+	}
+
+	if j.RunID == "" {
+		j.RunID = uuid.New().String()
+		j.RunFirstSent = now.Format(http.TimeFormat)
+		j.RunAttempts = 0
+	}
+
+	j.RunAttempts++
+
+	// Record the attempt before sending, so a crash doesn't lose the run ID
+	if err := s.store.Save(ctx, j); err != nil {
+		return
+	}
+
+	messageID, sendErr := s.send(client.WithAttempt(ctx, j.RunAttempts), j)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if sendErr != nil && client.IsRetryable(sendErr) && j.RunAttempts < s.opts.MaxAttempts {
+		j.LastError = sendErr.Error()
+		j.NextRun = now.Add(s.opts.RetryDelay)
+		_ = s.store.Save(ctx, j)
+
+		return
+	}
+
+	j.LastRun = now
+	j.RunID = ""
+	j.RunFirstSent = ""
+	j.Runs++
+
+	if sendErr == nil {
+		j.MessageID = messageID
+		j.LastError = ""
+	} else {
+		j.LastError = sendErr.Error()
+	}
+
+	if j.Schedule.Cron == "" {
+		j.State = StateDone
+		if sendErr != nil {
+			j.State = StateFailed
+		}
+	} else {
+		// Recurring jobs carry on after a failure, missed runs are skipped
+		j.NextRun, err = j.Schedule.next(j.Kind, now, false)
+		if err != nil || j.NextRun.IsZero() {
+			j.State = StateDone
+		}
+	}
+
+	_ = s.store.Save(ctx, j)
+
+	if s.opts.OnRun != nil {
+		s.opts.OnRun(j, sendErr)
+	}
+}
+
+// send sends the message, using the run ID for repeatability so retries aren't delivered twice
+func (s *Scheduler) send(ctx context.Context, j *Job) (string, error) {
+	switch j.Kind {
+	case KindEmail:
+		e := *j.Email
+		e.RepeatabilityRequestID = j.RunID
+		e.RepeatabilityFirstSent = j.RunFirstSent
+
+		return s.client.SendEmailContext(ctx, &e)
+	case KindSMS:
+		// Each recurring run is a new message, so every recipient gets an ID derived from the run
+		sms := *j.SMS
+		sms.SMSRecipients = make([]client.SMSRecipient, len(j.SMS.SMSRecipients))
+		runID := uuid.MustParse(j.RunID)
+
+		for i, r := range j.SMS.SMSRecipients {
+			r.RepeatabilityRequestID = uuid.NewSHA1(runID, []byte(strconv.Itoa(i))).String()
+			r.RepeatabilityFirstSent = j.RunFirstSent
+			sms.SMSRecipients[i] = r
+		}
+
+		resp, err := s.client.SendSingleSMSContext(ctx, &sms)
+		if err != nil {
+			return "", err
+		}
+
+		if err := resp.Err(); err != nil {
+			return "", err
+		}
+
+		return resp.MessageID, nil
+	default:
+		return "", errors.New("unknown job kind: " + string(j.Kind))
+	}
+}
+
+func (sch Schedule) location() *time.Location {
+	if sch.TimeZone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(sch.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// next works out the next run after the given time, moving it out of any quiet hours
+func (sch Schedule) next(kind Kind, after time.Time, first bool) (time.Time, error) {
+	if sch.TimeZone != "" {
+		if _, err := time.LoadLocation(sch.TimeZone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time zone: %s", err)
+		}
+	}
+
+	loc := sch.location()
+	next := sch.SendAt
+
+	if sch.Cron != "" {
+		cron, err := ParseCron(sch.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		next = cron.Next(after.In(loc))
+	} else if !first {
+		return time.Time{}, nil
+	}
+
+	if kind == KindSMS && sch.QuietHours != nil {
+		if _, _, err := sch.QuietHours.parse(); err != nil {
+			return time.Time{}, err
+		}
+
+		if end, quiet := sch.QuietHours.until(next.In(loc)); quiet {
+			next = end
+		}
+	}
+
+	return next.UTC(), nil
+}
+
+// parse returns the start and end of quiet hours, as minutes after midnight
+func (q *QuietHours) parse() (start, end int, err error) {
+	toMinutes := func(hhmm string) (int, error) {
+		h, m, found := strings.Cut(hhmm, ":")
+
+		hour, err1 := strconv.Atoi(h)
+		minute, err2 := strconv.Atoi(m)
+
+		if !found || err1 != nil || err2 != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+			return 0, fmt.Errorf("invalid quiet hours time %q, use HH:MM", hhmm)
+		}
+
+		return hour*60 + minute, nil
+	}
+
+	if start, err = toMinutes(q.Start); err != nil {
+		return
+	}
+
+	end, err = toMinutes(q.End)
+
+	return
+}
+
+// until checks if t is within quiet hours, and if so returns when they end
+func (q *QuietHours) until(t time.Time) (time.Time, bool) {
+	start, end, err := q.parse()
+	if err != nil || start == end {
+		return time.Time{}, false
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	endToday := time.Date(t.Year(), t.Month(), t.Day(), end/60, end%60, 0, 0, t.Location())
+
+	if start < end {
+		if now >= start && now < end {
+			return endToday, true
+		}
+
+		return time.Time{}, false
+	}
+
+	// Window wraps midnight
+	if now >= start {
+		return endToday.AddDate(0, 0, 1), true
+	}
+
+	if now < end {
+		return endToday, true
+	}
+
+	return time.Time{}, false
+}
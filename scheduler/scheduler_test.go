@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		expr     string
+		after    string
+		expected string
+	}{
+		{"30 9 * * 1-5", "2026-10-16T10:00:00Z", "2026-10-19T09:30:00Z"}, // Friday after 9:30, so Monday
+		{"*/15 * * * *", "2026-10-16T10:07:30Z", "2026-10-16T10:15:00Z"},
+		{"@daily", "2026-12-31T23:59:00Z", "2027-01-01T00:00:00Z"},
+		{"0 12 1 * 0", "2026-10-02T00:00:00Z", "2026-10-04T12:00:00Z"}, // Sunday comes before the 1st
+		{"0 0 29 2 *", "2026-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		cron, err := ParseCron(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		after, _ := time.Parse(time.RFC3339, test.after)
+		if next := cron.Next(after).Format(time.RFC3339); next != test.expected {
+			t.Errorf("%s after %s: expected %s, got %s", test.expr, test.after, test.expected, next)
+		}
+	}
+
+	for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "1-x * * * *"} {
+		if _, err := ParseCron(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	sch := Schedule{
+		Cron:       "0 22 * * *",
+		TimeZone:   "Europe/London",
+		QuietHours: &QuietHours{Start: "21:00", End: "08:30"},
+	}
+
+	next, err := sch.next(KindSMS, time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 22:00 in London is quiet, so held until 08:30 the next day, which is 07:30 UTC in summer
+	if expected := time.Date(2026, 7, 2, 7, 30, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, next)
+	}
+
+	// Quiet hours don't apply to email
+	next, _ = sch.next(KindEmail, time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC), true)
+	if expected := time.Date(2026, 7, 1, 21, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, next)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(client.SMSSendResponse{Value: []client.SMSSendResponseItem{{MessageID: "sms-id", Successful: true}}})
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+	runs := make(chan *Job, 1)
+	s := New(client.New(base64.StdEncoding.EncodeToString([]byte("key")), srv.URL), store, Options{
+		OnRun: func(j *Job, err error) { runs <- j },
+	})
+
+	ctx := context.Background()
+
+	id, err := s.ScheduleSMS(ctx, client.NewSMS("+15550000000", "+15551111111", "Reminder"), Schedule{SendAt: time.Now().Add(50 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later, _ := s.ScheduleSMS(ctx, client.NewSMS("+15550000000", "+15551111111", "Later"), Schedule{Cron: "@yearly"})
+
+	pending, _ := s.Pending(ctx)
+	if len(pending) != 2 || pending[0].ID != id {
+		t.Fatalf("Expected 2 pending jobs, got %d", len(pending))
+	}
+
+	s.Start(ctx)
+	defer s.Stop()
+
+	select {
+	case j := <-runs:
+		if j.ID != id || j.State != StateDone || j.MessageID != "sms-id" {
+			t.Errorf("Unexpected job: %+v", j)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Job did not run")
+	}
+
+	if err := s.Cancel(ctx, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Cancel(ctx, id); err != ErrNotScheduled {
+		t.Error("Expected ErrNotScheduled, got:", err)
+	}
+
+	pending, _ = s.Pending(ctx)
+	if len(pending) != 0 {
+		t.Errorf("Expected no pending jobs, got %d", len(pending))
+	}
+}
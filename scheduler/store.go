@@ -0,0 +1,208 @@
+package scheduler
+
+// ==============================================================================
+// Storage for scheduled jobs, the Store interface can be implemented for
+// databases, a file based store and an in memory store are provided
+// ==============================================================================
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const fileMode = 0o600
+const dirMode = 0o750
+
+// ErrNotFound is returned by a Store when a job doesn't exist
+var ErrNotFound = errors.New("job not found")
+
+// Store persists scheduled jobs, implementations must be safe for concurrent use
+type Store interface {
+	// Save inserts or updates a job, it must be durable when Save returns
+	Save(ctx context.Context, j *Job) error
+
+	// Get returns the job with the given ID, or ErrNotFound
+	Get(ctx context.Context, id string) (*Job, error)
+
+	// ListScheduled returns all jobs in the scheduled state, ordered by next run time
+	ListScheduled(ctx context.Context) ([]*Job, error)
+}
+
+// MemoryStore keeps jobs in memory, it's not durable so is only useful for testing
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewMemoryStore creates an empty in memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[string]*Job{}}
+}
+
+// Save stores a copy of the job
+func (s *MemoryStore) Save(_ context.Context, j *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp := *j
+	s.jobs[j.ID] = &cp
+
+	return nil
+}
+
+// Get returns a copy of the job
+func (s *MemoryStore) Get(_ context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, found := s.jobs[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	cp := *j
+
+	return &cp, nil
+}
+
+// ListScheduled returns copies of all scheduled jobs
+func (s *MemoryStore) ListScheduled(_ context.Context) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []*Job{}
+
+	for _, j := range s.jobs {
+		if j.State == StateScheduled {
+			cp := *j
+			jobs = append(jobs, &cp)
+		}
+	}
+
+	sortByNextRun(jobs)
+
+	return jobs, nil
+}
+
+// FileStore keeps each job as a JSON file in a directory
+// Files are replaced atomically, so a crash never leaves a partial job
+type FileStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileStore creates a store in the given directory, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("error creating store directory: %s", err)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes the job to a temporary file, syncs it, then renames it into place
+func (s *FileStore) Save(_ context.Context, j *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(j.ID))
+}
+
+// Get reads a job from its file
+func (s *FileStore) Get(_ context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(s.path(id))
+}
+
+// ListScheduled reads all jobs and returns those which are scheduled
+func (s *FileStore) ListScheduled(_ context.Context) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []*Job{}
+
+	for _, file := range files {
+		j, err := s.read(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if j.State == StateScheduled {
+			jobs = append(jobs, j)
+		}
+	}
+
+	sortByNextRun(jobs)
+
+	return jobs, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(filepath.Base(id), ".", "_")+".json")
+}
+
+func (s *FileStore) read(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Job{}
+
+	err = json.Unmarshal(data, j)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	return j, nil
+}
+
+func sortByNextRun(jobs []*Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].NextRun.Before(jobs[j].NextRun)
+	})
+}
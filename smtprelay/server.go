@@ -0,0 +1,735 @@
+package smtprelay
+
+// ==============================================================================
+// SMTP relay server, for apps and appliances which can only send email with
+// SMTP. Messages are converted with client.EmailFromMIME and sent, ACS
+// failures are returned as SMTP reply codes so the sender can retry or give up
+// ==============================================================================
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+const defaultAddr = "localhost:2525"
+const defaultMaxMessageSize = 10 * 1024 * 1024
+const defaultMaxRecipients = 50
+const defaultTimeout = 5 * time.Minute
+const sendTimeout = time.Minute
+
+// maxReplyText limits the text from an error in a reply, reply lines can be at most 512 bytes
+const maxReplyText = 200
+
+// Options configures the relay
+type Options struct {
+	Addr              string            // Address to listen on, defaults to localhost:2525
+	Domain            string            // Name given in the greeting, defaults to the hostname
+	TLSConfig         *tls.Config       // Enables STARTTLS, when set AUTH is only allowed after STARTTLS
+	Users             map[string]string // Usernames and passwords, when set clients must AUTH
+	AllowedSenders    []string          // Addresses or @domains allowed in MAIL FROM and the From header, empty allows all
+	AllowedRecipients []string          // Addresses or @domains allowed to receive, empty allows all
+	MaxMessageSize    int               // In bytes, defaults to 10MB
+	MaxRecipients     int               // Per message, defaults to 50
+	Timeout           time.Duration     // Idle timeout for each command, defaults to 5 minutes
+
+	// Sender replaces the sender of every message, as ACS can only send from verified
+	// domains. The original sender becomes the reply to address, unless one is set
+	Sender string
+
+	// AllowOpenRelay lets the relay start with no Users and no AllowedSenders, so anyone
+	// who can connect can send. Only set it when the network is locked down some other way
+	AllowOpenRelay bool
+
+	// OnMessage is called after each message is relayed, or fails
+	OnMessage func(r Result)
+}
+
+// Result of relaying a message
+type Result struct {
+	Remote     string // Address of the SMTP client
+	User       string // Authenticated user, if any
+	From       string
+	Recipients []string
+	MessageID  string
+	Err        error
+
+	// Unmapped are parts of the message which couldn't be sent, such as calendar invites
+	Unmapped []client.UnmappedPart
+}
+
+// Server is a SMTP server which relays messages to ACS
+type Server struct {
+	client *client.Client
+	opts   Options
+	wg     sync.WaitGroup
+}
+
+// reply is a SMTP reply code, and enhanced status code with message
+type reply struct {
+	code    int
+	message string
+}
+
+var (
+	replyOK            = reply{250, "2.0.0 OK"}
+	replyBadSequence   = reply{503, "5.5.1 Bad sequence of commands"}
+	replyAuthRequired  = reply{530, "5.7.0 Authentication required"}
+	replyTLSRequired   = reply{538, "5.7.11 Encryption required for requested authentication mechanism"}
+	replyAuthFailed    = reply{535, "5.7.8 Authentication credentials invalid"}
+	replySyntax        = reply{501, "5.5.4 Syntax error in parameters"}
+	replyNotAllowed    = reply{550, "5.7.1 Address not allowed"}
+	replyTooBig        = reply{552, "5.3.4 Message too big"}
+	replyTooMany       = reply{452, "4.5.3 Too many recipients"}
+	replyNotRecognised = reply{500, "5.5.2 Command not recognised"}
+)
+
+// ErrOpenRelay is returned when starting a relay with no Users or AllowedSenders,
+// unless AllowOpenRelay is set
+var ErrOpenRelay = errors.New("relay has no users or allowed senders, set AllowOpenRelay to run an open relay")
+
+// errSenderNotAllowed is returned when the From header isn't an allowed sender
+var errSenderNotAllowed = errors.New("sender in the From header is not allowed")
+
+// New creates a relay which sends with the given client
+func New(acsClient *client.Client, opts Options) *Server {
+	if opts.Addr == "" {
+		opts.Addr = defaultAddr
+	}
+
+	if opts.Domain == "" {
+		opts.Domain, _ = os.Hostname()
+	}
+
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = defaultMaxMessageSize
+	}
+
+	if opts.MaxRecipients <= 0 {
+		opts.MaxRecipients = defaultMaxRecipients
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	return &Server{client: acsClient, opts: opts}
+}
+
+// ListenAndServe listens on the configured address, until the context is cancelled
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening: %s", err)
+	}
+
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on the listener, until the context is cancelled.
+// It waits for open sessions to finish before returning
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	if err := s.check(); err != nil {
+		ln.Close()
+
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	defer s.wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("error accepting connection: %s", err)
+		}
+
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+
+			sess := &session{server: s, conn: conn}
+			sess.serve(ctx)
+		}()
+	}
+}
+
+// check refuses to run an open relay, unless it's been asked for
+func (s *Server) check() error {
+	if len(s.opts.Users) == 0 && len(s.opts.AllowedSenders) == 0 && !s.opts.AllowOpenRelay {
+		return ErrOpenRelay
+	}
+
+	return nil
+}
+
+// session is a single SMTP connection
+type session struct {
+	server *Server
+	conn   net.Conn
+	text   *textproto.Conn
+
+	helo  bool
+	tls   bool
+	user  string
+	from  string
+	rcpts []string
+}
+
+func (ss *session) serve(ctx context.Context) {
+	defer ss.conn.Close()
+
+	// Close the connection on shutdown, which ends the session
+	raw := ss.conn
+	stop := context.AfterFunc(ctx, func() { raw.Close() })
+	defer stop()
+
+	ss.text = textproto.NewConn(ss.conn)
+	ss.reply(reply{220, ss.server.opts.Domain + " ESMTP ACS relay ready"})
+
+	for {
+		_ = ss.conn.SetDeadline(time.Now().Add(ss.server.opts.Timeout))
+
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			ss.hello(strings.ToUpper(verb) == "EHLO")
+		case "STARTTLS":
+			if !ss.startTLS() {
+				return
+			}
+		case "AUTH":
+			ss.auth(arg)
+		case "MAIL":
+			ss.mail(arg)
+		case "RCPT":
+			ss.rcpt(arg)
+		case "DATA":
+			ss.data(ctx)
+		case "RSET":
+			ss.reset()
+			ss.reply(replyOK)
+		case "NOOP":
+			ss.reply(replyOK)
+		case "VRFY":
+			ss.reply(reply{252, "2.5.0 Cannot verify user"})
+		case "QUIT":
+			ss.reply(reply{221, "2.0.0 Bye"})
+
+			return
+		default:
+			ss.reply(replyNotRecognised)
+		}
+	}
+}
+
+func (ss *session) reply(r reply, extra ...string) {
+	lines := append([]string{r.message}, extra...)
+
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+
+		_ = ss.text.PrintfLine("%d%s%s", r.code, sep, line)
+	}
+}
+
+func (ss *session) reset() {
+	ss.from = ""
+	ss.rcpts = nil
+}
+
+// authAllowed is true when AUTH can be used, it needs TLS if STARTTLS is available
+func (ss *session) authAllowed() bool {
+	return len(ss.server.opts.Users) > 0 && (ss.tls || ss.server.opts.TLSConfig == nil)
+}
+
+func (ss *session) hello(extended bool) {
+	ss.reset()
+	ss.helo = true
+
+	if !extended {
+		ss.reply(reply{250, ss.server.opts.Domain})
+
+		return
+	}
+
+	extensions := []string{"8BITMIME", "SIZE " + strconv.Itoa(ss.server.opts.MaxMessageSize)}
+
+	if ss.server.opts.TLSConfig != nil && !ss.tls {
+		extensions = append(extensions, "STARTTLS")
+	}
+
+	if ss.authAllowed() && ss.user == "" {
+		extensions = append(extensions, "AUTH PLAIN LOGIN")
+	}
+
+	ss.reply(reply{250, ss.server.opts.Domain}, extensions...)
+}
+
+// startTLS upgrades the connection, returning false if the session must end
+func (ss *session) startTLS() bool {
+	if ss.server.opts.TLSConfig == nil || ss.tls {
+		ss.reply(replyNotRecognised)
+
+		return true
+	}
+
+	ss.reply(reply{220, "2.0.0 Ready to start TLS"})
+
+	tlsConn := tls.Server(ss.conn, ss.server.opts.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return false
+	}
+
+	// The client must start again after the handshake
+	ss.conn = tlsConn
+	ss.text = textproto.NewConn(tlsConn)
+	ss.tls = true
+	ss.helo = false
+	ss.reset()
+
+	return true
+}
+
+func (ss *session) auth(arg string) {
+	switch {
+	case len(ss.server.opts.Users) == 0:
+		ss.reply(replyNotRecognised)
+
+		return
+	case !ss.helo || ss.user != "" || ss.from != "":
+		ss.reply(replyBadSequence)
+
+		return
+	case !ss.authAllowed():
+		ss.reply(replyTLSRequired)
+
+		return
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+
+	var user, password string
+
+	var err error
+
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		user, password, err = ss.authPlain(initial)
+	case "LOGIN":
+		user, password, err = ss.authLogin(initial)
+	default:
+		ss.reply(reply{504, "5.5.4 Unrecognised authentication mechanism"})
+
+		return
+	}
+
+	if err != nil {
+		ss.reply(replySyntax)
+
+		return
+	}
+
+	expected, found := ss.server.opts.Users[user]
+	if !found || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+		ss.reply(replyAuthFailed)
+
+		return
+	}
+
+	ss.user = user
+	ss.reply(reply{235, "2.7.0 Authentication successful"})
+}
+
+// challenge sends a base64 challenge, and returns the decoded response
+func (ss *session) challenge(prompt string) (string, error) {
+	ss.reply(reply{334, base64.StdEncoding.EncodeToString([]byte(prompt))})
+
+	line, err := ss.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+
+	if line == "*" {
+		return "", errors.New("authentication cancelled")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(line)
+
+	return string(data), err
+}
+
+func (ss *session) authPlain(initial string) (string, string, error) {
+	response := ""
+
+	var err error
+
+	if initial == "" {
+		response, err = ss.challenge("")
+	} else {
+		var data []byte
+
+		data, err = base64.StdEncoding.DecodeString(initial)
+		response = string(data)
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	// The response is authorisation identity, user and password separated by NULs
+	parts := strings.Split(response, "\x00")
+	if len(parts) != 3 {
+		return "", "", errors.New("invalid PLAIN response")
+	}
+
+	return parts[1], parts[2], nil
+}
+
+func (ss *session) authLogin(initial string) (string, string, error) {
+	user := ""
+
+	var err error
+
+	if initial == "" {
+		user, err = ss.challenge("Username:")
+	} else {
+		var data []byte
+
+		data, err = base64.StdEncoding.DecodeString(initial)
+		user = string(data)
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	password, err := ss.challenge("Password:")
+
+	return user, password, err
+}
+
+func (ss *session) mail(arg string) {
+	if !ss.helo || ss.from != "" {
+		ss.reply(replyBadSequence)
+
+		return
+	}
+
+	if len(ss.server.opts.Users) > 0 && ss.user == "" {
+		ss.reply(replyAuthRequired)
+
+		return
+	}
+
+	address, params, ok := parsePath(arg, "FROM:")
+	if !ok || address == "" {
+		ss.reply(replySyntax)
+
+		return
+	}
+
+	for _, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		if size, err := strconv.Atoi(value); strings.EqualFold(key, "SIZE") && err == nil && size > ss.server.opts.MaxMessageSize {
+			ss.reply(replyTooBig)
+
+			return
+		}
+	}
+
+	if !allowed(ss.server.opts.AllowedSenders, address) {
+		ss.reply(replyNotAllowed)
+
+		return
+	}
+
+	ss.from = address
+	ss.reply(reply{250, "2.1.0 OK"})
+}
+
+func (ss *session) rcpt(arg string) {
+	if ss.from == "" {
+		ss.reply(replyBadSequence)
+
+		return
+	}
+
+	address, _, ok := parsePath(arg, "TO:")
+	if !ok || address == "" {
+		ss.reply(replySyntax)
+
+		return
+	}
+
+	if len(ss.rcpts) >= ss.server.opts.MaxRecipients {
+		ss.reply(replyTooMany)
+
+		return
+	}
+
+	if !allowed(ss.server.opts.AllowedRecipients, address) {
+		ss.reply(replyNotAllowed)
+
+		return
+	}
+
+	ss.rcpts = append(ss.rcpts, address)
+	ss.reply(reply{250, "2.1.5 OK"})
+}
+
+func (ss *session) data(ctx context.Context) {
+	if len(ss.rcpts) == 0 {
+		ss.reply(replyBadSequence)
+
+		return
+	}
+
+	ss.reply(reply{354, "Start mail input; end with <CRLF>.<CRLF>"})
+
+	// Read one byte over the limit to detect messages which are too big
+	limit := int64(ss.server.opts.MaxMessageSize)
+	dot := ss.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(dot, limit+1))
+
+	if err != nil {
+		return
+	}
+
+	defer ss.reset()
+
+	if int64(len(data)) > limit {
+		_, _ = io.Copy(io.Discard, dot)
+		ss.reply(replyTooBig)
+
+		return
+	}
+
+	result := Result{
+		Remote:     ss.conn.RemoteAddr().String(),
+		User:       ss.user,
+		From:       ss.from,
+		Recipients: ss.rcpts,
+	}
+
+	ss.server.send(ctx, data, &result)
+
+	if ss.server.opts.OnMessage != nil {
+		ss.server.opts.OnMessage(result)
+	}
+
+	if result.Err != nil {
+		ss.reply(errorReply(result.Err))
+
+		return
+	}
+
+	ss.reply(reply{250, "2.0.0 OK queued as " + result.MessageID})
+}
+
+// send converts the message to an email and sends it with ACS
+func (s *Server) send(ctx context.Context, data []byte, result *Result) {
+	e, unmapped, err := client.EmailFromMIME(bytes.NewReader(data))
+	if err != nil {
+		result.Err = &messageError{err}
+
+		return
+	}
+
+	result.Unmapped = unmapped
+
+	if e.Sender == "" {
+		e.Sender = result.From
+	}
+
+	// The email is sent as the From header, so it must be allowed as well as MAIL FROM
+	if !allowed(s.opts.AllowedSenders, e.Sender) {
+		result.Err = errSenderNotAllowed
+
+		return
+	}
+
+	e.Recipients = envelopeRecipients(e.Recipients, result.Recipients)
+
+	if s.opts.Sender != "" {
+		if len(e.ReplyTo) == 0 {
+			e.AddReplyTo(e.Sender, "")
+		}
+
+		e.Sender = s.opts.Sender
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	result.MessageID, result.Err = s.client.SendEmailContext(ctx, e)
+}
+
+// envelopeRecipients decides who receives the message, which is the envelope recipients.
+// Those in the To or Cc headers are kept there, the rest become BCC
+func envelopeRecipients(headers client.Recipients, rcpts []string) client.Recipients {
+	find := func(list []client.Address, address string) *client.Address {
+		for i := range list {
+			if strings.EqualFold(list[i].Email, address) {
+				return &list[i]
+			}
+		}
+
+		return nil
+	}
+
+	recipients := client.Recipients{}
+
+	for _, rcpt := range rcpts {
+		if a := find(headers.To, rcpt); a != nil {
+			recipients.To = append(recipients.To, *a)
+		} else if a := find(headers.CC, rcpt); a != nil {
+			recipients.CC = append(recipients.CC, *a)
+		} else {
+			recipients.BCC = append(recipients.BCC, client.Address{Email: rcpt})
+		}
+	}
+
+	return recipients
+}
+
+// messageError is returned when a message can't be converted to an email
+type messageError struct {
+	err error
+}
+
+func (e *messageError) Error() string {
+	return e.err.Error()
+}
+
+// errorReply maps a failure to send to a SMTP reply. Failures which might work
+// later are temporary (4xx) so the sender retries, the rest are permanent (5xx)
+func errorReply(err error) reply {
+	if errors.Is(err, errSenderNotAllowed) {
+		return reply{550, "5.7.1 " + err.Error()}
+	}
+
+	msgErr := &messageError{}
+	if errors.As(err, &msgErr) {
+		return reply{554, "5.6.0 Invalid message: " + replyText(msgErr.Error())}
+	}
+
+	apiErr := &client.APIError{}
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return reply{451, "4.7.0 Rate limited by ACS, try again later"}
+		case apiErr.StatusCode == http.StatusRequestEntityTooLarge:
+			return replyTooBig
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			// The relay is misconfigured, the sender should retry once it's fixed
+			return reply{451, "4.7.0 Relay not authorised with ACS"}
+		case apiErr.Retryable():
+			return reply{451, "4.3.0 ACS unavailable, try again later"}
+		default:
+			return reply{554, "5.6.0 Rejected by ACS: " + replyText(apiErr.Message)}
+		}
+	}
+
+	if errors.Is(err, client.ErrCircuitOpen) {
+		return reply{451, "4.3.0 ACS unavailable, try again later"}
+	}
+
+	// Network errors and timeouts
+	return reply{451, "4.4.1 Error sending to ACS, try again later"}
+}
+
+// replyText makes error text safe for a one line reply, the message from ACS can be a raw
+// response body, and a line break in it would end the reply early
+func replyText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+
+	if len(s) > maxReplyText {
+		s = strings.ToValidUTF8(s[:maxReplyText], "") + "..."
+	}
+
+	return s
+}
+
+// parsePath parses "FROM:<address> PARAMS" or "TO:<address>"
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+
+	fields := strings.Fields(strings.TrimSpace(arg[len(prefix):]))
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+
+	path := fields[0]
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", nil, false
+	}
+
+	return path[1 : len(path)-1], fields[1:], true
+}
+
+// allowed checks an address against a list of addresses and @domains
+func allowed(list []string, address string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	address = strings.ToLower(address)
+
+	for _, entry := range list {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		if entry == address || (strings.HasPrefix(entry, "@") && strings.HasSuffix(address, entry)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,314 @@
+package smtprelay
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/benc-uk/go-acs-client/client"
+)
+
+const testMessage = "From: Jenkins <jenkins@build.example.net>\r\n" +
+	"To: Alice <alice@example.net>\r\n" +
+	"Cc: bob@example.net\r\n" +
+	"Subject: =?utf-8?q?Build_=E2=9C=85_passed?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Build 42 passed\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p style=3D\"color: green\">Build 42 passed</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; name=\"log.txt\"\r\n" +
+	"Content-Disposition: attachment; filename=\"log.txt\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YWxsIGdvb2Q=\r\n" +
+	"--outer--\r\n"
+
+// startRelay runs a relay sending to a fake ACS API, which returns the given status
+func startRelay(t *testing.T, status *int32, received chan<- *client.Email, opts Options) string {
+	t.Helper()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &client.Email{}
+		_ = json.NewDecoder(r.Body).Decode(e)
+
+		code := int(atomic.LoadInt32(status))
+		if code == http.StatusAccepted {
+			received <- e
+
+			w.Header().Set("x-ms-request-id", "msg-id")
+		}
+
+		w.WriteHeader(code)
+
+		// A raw body with line breaks, as a proxy in front of ACS might return
+		if code == http.StatusBadRequest {
+			_, _ = w.Write([]byte("Bad request\r\n250 2.0.0 OK\r\n"))
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	relay := New(client.New(base64.StdEncoding.EncodeToString([]byte("secret")), api.URL), opts)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if err := relay.Serve(ctx, ln); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return ln.Addr().String()
+}
+
+func TestRelay(t *testing.T) {
+	// Borrow the test certificate from httptest
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+
+	status := int32(http.StatusAccepted)
+	received := make(chan *client.Email, 1)
+	results := make(chan Result, 1)
+
+	addr := startRelay(t, &status, received, Options{
+		Domain:            "relay.test",
+		TLSConfig:         tlsServer.TLS,
+		Users:             map[string]string{"jenkins": "s3cret"},
+		AllowedRecipients: []string{"@example.net"},
+		Sender:            "DoNotReply@acs.example.com",
+		OnMessage:         func(r Result) { results <- r },
+	})
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Hello("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// AUTH is not offered before STARTTLS
+	if ok, _ := c.Extension("AUTH"); ok {
+		t.Error("AUTH should need STARTTLS")
+	}
+
+	tlsConfig := tlsServer.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.ServerName = "example.com"
+
+	if err := c.StartTLS(tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Auth(smtp.PlainAuth("", "jenkins", "s3cret", "127.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Mail("jenkins@build.example.net"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Rcpt("eve@elsewhere.net"); smtpCode(err) != 550 {
+		t.Errorf("expected 550 for a recipient not allowed, got %v", err)
+	}
+
+	for _, rcpt := range []string{"alice@example.net", "bob@example.net", "carol@example.net"} {
+		if err := c.Rcpt(rcpt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = w.Write([]byte(testMessage))
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-received
+
+	if e.Sender != "DoNotReply@acs.example.com" || len(e.ReplyTo) != 1 || e.ReplyTo[0].Email != "jenkins@build.example.net" {
+		t.Errorf("unexpected sender %q or reply to %v", e.Sender, e.ReplyTo)
+	}
+
+	if len(e.Recipients.To) != 1 || e.Recipients.To[0].DisplayName != "Alice" ||
+		len(e.Recipients.CC) != 1 || len(e.Recipients.BCC) != 1 || e.Recipients.BCC[0].Email != "carol@example.net" {
+		t.Errorf("unexpected recipients %+v", e.Recipients)
+	}
+
+	if e.Content.Subject != "Build ✅ passed" {
+		t.Errorf("unexpected subject %q", e.Content.Subject)
+	}
+
+	if e.Content.PlainText != "Build 42 passed" || e.Content.HTML != `<p style="color: green">Build 42 passed</p>` {
+		t.Errorf("unexpected content %+v", e.Content)
+	}
+
+	if len(e.Attachments) != 1 || e.Attachments[0].Name != "log.txt" || e.Attachments[0].AttachmentType != "txt" ||
+		e.Attachments[0].Content != "YWxsIGdvb2Q=" {
+		t.Errorf("unexpected attachments %+v", e.Attachments)
+	}
+
+	if r := <-results; r.User != "jenkins" || r.MessageID != "msg-id" || r.Err != nil {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelayErrors(t *testing.T) {
+	status := int32(http.StatusServiceUnavailable)
+	received := make(chan *client.Email, 1)
+
+	addr := startRelay(t, &status, received, Options{
+		Users:          map[string]string{"jenkins": "s3cret"},
+		AllowedSenders: []string{"@build.example.net"},
+	})
+
+	auth := smtp.PlainAuth("", "jenkins", "s3cret", "127.0.0.1")
+	send := func(from string) error {
+		return smtp.SendMail(addr, auth, from, []string{"alice@example.net"}, []byte(testMessage))
+	}
+
+	if err := smtp.SendMail(addr, nil, "jenkins@build.example.net", []string{"alice@example.net"},
+		[]byte(testMessage)); smtpCode(err) != 530 {
+		t.Errorf("expected 530 without authentication, got %v", err)
+	}
+
+	auth = smtp.PlainAuth("", "jenkins", "wrong", "127.0.0.1")
+	if err := send("jenkins@build.example.net"); smtpCode(err) != 535 {
+		t.Errorf("expected 535 for bad credentials, got %v", err)
+	}
+
+	auth = smtp.PlainAuth("", "jenkins", "s3cret", "127.0.0.1")
+	if err := send("someone@elsewhere.net"); smtpCode(err) != 550 {
+		t.Errorf("expected 550 for a sender not allowed, got %v", err)
+	}
+
+	// The email is sent as the From header, so it's checked as well as the envelope sender
+	spoofed := strings.Replace(testMessage, "jenkins@build.example.net", "ceo@elsewhere.net", 1)
+	if err := smtp.SendMail(addr, auth, "jenkins@build.example.net", []string{"alice@example.net"},
+		[]byte(spoofed)); smtpCode(err) != 550 {
+		t.Errorf("expected 550 for a From header not allowed, got %v", err)
+	}
+
+	// ACS server errors are temporary, so the sender will retry
+	if err := send("jenkins@build.example.net"); smtpCode(err) != 451 {
+		t.Errorf("expected 451 for an ACS server error, got %v", err)
+	}
+
+	atomic.StoreInt32(&status, http.StatusBadRequest)
+
+	// The lines of the body are joined, so they can't end the reply early
+	err := send("jenkins@build.example.net")
+
+	protoErr := &textproto.Error{}
+	if !errors.As(err, &protoErr) || protoErr.Code != 554 || protoErr.Msg != "5.6.0 Rejected by ACS: Bad request 250 2.0.0 OK" {
+		t.Errorf("expected 554 for an email rejected by ACS, got %v", err)
+	}
+}
+
+func TestReplyText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "Bad request", want: "Bad request"},
+		{text: "Bad\r\nrequest\n\t\x00here ", want: "Bad request here"},
+		{text: strings.Repeat("é", 150), want: strings.Repeat("é", maxReplyText/2) + "..."},
+		{text: "x" + strings.Repeat("é", 150), want: "x" + strings.Repeat("é", maxReplyText/2-1) + "..."},
+	}
+
+	for _, test := range tests {
+		if got := replyText(test.text); got != test.want {
+			t.Errorf("replyText(%q) = %q, expected %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestOpenRelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acsClient := client.New(base64.StdEncoding.EncodeToString([]byte("secret")), "http://localhost")
+	if err := New(acsClient, Options{}).Serve(context.Background(), ln); !errors.Is(err, ErrOpenRelay) {
+		t.Errorf("expected a relay with no users or allowed senders to refuse to start, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := New(acsClient, Options{Addr: "127.0.0.1:0", AllowOpenRelay: true}).ListenAndServe(ctx); err != nil {
+		t.Errorf("expected an open relay when it's allowed, got %v", err)
+	}
+}
+
+func smtpCode(err error) int {
+	protoErr := &textproto.Error{}
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+
+	return 0
+}
+
+func TestAllowed(t *testing.T) {
+	list := []string{"Jenkins@Build.example.net", "@example.com"}
+
+	for address, want := range map[string]bool{
+		"jenkins@build.example.net": true,
+		"anyone@example.com":        true,
+		"anyone@notexample.com":     false,
+		"other@build.example.net":   false,
+	} {
+		if got := allowed(list, address); got != want {
+			t.Errorf("allowed(%q) = %v, want %v", address, got, want)
+		}
+	}
+
+	if !allowed(nil, "anyone@anywhere.net") {
+		t.Error("an empty list should allow everything")
+	}
+}